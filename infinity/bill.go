@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"infinity/billing"
+	"infinity/models"
+	"infinity/store"
+	"log"
+	"os"
+	"time"
+)
+
+// billingInterval reads BILLING_INTERVAL (a Go duration such as "1h"), the time
+// between billing runs in the server. Unset, the server doesn't bill at all
+func billingInterval() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("BILLING_INTERVAL"))
+	if err != nil || d <= 0 {
+		return 0
+	}
+	return d
+}
+
+// runBillingLoop expires or renews subscriptions past their end date and bills
+// due ones every interval for as long as the server runs
+func runBillingLoop(engine *billing.Engine, interval time.Duration) {
+	for range time.Tick(interval) {
+		expiry, err := engine.Expire(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("expiry run failed after %d expiries and %d renewals: %v", len(expiry.Expired), len(expiry.Renewed), err)
+		} else {
+			log.Printf("expiry run expired %d subscriptions, renewed %d, charged %d renewals and skipped %d",
+				len(expiry.Expired), len(expiry.Renewed), len(expiry.Charges), len(expiry.Skipped))
+		}
+
+		report, err := engine.Run(context.Background(), time.Now())
+		if err != nil {
+			log.Printf("billing run failed after %d charges: %v", len(report.Charges), err)
+			continue
+		}
+		log.Printf("billing run charged %d subscriptions and skipped %d", len(report.Charges), len(report.Skipped))
+	}
+}
+
+// runBill implements `infinity bill [-dry-run [-date YYYY-MM-DD]]`: a single billing
+// run as of now, or a simulation of the run for the end of a date that charges nothing
+func runBill(db *sql.DB, args []string) error {
+	flags := flag.NewFlagSet("bill", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "show what would be charged without charging it")
+	date := flags.String("date", "", "simulate the run for this YYYY-MM-DD date; requires -dry-run")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("usage: infinity bill [-dry-run [-date YYYY-MM-DD]]")
+	}
+	if *date != "" && !*dryRun {
+		return fmt.Errorf("-date only applies to a -dry-run; a real run always bills as of now")
+	}
+
+	engine := billing.NewEngine(store.NewPostgres(db))
+	if !*dryRun {
+		report, err := engine.Run(context.Background(), time.Now())
+		printReport(report)
+		return err
+	}
+
+	at := time.Now()
+	if *date != "" {
+		var err error
+		if at, err = billing.ParseDate(*date); err != nil {
+			return err
+		}
+	}
+	report, err := engine.Simulate(context.Background(), at)
+	if err != nil {
+		return err
+	}
+	printReport(report)
+	return nil
+}
+
+// runExpire implements `infinity expire`: a single expiry run as of now
+func runExpire(db *sql.DB, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: infinity expire")
+	}
+	report, err := billing.NewEngine(store.NewPostgres(db)).Expire(context.Background(), time.Now())
+	printExpiryReport(report)
+	return err
+}
+
+// printExpiryReport lists what an expiry run changed, one subscription per line
+func printExpiryReport(report billing.ExpiryReport) {
+	for _, e := range report.Expired {
+		fmt.Printf("expired subscription %d of partner %d, %s since %s\n", e.SubscriptionID, e.PartnerID, e.FromStatus, e.EndDate.Format("2006-01-02"))
+	}
+	for _, r := range report.Renewed {
+		fmt.Printf("renewed subscription %d of partner %d from %s to %s\n", r.SubscriptionID, r.PartnerID,
+			r.PreviousEndDate.Format("2006-01-02"), r.EndDate.Format("2006-01-02"))
+	}
+	for _, c := range report.Charges {
+		fmt.Printf("charged subscription %d of partner %d %s %s to renew from %s, transaction %d\n", c.SubscriptionID, c.PartnerID,
+			c.Currency, models.FormatMoney(c.Amount, c.Currency), c.Period.Format("2006-01-02"), c.TransactionID)
+	}
+	for _, s := range report.Skipped {
+		fmt.Printf("skipped subscription %d of partner %d: %s\n", s.SubscriptionID, s.PartnerID, s.Reason)
+	}
+	fmt.Printf("expired %d, renewed %d, charged %d renewals, skipped %d\n", len(report.Expired), len(report.Renewed), len(report.Charges), len(report.Skipped))
+}
+
+// printReport lists what a billing run did, one subscription per line, and the totals
+func printReport(report billing.Report) {
+	verb := "charged"
+	if report.DryRun {
+		verb = "would charge"
+	}
+	for _, c := range report.Charges {
+		fmt.Printf("%s subscription %d of partner %d %s %s for %s", verb,
+			c.SubscriptionID, c.PartnerID, c.Currency, models.FormatMoney(c.Amount, c.Currency), c.Period.Format("2006-01-02"))
+		if c.Retry {
+			fmt.Print(" (retry)")
+		}
+		if c.TransactionID != 0 {
+			fmt.Printf(", transaction %d", c.TransactionID)
+		}
+		fmt.Println()
+	}
+	for _, s := range report.Skipped {
+		fmt.Printf("skipped subscription %d of partner %d: %s\n", s.SubscriptionID, s.PartnerID, s.Reason)
+	}
+
+	byPartner, byCurrency := report.Totals()
+	for _, t := range byPartner {
+		fmt.Printf("partner %d: %d charges, %s %s\n", t.PartnerID, t.Charges, t.Currency, models.FormatMoney(t.Amount, t.Currency))
+	}
+	for _, t := range byCurrency {
+		fmt.Printf("total: %d charges, %s %s\n", t.Charges, t.Currency, models.FormatMoney(t.Amount, t.Currency))
+	}
+	fmt.Printf("%s %d, skipped %d\n", verb, len(report.Charges), len(report.Skipped))
+}
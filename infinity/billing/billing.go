@@ -0,0 +1,241 @@
+// Package billing charges subscriptions as their billing cycles come round
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"infinity/models"
+	"infinity/store"
+	"sort"
+	"time"
+)
+
+// Reasons a subscription isn't charged in a run. A subscription that isn't
+// active is skipped with its status as the reason
+const (
+	SkipNotStarted    = "not_started"
+	SkipTrial         = "trial"
+	SkipAlreadyBilled = "already_billed"
+	// SkipExpired is also given to an active subscription whose end date has passed
+	SkipExpired = models.SubscriptionExpired
+	// SkipChanged is a subscription that another run billed, or that changed
+	// status, between being listed and being charged
+	SkipChanged = "changed"
+)
+
+// pageSize is how many subscriptions a run reads at a time
+const pageSize = 500
+
+// Charge is one billing period a run charged, or charged again as a dunning
+// retry. A simulated charge has no transaction
+type Charge struct {
+	SubscriptionID int
+	PartnerID      int
+	Period         time.Time
+	Amount         models.Money
+	Currency       string
+	Retry          bool
+	TransactionID  int
+}
+
+// Skip is a subscription a run didn't charge, and why
+type Skip struct {
+	SubscriptionID int
+	PartnerID      int
+	Reason         string
+}
+
+// Report is the outcome of one run. Skipped lists the subscriptions that were
+// due but couldn't be charged; those with nothing due aren't looked at
+type Report struct {
+	At      time.Time
+	DryRun  bool
+	Charges []Charge
+	Skipped []Skip
+}
+
+// Total sums the charges of one currency, for one partner or for everyone
+type Total struct {
+	PartnerID int
+	Currency  string
+	Charges   int
+	Amount    models.Money
+}
+
+// Totals sums the charges per partner and currency, ordered by partner, and per
+// currency across partners. Amounts in different currencies are never added up
+func (r Report) Totals() (byPartner, byCurrency []Total) {
+	type key struct {
+		partnerID int
+		currency  string
+	}
+	partners := make(map[key]*Total)
+	currencies := make(map[string]*Total)
+	for _, c := range r.Charges {
+		k := key{c.PartnerID, c.Currency}
+		if partners[k] == nil {
+			partners[k] = &Total{PartnerID: c.PartnerID, Currency: c.Currency}
+		}
+		if currencies[c.Currency] == nil {
+			currencies[c.Currency] = &Total{Currency: c.Currency}
+		}
+		for _, t := range []*Total{partners[k], currencies[c.Currency]} {
+			t.Charges++
+			t.Amount += c.Amount
+		}
+	}
+
+	for _, t := range partners {
+		byPartner = append(byPartner, *t)
+	}
+	sort.Slice(byPartner, func(i, j int) bool {
+		if byPartner[i].PartnerID != byPartner[j].PartnerID {
+			return byPartner[i].PartnerID < byPartner[j].PartnerID
+		}
+		return byPartner[i].Currency < byPartner[j].Currency
+	})
+	for _, t := range currencies {
+		byCurrency = append(byCurrency, *t)
+	}
+	sort.Slice(byCurrency, func(i, j int) bool { return byCurrency[i].Currency < byCurrency[j].Currency })
+	return byPartner, byCurrency
+}
+
+// ParseDate reads a YYYY-MM-DD date to run the engine for. The run happens at
+// the last instant of that UTC day, so it bills everything due on the date
+func ParseDate(s string) (time.Time, error) {
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("date must be a YYYY-MM-DD date")
+	}
+	return d.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+}
+
+// Engine bills live subscriptions whose next billing date has come
+type Engine struct {
+	subscriptions store.SubscriptionStore
+}
+
+// NewEngine returns an engine that bills through stores
+func NewEngine(stores store.Stores) *Engine {
+	return &Engine{subscriptions: stores.Subscriptions}
+}
+
+// Run charges every active subscription due at at for its current period and
+// moves its next billing date on by one cycle, and retries the failed charges
+// whose dunning retry is due. Running again for the same time charges nothing
+// more, so a run that failed halfway can simply be repeated
+func (e *Engine) Run(ctx context.Context, at time.Time) (Report, error) {
+	return e.run(ctx, at, false)
+}
+
+// Simulate works out what Run would charge at at without writing anything
+func (e *Engine) Simulate(ctx context.Context, at time.Time) (Report, error) {
+	return e.run(ctx, at, true)
+}
+
+func (e *Engine) run(ctx context.Context, at time.Time, dryRun bool) (Report, error) {
+	report := Report{At: at, DryRun: dryRun}
+	err := e.each(ctx, store.SubscriptionFilter{DueBy: at}, func(sub models.Subscriptions) error {
+		retried := false
+		if retryDue(sub, at) {
+			charge := Charge{SubscriptionID: sub.ID, PartnerID: sub.PartnerID, Period: *sub.DunningPeriod, Amount: sub.BillingAmount, Currency: sub.Currency, Retry: true}
+			if !dryRun {
+				t, err := e.subscriptions.Retry(ctx, sub.ID, at)
+				if errors.Is(err, store.ErrConflict) {
+					report.Skipped = append(report.Skipped, Skip{sub.ID, sub.PartnerID, SkipChanged})
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				charge.Amount, charge.Currency, charge.TransactionID = t.Amount, t.Currency, t.ID
+			}
+			report.Charges = append(report.Charges, charge)
+			retried = true
+		}
+
+		period, next, reason := plan(sub, at)
+		if reason != "" {
+			if !retried {
+				report.Skipped = append(report.Skipped, Skip{sub.ID, sub.PartnerID, reason})
+			}
+			return nil
+		}
+		charge := Charge{SubscriptionID: sub.ID, PartnerID: sub.PartnerID, Period: period, Amount: sub.BillingAmount, Currency: sub.Currency}
+		if !dryRun {
+			t, err := e.subscriptions.Bill(ctx, sub.ID, period, next, at)
+			if errors.Is(err, store.ErrConflict) {
+				report.Skipped = append(report.Skipped, Skip{sub.ID, sub.PartnerID, SkipChanged})
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			charge.Amount, charge.Currency, charge.TransactionID = t.Amount, t.Currency, t.ID
+		}
+		report.Charges = append(report.Charges, charge)
+		return nil
+	})
+	return report, err
+}
+
+// retryDue reports whether the failed charge of sub is due to be retried at at.
+// Suspended subscriptions are retried too, since a successful retry reactivates them
+func retryDue(sub models.Subscriptions, at time.Time) bool {
+	if sub.DunningState != models.DunningRetrying || sub.NextAttemptAt == nil || sub.NextAttemptAt.After(at) || sub.DunningPeriod == nil {
+		return false
+	}
+	return sub.Status == models.SubscriptionActive || sub.Status == models.SubscriptionSuspended
+}
+
+// each calls fn with every live subscription f keeps in ID order, a page at a time
+func (e *Engine) each(ctx context.Context, f store.SubscriptionFilter, fn func(models.Subscriptions) error) error {
+	page := store.Page{Limit: pageSize}
+	for {
+		subs, next, err := e.subscriptions.List(ctx, f, page)
+		if err != nil {
+			return err
+		}
+		for _, sub := range subs {
+			if err := fn(sub); err != nil {
+				return err
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		page.After = next
+	}
+}
+
+// plan works out what a run at at does with sub: the period to charge and the
+// billing date after it, or the reason to skip it. The period is the one at falls
+// in; periods that passed unbilled, such as while the subscription was suspended,
+// are never charged in arrears
+func plan(sub models.Subscriptions, at time.Time) (period, next time.Time, skip string) {
+	if sub.Status != models.SubscriptionActive {
+		return period, next, sub.Status
+	}
+	if sub.NextBillingDate.After(at) {
+		if sub.StartDate.After(at) {
+			return period, next, SkipNotStarted
+		}
+		if sub.TrialEndsAt != nil && sub.TrialEndsAt.After(at) {
+			return period, next, SkipTrial
+		}
+		return period, next, SkipAlreadyBilled
+	}
+
+	anchor := sub.BillingAnchorDay()
+	period = sub.NextBillingDate
+	next = models.AdvanceBillingDate(sub.BillingCycle, period, anchor)
+	for !next.After(at) {
+		period, next = next, models.AdvanceBillingDate(sub.BillingCycle, next, anchor)
+	}
+	if !period.Before(sub.EndDate) {
+		return period, next, SkipExpired
+	}
+	return period, next, ""
+}
@@ -0,0 +1,222 @@
+package billing
+
+import (
+	"context"
+	"infinity/models"
+	"infinity/store"
+	"testing"
+	"time"
+)
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+// testEngine is an engine over in-memory stores holding one partner
+type testEngine struct {
+	*Engine
+	stores    store.Stores
+	partnerID int
+}
+
+func newTestEngine(t *testing.T) *testEngine {
+	t.Helper()
+	stores := store.NewMemory()
+	p := models.Partner{Name: "a", Email: "a@example.com"}
+	p.SetDunningDefaults()
+	if err := stores.Partners.Create(context.Background(), &p); err != nil {
+		t.Fatal(err)
+	}
+	return &testEngine{Engine: NewEngine(stores), stores: stores, partnerID: p.ID}
+}
+
+// subscribe creates an active subscription of the partner billed every cycle from start
+func (e *testEngine) subscribe(t *testing.T, cycle string, start, end time.Time, trialEndsAt *time.Time) models.Subscriptions {
+	t.Helper()
+	sub := models.Subscriptions{
+		PartnerID:        e.partnerID,
+		CustomerMSISDN:   "+254700000001",
+		SubscriptionDate: start,
+		Status:           models.SubscriptionActive,
+		BillingAmount:    1050,
+		Currency:         "KES",
+		BillingCycle:     cycle,
+		StartDate:        start,
+		EndDate:          end,
+		TrialEndsAt:      trialEndsAt,
+	}
+	if err := e.stores.Subscriptions.Create(context.Background(), &sub); err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+func (e *testEngine) get(t *testing.T, id int) models.Subscriptions {
+	t.Helper()
+	sub, err := e.stores.Subscriptions.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+// run runs the engine at at and returns the periods it charged
+func (e *testEngine) run(t *testing.T, at time.Time) []time.Time {
+	t.Helper()
+	report, err := e.Run(context.Background(), at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var periods []time.Time
+	for _, c := range report.Charges {
+		periods = append(periods, c.Period)
+	}
+	return periods
+}
+
+func TestPlan(t *testing.T) {
+	trialEnd := day(2026, 1, 15)
+	active := func(cycle string, start, next time.Time) models.Subscriptions {
+		return models.Subscriptions{
+			Status:          models.SubscriptionActive,
+			BillingCycle:    cycle,
+			StartDate:       start,
+			EndDate:         day(2027, 1, 1),
+			NextBillingDate: next,
+		}
+	}
+	inTrial := active("monthly", day(2026, 1, 1), trialEnd)
+	inTrial.TrialEndsAt = &trialEnd
+	suspended := active("monthly", day(2026, 1, 1), day(2026, 1, 1))
+	suspended.Status = models.SubscriptionSuspended
+	ending := active("monthly", day(2026, 1, 1), day(2027, 1, 1))
+
+	tests := []struct {
+		name       string
+		sub        models.Subscriptions
+		at         time.Time
+		wantPeriod time.Time
+		wantNext   time.Time
+		wantSkip   string
+	}{
+		{"due on the day", active("monthly", day(2026, 1, 1), day(2026, 1, 1)), day(2026, 1, 1), day(2026, 1, 1), day(2026, 2, 1), ""},
+		{"due later in the period", active("monthly", day(2026, 1, 1), day(2026, 1, 1)), day(2026, 1, 20), day(2026, 1, 1), day(2026, 2, 1), ""},
+		{"missed periods aren't charged in arrears", active("monthly", day(2026, 1, 1), day(2026, 1, 1)), day(2026, 4, 10), day(2026, 4, 1), day(2026, 5, 1), ""},
+		{"Jan 31 bills Feb 28 next", active("monthly", day(2026, 1, 31), day(2026, 1, 31)), day(2026, 1, 31), day(2026, 1, 31), day(2026, 2, 28), ""},
+		{"Feb 28 keeps the 31st anchor", active("monthly", day(2026, 1, 31), day(2026, 2, 28)), day(2026, 2, 28), day(2026, 2, 28), day(2026, 3, 31), ""},
+		{"weekly", active("weekly", day(2026, 1, 1), day(2026, 1, 8)), day(2026, 1, 9), day(2026, 1, 8), day(2026, 1, 15), ""},
+		{"trial ended", inTrial, trialEnd, trialEnd, day(2026, 2, 15), ""},
+		{"in trial", inTrial, day(2026, 1, 10), time.Time{}, time.Time{}, SkipTrial},
+		{"not started", active("monthly", day(2026, 2, 1), day(2026, 2, 1)), day(2026, 1, 10), time.Time{}, time.Time{}, SkipNotStarted},
+		{"already billed", active("monthly", day(2026, 1, 1), day(2026, 2, 1)), day(2026, 1, 20), time.Time{}, time.Time{}, SkipAlreadyBilled},
+		{"past the end date", ending, day(2027, 1, 1), day(2027, 1, 1), day(2027, 2, 1), SkipExpired},
+		{"not active", suspended, day(2026, 1, 1), time.Time{}, time.Time{}, models.SubscriptionSuspended},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			period, next, skip := plan(tt.sub, tt.at)
+			if skip != tt.wantSkip {
+				t.Fatalf("got skip %q, want %q", skip, tt.wantSkip)
+			}
+			if !period.Equal(tt.wantPeriod) || !next.Equal(tt.wantNext) {
+				t.Errorf("got period %v next %v, want %v and %v", period, next, tt.wantPeriod, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestRunBillsMonthEndOnTheAnchorDay(t *testing.T) {
+	e := newTestEngine(t)
+	sub := e.subscribe(t, "monthly", day(2026, 1, 31), day(2027, 1, 31), nil)
+
+	want := []time.Time{day(2026, 1, 31), day(2026, 2, 28), day(2026, 3, 31), day(2026, 4, 30)}
+	var got []time.Time
+	for at := day(2026, 1, 31); at.Before(day(2026, 5, 15)); at = at.AddDate(0, 0, 1) {
+		got = append(got, e.run(t, at)...)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got periods %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("charge %d: got period %v, want %v", i, got[i], want[i])
+		}
+	}
+	if next := e.get(t, sub.ID).NextBillingDate; !next.Equal(day(2026, 5, 31)) {
+		t.Errorf("got next billing date %v, want 2026-05-31", next)
+	}
+}
+
+func TestRunWaitsForTheTrial(t *testing.T) {
+	e := newTestEngine(t)
+	trialEnd := day(2026, 1, 15)
+	sub := e.subscribe(t, "monthly", day(2026, 1, 1), day(2027, 1, 1), &trialEnd)
+
+	if got := e.run(t, day(2026, 1, 14)); len(got) != 0 {
+		t.Fatalf("charged %v during the trial", got)
+	}
+	got := e.run(t, trialEnd)
+	if len(got) != 1 || !got[0].Equal(trialEnd) {
+		t.Fatalf("got periods %v, want the day the trial ends", got)
+	}
+	// billing keeps to the day the trial ended
+	if next := e.get(t, sub.ID).NextBillingDate; !next.Equal(day(2026, 2, 15)) {
+		t.Errorf("got next billing date %v, want 2026-02-15", next)
+	}
+}
+
+func TestRunIsRepeatable(t *testing.T) {
+	e := newTestEngine(t)
+	e.subscribe(t, "weekly", day(2026, 1, 1), day(2027, 1, 1), nil)
+	at := day(2026, 1, 1)
+
+	if got := e.run(t, at); len(got) != 1 {
+		t.Fatalf("got %d charges, want 1", len(got))
+	}
+	if got := e.run(t, at); len(got) != 0 {
+		t.Errorf("a repeated run charged %v again", got)
+	}
+}
+
+func TestRunReportsOnlyDueSubscriptions(t *testing.T) {
+	e := newTestEngine(t)
+	trialEnd := day(2026, 1, 15)
+	due := e.subscribe(t, "monthly", day(2026, 1, 1), day(2027, 1, 1), nil)
+	e.subscribe(t, "monthly", day(2026, 2, 1), day(2027, 2, 1), nil)
+	e.subscribe(t, "monthly", day(2026, 1, 1), day(2027, 1, 1), &trialEnd)
+	suspended := e.subscribe(t, "monthly", day(2026, 1, 1), day(2027, 1, 1), nil)
+	change := &models.SubscriptionStatusChange{FromStatus: models.SubscriptionActive, ToStatus: models.SubscriptionSuspended, Reason: "fraud check", Actor: "test"}
+	if _, err := e.stores.Subscriptions.Transition(context.Background(), suspended.ID, 0, change); err != nil {
+		t.Fatal(err)
+	}
+	ended := e.subscribe(t, "monthly", day(2025, 1, 1), day(2026, 1, 1), nil)
+	e.run(t, day(2025, 12, 1))
+
+	report, err := e.Run(context.Background(), day(2026, 1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Charges) != 1 || report.Charges[0].SubscriptionID != due.ID {
+		t.Errorf("got charges %+v, want subscription %d charged", report.Charges, due.ID)
+	}
+	// the subscriptions that aren't due yet, or at all, are left out of the report
+	if len(report.Skipped) != 1 || report.Skipped[0] != (Skip{ended.ID, e.partnerID, SkipExpired}) {
+		t.Errorf("got skips %+v, want only subscription %d skipped as expired", report.Skipped, ended.ID)
+	}
+}
+
+func TestSimulateWritesNothing(t *testing.T) {
+	e := newTestEngine(t)
+	sub := e.subscribe(t, "monthly", day(2026, 1, 1), day(2027, 1, 1), nil)
+
+	report, err := e.Simulate(context.Background(), day(2026, 1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Charges) != 1 || report.Charges[0].TransactionID != 0 {
+		t.Fatalf("got charges %+v, want one without a transaction", report.Charges)
+	}
+	if next := e.get(t, sub.ID).NextBillingDate; !next.Equal(day(2026, 1, 1)) {
+		t.Errorf("a simulation moved the next billing date to %v", next)
+	}
+}
@@ -0,0 +1,224 @@
+package billing
+
+import (
+	"context"
+	"infinity/models"
+	"testing"
+	"time"
+)
+
+func TestRetryDue(t *testing.T) {
+	at := day(2026, 1, 2)
+	period := day(2026, 1, 1)
+	retrying := func(status string, next time.Time) models.Subscriptions {
+		return models.Subscriptions{Status: status, DunningState: models.DunningRetrying, NextAttemptAt: &next, DunningPeriod: &period}
+	}
+	awaiting := retrying(models.SubscriptionActive, at)
+	awaiting.NextAttemptAt = nil
+	exhausted := retrying(models.SubscriptionSuspended, at)
+	exhausted.DunningState = models.DunningExhausted
+
+	tests := []struct {
+		name string
+		sub  models.Subscriptions
+		want bool
+	}{
+		{"due", retrying(models.SubscriptionActive, at), true},
+		{"overdue", retrying(models.SubscriptionActive, at.Add(-time.Hour)), true},
+		{"not yet", retrying(models.SubscriptionActive, at.Add(time.Nanosecond)), false},
+		{"suspended", retrying(models.SubscriptionSuspended, at), true},
+		{"cancelled", retrying(models.SubscriptionCancelled, at), false},
+		{"waiting on the retry", awaiting, false},
+		{"exhausted", exhausted, false},
+		{"current", models.Subscriptions{Status: models.SubscriptionActive, DunningState: models.DunningCurrent}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryDue(tt.sub, at); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// settle moves the billing charge from pending to status, as a payment callback would
+func (e *testEngine) settle(t *testing.T, id int, status string) {
+	t.Helper()
+	ctx := context.Background()
+	tr, err := e.stores.Transactions.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Status = status
+	if err := e.stores.Transactions.Patch(ctx, &tr, []string{"status"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// retry runs the engine once the subscription's retry is due and returns the retry it charged
+func (e *testEngine) retry(t *testing.T, id int) Charge {
+	t.Helper()
+	sub := e.get(t, id)
+	if sub.NextAttemptAt == nil {
+		t.Fatalf("subscription %d has no retry scheduled", id)
+	}
+	ctx := context.Background()
+	early, err := e.Run(ctx, sub.NextAttemptAt.Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(early.Charges) != 0 {
+		t.Fatalf("charged %+v before the retry was due", early.Charges)
+	}
+	report, err := e.Run(ctx, *sub.NextAttemptAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Charges) != 1 || !report.Charges[0].Retry {
+		t.Fatalf("got charges %+v, want one retry", report.Charges)
+	}
+	return report.Charges[0]
+}
+
+func TestDunningSuspendsAndReactivates(t *testing.T) {
+	e := newTestEngine(t)
+	// the term ends before the retries come round, so only retries are charged
+	sub := e.subscribe(t, "monthly", day(2026, 1, 1), day(2026, 1, 31), nil)
+
+	report, err := e.Run(context.Background(), day(2026, 1, 1))
+	if err != nil || len(report.Charges) != 1 {
+		t.Fatalf("got %+v, %v; want one charge", report.Charges, err)
+	}
+	e.settle(t, report.Charges[0].TransactionID, models.TransactionFailed)
+
+	// the partner suspends after three failures in a row
+	for attempt := 2; attempt <= 3; attempt++ {
+		charge := e.retry(t, sub.ID)
+		if !charge.Period.Equal(day(2026, 1, 1)) {
+			t.Errorf("retry %d charged period %v, want the failed one", attempt, charge.Period)
+		}
+		tr, err := e.stores.Transactions.Get(context.Background(), charge.TransactionID)
+		if err != nil || tr.Attempt != attempt {
+			t.Fatalf("got attempt %d, %v; want %d", tr.Attempt, err, attempt)
+		}
+		e.settle(t, charge.TransactionID, models.TransactionFailed)
+	}
+	got := e.get(t, sub.ID)
+	if got.Status != models.SubscriptionSuspended || got.FailedAttempts != 3 || got.DunningState != models.DunningRetrying {
+		t.Fatalf("got %s, %d failures, %s; want suspended after 3 and still retrying", got.Status, got.FailedAttempts, got.DunningState)
+	}
+
+	// a suspended subscription is still retried, and paying reactivates it
+	e.settle(t, e.retry(t, sub.ID).TransactionID, models.TransactionSucceeded)
+	got = e.get(t, sub.ID)
+	if got.Status != models.SubscriptionActive || got.FailedAttempts != 0 || got.DunningState != models.DunningCurrent || got.NextAttemptAt != nil {
+		t.Errorf("got %s, %d failures, %s, next attempt %v; want active and current", got.Status, got.FailedAttempts, got.DunningState, got.NextAttemptAt)
+	}
+
+	history, err := e.stores.Subscriptions.History(context.Background(), sub.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0].ToStatus != models.SubscriptionSuspended || history[1].ToStatus != models.SubscriptionActive {
+		t.Fatalf("got history %+v, want a suspension and a reactivation", history)
+	}
+	for _, h := range history {
+		if h.Actor != models.DunningActor {
+			t.Errorf("got actor %q, want %q", h.Actor, models.DunningActor)
+		}
+	}
+}
+
+func TestDunningExhaustsTheSchedule(t *testing.T) {
+	e := newTestEngine(t)
+	ctx := context.Background()
+	p, err := e.stores.Partners.Get(ctx, e.partnerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.RetrySchedule, p.SuspendAfter = models.RetrySchedule{time.Hour}, 5
+	if err := e.stores.Partners.Update(ctx, &p); err != nil {
+		t.Fatal(err)
+	}
+	sub := e.subscribe(t, "monthly", day(2026, 1, 1), day(2026, 1, 31), nil)
+
+	report, err := e.Run(ctx, day(2026, 1, 1))
+	if err != nil || len(report.Charges) != 1 {
+		t.Fatalf("got %+v, %v; want one charge", report.Charges, err)
+	}
+	e.settle(t, report.Charges[0].TransactionID, models.TransactionFailed)
+	e.settle(t, e.retry(t, sub.ID).TransactionID, models.TransactionFailed)
+
+	// with no retry left it suspends before reaching suspend_after
+	got := e.get(t, sub.ID)
+	if got.DunningState != models.DunningExhausted || got.NextAttemptAt != nil || got.Status != models.SubscriptionSuspended {
+		t.Fatalf("got %s, next attempt %v, %s; want exhausted and suspended", got.DunningState, got.NextAttemptAt, got.Status)
+	}
+	report, err = e.Run(ctx, day(2030, 1, 1))
+	if err != nil || len(report.Charges) != 0 {
+		t.Errorf("got %+v, %v; want nothing charged once exhausted", report.Charges, err)
+	}
+}
+
+func TestManualTransitionsClearDunning(t *testing.T) {
+	for _, action := range []string{"resume", "cancel"} {
+		t.Run(action, func(t *testing.T) {
+			e := newTestEngine(t)
+			ctx := context.Background()
+			sub := e.subscribe(t, "monthly", day(2026, 1, 1), day(2026, 1, 31), nil)
+			report, err := e.Run(ctx, day(2026, 1, 1))
+			if err != nil || len(report.Charges) != 1 {
+				t.Fatalf("got %+v, %v; want one charge", report.Charges, err)
+			}
+			e.settle(t, report.Charges[0].TransactionID, models.TransactionFailed)
+			for i := 0; i < 2; i++ {
+				e.settle(t, e.retry(t, sub.ID).TransactionID, models.TransactionFailed)
+			}
+			got := e.get(t, sub.ID)
+			if got.Status != models.SubscriptionSuspended || got.NextAttemptAt == nil {
+				t.Fatalf("got %s with next attempt %v, want suspended and retrying", got.Status, got.NextAttemptAt)
+			}
+
+			to, err := models.SubscriptionTransition(action, got.Status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			change := &models.SubscriptionStatusChange{FromStatus: got.Status, ToStatus: to, Reason: "settled by phone", Actor: "admin"}
+			if _, err := e.stores.Subscriptions.Transition(ctx, sub.ID, got.Version, change); err != nil {
+				t.Fatal(err)
+			}
+			got = e.get(t, sub.ID)
+			if got.DunningState != models.DunningCurrent || got.FailedAttempts != 0 || got.NextAttemptAt != nil || got.DunningPeriod != nil {
+				t.Errorf("got %s, %d failures, next attempt %v, period %v; want dunning cleared",
+					got.DunningState, got.FailedAttempts, got.NextAttemptAt, got.DunningPeriod)
+			}
+
+			// nothing is retried afterwards
+			if report, err := e.Run(ctx, day(2026, 3, 1)); err != nil || len(report.Charges) != 0 {
+				t.Errorf("got %+v, %v; want no retry once dunning is cleared", report.Charges, err)
+			}
+		})
+	}
+}
+
+func TestManualSuspendKeepsDunning(t *testing.T) {
+	e := newTestEngine(t)
+	ctx := context.Background()
+	sub := e.subscribe(t, "monthly", day(2026, 1, 1), day(2026, 1, 31), nil)
+	report, err := e.Run(ctx, day(2026, 1, 1))
+	if err != nil || len(report.Charges) != 1 {
+		t.Fatalf("got %+v, %v; want one charge", report.Charges, err)
+	}
+	e.settle(t, report.Charges[0].TransactionID, models.TransactionFailed)
+
+	got := e.get(t, sub.ID)
+	change := &models.SubscriptionStatusChange{FromStatus: got.Status, ToStatus: models.SubscriptionSuspended, Actor: "admin"}
+	if _, err := e.stores.Subscriptions.Transition(ctx, sub.ID, got.Version, change); err != nil {
+		t.Fatal(err)
+	}
+	// a suspended subscription still owes the charge, so it is still retried
+	if got := e.get(t, sub.ID); got.DunningState != models.DunningRetrying || got.FailedAttempts != 1 {
+		t.Fatalf("got %s with %d failures, want the retry kept", got.DunningState, got.FailedAttempts)
+	}
+	e.retry(t, sub.ID)
+}
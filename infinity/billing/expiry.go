@@ -0,0 +1,93 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"infinity/models"
+	"infinity/store"
+	"time"
+)
+
+// ExpiryActor is the actor of the status changes an expiry run makes
+const ExpiryActor = "expiry"
+
+// SkipAwaitingRenewal is an auto-renewing subscription past its end date whose
+// renewal charge hasn't succeeded yet. It stays as it is while the charge is
+// pending or being retried, and expires once dunning suspends it
+const SkipAwaitingRenewal = "awaiting_renewal"
+
+// Expiry is a subscription a run expired, with the status it had
+type Expiry struct {
+	SubscriptionID int
+	PartnerID      int
+	FromStatus     string
+	EndDate        time.Time
+}
+
+// Renewal is an auto-renewing subscription a run extended by one cycle
+type Renewal struct {
+	SubscriptionID  int
+	PartnerID       int
+	PreviousEndDate time.Time
+	EndDate         time.Time
+}
+
+// ExpiryReport is the outcome of one expiry run. Charges are the renewal charges
+// the run made for the first period of a new term
+type ExpiryReport struct {
+	At      time.Time
+	Expired []Expiry
+	Renewed []Renewal
+	Charges []Charge
+	Skipped []Skip
+}
+
+// Expire ends every subscription whose end date has passed by at. One that renews
+// automatically and is active is charged for the first period of its next term
+// instead, and extended by one billing cycle by the first run after that charge
+// succeeds. Like Run it can simply be repeated
+func (e *Engine) Expire(ctx context.Context, at time.Time) (ExpiryReport, error) {
+	report := ExpiryReport{At: at}
+	err := e.each(ctx, store.SubscriptionFilter{EndedBy: at}, func(sub models.Subscriptions) error {
+		if sub.AutoRenew && sub.Status == models.SubscriptionActive {
+			next := models.RenewedEndDate(sub)
+			_, err := e.subscriptions.Renew(ctx, sub.ID, sub.EndDate, next)
+			if err == nil {
+				report.Renewed = append(report.Renewed, Renewal{sub.ID, sub.PartnerID, sub.EndDate, next})
+				return nil
+			}
+			if !errors.Is(err, store.ErrConflict) {
+				return err
+			}
+			// the renewal isn't paid for yet: charge it, or wait on the charge made before
+			t, err := e.subscriptions.BillRenewal(ctx, sub.ID, sub.EndDate, next, at)
+			if errors.Is(err, store.ErrConflict) {
+				report.Skipped = append(report.Skipped, Skip{sub.ID, sub.PartnerID, SkipAwaitingRenewal})
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			report.Charges = append(report.Charges, Charge{SubscriptionID: sub.ID, PartnerID: sub.PartnerID, Period: sub.EndDate, Amount: t.Amount, Currency: t.Currency, TransactionID: t.ID})
+			return nil
+		}
+
+		change := &models.SubscriptionStatusChange{
+			FromStatus: sub.Status,
+			ToStatus:   models.SubscriptionExpired,
+			Reason:     "the end date passed",
+			Actor:      ExpiryActor,
+		}
+		_, err := e.subscriptions.Transition(ctx, sub.ID, sub.Version, change)
+		if errors.Is(err, store.ErrConflict) || errors.Is(err, store.ErrVersionMismatch) {
+			report.Skipped = append(report.Skipped, Skip{sub.ID, sub.PartnerID, SkipChanged})
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		report.Expired = append(report.Expired, Expiry{sub.ID, sub.PartnerID, sub.Status, sub.EndDate})
+		return nil
+	})
+	return report, err
+}
@@ -0,0 +1,189 @@
+package billing
+
+import (
+	"context"
+	"infinity/models"
+	"testing"
+	"time"
+)
+
+// renewing subscribes monthly from start to end with auto_renew on
+func (e *testEngine) renewing(t *testing.T, start, end time.Time) models.Subscriptions {
+	t.Helper()
+	sub := e.subscribe(t, "monthly", start, end, nil)
+	sub.AutoRenew = true
+	if err := e.stores.Subscriptions.Patch(context.Background(), &sub, []string{"auto_renew"}); err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+// billThrough runs billing every day from from until to and settles each charge with status
+func (e *testEngine) billThrough(t *testing.T, from, to time.Time, status string) {
+	t.Helper()
+	for at := from; !at.After(to); at = at.AddDate(0, 0, 1) {
+		report, err := e.Run(context.Background(), at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range report.Charges {
+			e.settle(t, c.TransactionID, status)
+		}
+	}
+}
+
+func (e *testEngine) expire(t *testing.T, at time.Time) ExpiryReport {
+	t.Helper()
+	report, err := e.Expire(context.Background(), at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestExpireEndsTheTerm(t *testing.T) {
+	e := newTestEngine(t)
+	sub := e.subscribe(t, "monthly", day(2026, 1, 1), day(2026, 4, 1), nil)
+
+	// a subscription that hasn't ended isn't looked at, let alone reported
+	if report := e.expire(t, day(2026, 3, 31)); len(report.Expired)+len(report.Skipped) != 0 {
+		t.Fatalf("got %+v before the end date", report)
+	}
+	report := e.expire(t, day(2026, 4, 1))
+	if len(report.Expired) != 1 || report.Expired[0].SubscriptionID != sub.ID || report.Expired[0].FromStatus != models.SubscriptionActive {
+		t.Fatalf("got %+v, want the subscription expired from active", report.Expired)
+	}
+	if got := e.get(t, sub.ID).Status; got != models.SubscriptionExpired {
+		t.Errorf("got status %s, want expired", got)
+	}
+
+	history, err := e.stores.Subscriptions.History(context.Background(), sub.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].FromStatus != models.SubscriptionActive || history[0].ToStatus != models.SubscriptionExpired || history[0].Actor != ExpiryActor {
+		t.Errorf("got history %+v, want active to expired by %s", history, ExpiryActor)
+	}
+
+	// a repeated run has nothing left to do
+	if report := e.expire(t, day(2026, 4, 1)); len(report.Expired)+len(report.Renewed)+len(report.Skipped) != 0 {
+		t.Errorf("a repeated run reported %+v", report)
+	}
+}
+
+// renewalCharge runs an expiry at at and returns the renewal charge it made
+func (e *testEngine) renewalCharge(t *testing.T, at time.Time) Charge {
+	t.Helper()
+	report := e.expire(t, at)
+	if len(report.Charges) != 1 || len(report.Renewed)+len(report.Expired) != 0 {
+		t.Fatalf("got %+v, want one renewal charge", report)
+	}
+	return report.Charges[0]
+}
+
+func TestExpireChargesTheRenewalFirst(t *testing.T) {
+	e := newTestEngine(t)
+	sub := e.renewing(t, day(2026, 1, 31), day(2026, 4, 30))
+	e.billThrough(t, day(2026, 1, 31), day(2026, 4, 30), models.TransactionSucceeded)
+
+	// the first period of the new term is charged before the term is extended
+	charge := e.renewalCharge(t, day(2026, 4, 30))
+	if !charge.Period.Equal(day(2026, 4, 30)) {
+		t.Errorf("got renewal period %v, want the old end date", charge.Period)
+	}
+	if got := e.get(t, sub.ID); got.Status != models.SubscriptionActive || !got.EndDate.Equal(day(2026, 4, 30)) {
+		t.Fatalf("got %s ending %v, want active until 2026-04-30 while the charge is pending", got.Status, got.EndDate)
+	}
+	report := e.expire(t, day(2026, 5, 1))
+	if len(report.Charges)+len(report.Renewed)+len(report.Expired) != 0 || len(report.Skipped) != 1 || report.Skipped[0].Reason != SkipAwaitingRenewal {
+		t.Fatalf("got %+v, want the subscription left awaiting its renewal", report)
+	}
+	// the renewal charge pays for the period, so billing doesn't charge it again
+	if periods := e.run(t, day(2026, 5, 15)); len(periods) != 0 {
+		t.Fatalf("billed %v on top of the renewal charge", periods)
+	}
+
+	e.settle(t, charge.TransactionID, models.TransactionSucceeded)
+	report = e.expire(t, day(2026, 5, 1))
+	if len(report.Renewed) != 1 || len(report.Charges)+len(report.Expired) != 0 {
+		t.Fatalf("got %+v, want one renewal", report)
+	}
+	// the term ended on the anchor day, so the renewal goes back to the 31st
+	if r := report.Renewed[0]; !r.PreviousEndDate.Equal(day(2026, 4, 30)) || !r.EndDate.Equal(day(2026, 5, 31)) {
+		t.Errorf("got renewal %+v, want 2026-04-30 to 2026-05-31", r)
+	}
+	if got := e.get(t, sub.ID); got.Status != models.SubscriptionActive || !got.EndDate.Equal(day(2026, 5, 31)) {
+		t.Errorf("got %s ending %v, want active until 2026-05-31", got.Status, got.EndDate)
+	}
+	if report := e.expire(t, day(2026, 5, 1)); len(report.Charges)+len(report.Renewed)+len(report.Expired)+len(report.Skipped) != 0 {
+		t.Errorf("a repeated run reported %+v", report)
+	}
+
+	// the next term is charged for in turn
+	if charge := e.renewalCharge(t, day(2026, 5, 31)); !charge.Period.Equal(day(2026, 5, 31)) {
+		t.Errorf("got renewal period %v, want 2026-05-31", charge.Period)
+	}
+}
+
+func TestExpireRenewsOnceARetrySucceeds(t *testing.T) {
+	e := newTestEngine(t)
+	sub := e.renewing(t, day(2026, 1, 1), day(2026, 4, 1))
+	e.billThrough(t, day(2026, 1, 1), day(2026, 3, 31), models.TransactionSucceeded)
+
+	e.settle(t, e.renewalCharge(t, day(2026, 4, 1)).TransactionID, models.TransactionFailed)
+	// one failure doesn't suspend, so the subscription waits on the retry
+	if report := e.expire(t, day(2026, 4, 1)); len(report.Skipped) != 1 || len(report.Expired)+len(report.Renewed) != 0 {
+		t.Fatalf("got %+v, want the subscription left awaiting its renewal", report)
+	}
+	retry := e.retry(t, sub.ID)
+	if !retry.Period.Equal(day(2026, 4, 1)) {
+		t.Fatalf("retried period %v, want the renewal period", retry.Period)
+	}
+	e.settle(t, retry.TransactionID, models.TransactionSucceeded)
+
+	report := e.expire(t, day(2026, 4, 2))
+	if len(report.Renewed) != 1 || !report.Renewed[0].EndDate.Equal(day(2026, 5, 1)) {
+		t.Fatalf("got %+v, want a renewal to 2026-05-01", report)
+	}
+}
+
+func TestExpireOnceTheRenewalFails(t *testing.T) {
+	e := newTestEngine(t)
+	ctx := context.Background()
+	p, err := e.stores.Partners.Get(ctx, e.partnerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SuspendAfter = 1
+	if err := e.stores.Partners.Update(ctx, &p); err != nil {
+		t.Fatal(err)
+	}
+	sub := e.renewing(t, day(2026, 1, 1), day(2026, 4, 1))
+	e.billThrough(t, day(2026, 1, 1), day(2026, 3, 31), models.TransactionSucceeded)
+
+	e.settle(t, e.renewalCharge(t, day(2026, 4, 1)).TransactionID, models.TransactionFailed)
+	report := e.expire(t, day(2026, 4, 1))
+	if len(report.Renewed) != 0 || len(report.Expired) != 1 || report.Expired[0].FromStatus != models.SubscriptionSuspended {
+		t.Fatalf("got %+v, want the suspended subscription expired", report)
+	}
+	if got := e.get(t, sub.ID); got.Status != models.SubscriptionExpired || !got.EndDate.Equal(day(2026, 4, 1)) {
+		t.Errorf("got %s ending %v, want expired on 2026-04-01", got.Status, got.EndDate)
+	}
+}
+
+func TestExpireSkipsFinalStatuses(t *testing.T) {
+	e := newTestEngine(t)
+	ctx := context.Background()
+	sub := e.renewing(t, day(2026, 1, 1), day(2026, 4, 1))
+	change := &models.SubscriptionStatusChange{FromStatus: sub.Status, ToStatus: models.SubscriptionCancelled, Reason: "moved away", Actor: "test"}
+	if _, err := e.stores.Subscriptions.Transition(ctx, sub.ID, e.get(t, sub.ID).Version, change); err != nil {
+		t.Fatal(err)
+	}
+
+	if report := e.expire(t, day(2026, 4, 1)); len(report.Expired)+len(report.Renewed)+len(report.Skipped) != 0 {
+		t.Errorf("got %+v, want a cancelled subscription left alone", report)
+	}
+	if got := e.get(t, sub.ID).Status; got != models.SubscriptionCancelled {
+		t.Errorf("got status %s, want cancelled", got)
+	}
+}
@@ -4,39 +4,75 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 )
 
-// Database Connection
-func Connectdb() (*sql.DB, error) {
-	// Open a connection to the database
-	db, err := sql.Open("postgres", os.Getenv("DB_CONN_STR"))
+// Config holds the connection string and pool limits for the database
+type Config struct {
+	ConnStr         string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// ConfigFromEnv builds a Config from the environment.
+// DB_CONN_STR is required; DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and
+// DB_CONN_MAX_LIFETIME (a Go duration such as "5m") fall back to sane defaults
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		ConnStr:         os.Getenv("DB_CONN_STR"),
+		MaxOpenConns:    25,
+		MaxIdleConns:    25,
+		ConnMaxLifetime: 5 * time.Minute,
+	}
+	if cfg.ConnStr == "" {
+		return cfg, fmt.Errorf("DB_CONN_STR is not set")
+	}
+
+	if v := os.Getenv("DB_MAX_OPEN_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid DB_MAX_OPEN_CONNS: %v", err)
+		}
+		cfg.MaxOpenConns = n
+	}
+	if v := os.Getenv("DB_MAX_IDLE_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid DB_MAX_IDLE_CONNS: %v", err)
+		}
+		cfg.MaxIdleConns = n
+	}
+	if v := os.Getenv("DB_CONN_MAX_LIFETIME"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid DB_CONN_MAX_LIFETIME: %v", err)
+		}
+		cfg.ConnMaxLifetime = d
+	}
+
+	return cfg, nil
+}
+
+// Open creates the long-lived connection pool shared by all handlers.
+// The caller owns the returned *sql.DB and should close it on shutdown
+func Open(cfg Config) (*sql.DB, error) {
+	// Open a connection pool to the database
+	db, err := sql.Open("postgres", cfg.ConnStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database connection: %v", err)
 	}
 
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
 	// Ping the database to verify that the connection is working
 	if err := db.Ping(); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %v", err)
 	}
-	rows, err := db.Query("SELECT * FROM partners")
-	if err != nil {
-		panic(err)
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var id int
-		var name, email, phoneNumber, billingAddress string
-		var createdAt, updatedAt time.Time
-	
-		err := rows.Scan(&id, &name, &email, &phoneNumber, &billingAddress, &createdAt, &updatedAt)
-		if err != nil {
-			panic(err)
-		}
-		// fmt.Printf("id: %d, name: %s, email: %s, phone number: %s, billing address: %s, created at: %s, updated at: %s\n", 
-		// 	id, name, email, phoneNumber, billingAddress, createdAt.Format(time.RFC3339), updatedAt.Format(time.RFC3339))
-	}
+
 	return db, nil
-}
\ No newline at end of file
+}
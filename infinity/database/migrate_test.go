@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	// versions run 1, 2, 3... with no gaps, so a missing file can't go unnoticed
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d, want %d", i, m.Version, i+1)
+		}
+		if m.Name == "" || strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("migration %04d_%s is missing its name, up or down SQL", m.Version, m.Name)
+		}
+	}
+}
+
+// withSearchPath points every connection opened with connStr at schema, in either
+// of the URL and key=value forms lib/pq accepts
+func withSearchPath(connStr, schema string) (string, error) {
+	if !strings.HasPrefix(connStr, "postgres://") && !strings.HasPrefix(connStr, "postgresql://") {
+		return connStr + " search_path=" + schema, nil
+	}
+	u, err := url.Parse(connStr)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// openScratchSchema connects to TEST_DB_CONN_STR inside a schema of its own, so
+// the migrations can come and go without touching the tables other tests use
+func openScratchSchema(t *testing.T) (*sql.DB, string) {
+	t.Helper()
+	connStr := os.Getenv("TEST_DB_CONN_STR")
+	if connStr == "" {
+		t.Skip("TEST_DB_CONN_STR is not set")
+	}
+	admin, err := Open(Config{ConnStr: connStr, MaxOpenConns: 1, MaxIdleConns: 1, ConnMaxLifetime: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := fmt.Sprintf("migrate_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("dropping %s: %v", schema, err)
+		}
+	})
+
+	scoped, err := withSearchPath(connStr, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(Config{ConnStr: scoped, MaxOpenConns: 5, MaxIdleConns: 5, ConnMaxLifetime: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// closed before the schema is dropped, as cleanups run last in first out
+	t.Cleanup(func() { db.Close() })
+	return db, schema
+}
+
+// tables lists the tables of schema other than schema_migrations
+func tables(t *testing.T, db *sql.DB, schema string) []string {
+	t.Helper()
+	rows, err := db.Query(`
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema=$1 AND table_name <> 'schema_migrations' ORDER BY table_name`, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	db, schema := openScratchSchema(t)
+	ctx := context.Background()
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureMigrationsTable(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+
+	// each migration goes up, down against the schema it just made, and up again
+	for _, m := range migrations {
+		for _, up := range []bool{true, false, true} {
+			if err := runMigration(ctx, db, m, up); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	statuses, err := Status(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			t.Errorf("migration %04d_%s isn't recorded as applied", s.Version, s.Name)
+		}
+	}
+
+	// stepping down one at a time undoes them newest first and leaves nothing behind
+	for i := len(migrations) - 1; i >= 0; i-- {
+		reverted, err := MigrateDown(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reverted == nil || reverted.Version != migrations[i].Version {
+			t.Fatalf("reverted %+v, want version %d", reverted, migrations[i].Version)
+		}
+	}
+	if reverted, err := MigrateDown(ctx, db); err != nil || reverted != nil {
+		t.Fatalf("got %+v, %v with nothing left to revert", reverted, err)
+	}
+	if left := tables(t, db, schema); len(left) != 0 {
+		t.Errorf("the down migrations left tables %v", left)
+	}
+
+	// and the schema builds again from scratch
+	applied, err := MigrateUp(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+	if again, err := MigrateUp(ctx, db); err != nil || len(again) != 0 {
+		t.Errorf("a second MigrateUp applied %d, %v; want nothing", len(again), err)
+	}
+}
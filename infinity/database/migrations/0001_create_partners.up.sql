@@ -0,0 +1,11 @@
+-- Databases created before migrations existed already have this table;
+-- IF NOT EXISTS lets them adopt the migration history without failing
+CREATE TABLE IF NOT EXISTS partners (
+    id              SERIAL PRIMARY KEY,
+    name            TEXT        NOT NULL,
+    email           TEXT        NOT NULL UNIQUE,
+    phone_number    TEXT        NOT NULL DEFAULT '',
+    billing_address TEXT        NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
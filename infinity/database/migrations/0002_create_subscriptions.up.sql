@@ -0,0 +1,16 @@
+-- IF NOT EXISTS, like 0001, for databases that predate migrations
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id                SERIAL PRIMARY KEY,
+    partner_id        INTEGER       NOT NULL REFERENCES partners (id),
+    customer_msisdn   TEXT          NOT NULL,
+    subscription_date TIMESTAMPTZ   NOT NULL,
+    status            TEXT          NOT NULL DEFAULT '',
+    billing_amount    NUMERIC(12,2) NOT NULL DEFAULT 0,
+    billing_cycle     TEXT          NOT NULL DEFAULT '',
+    start_date        TIMESTAMPTZ   NOT NULL,
+    end_date          TIMESTAMPTZ   NOT NULL,
+    created_at        TIMESTAMPTZ   NOT NULL DEFAULT now(),
+    updated_at        TIMESTAMPTZ   NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS subscriptions_partner_id_idx ON subscriptions (partner_id);
@@ -0,0 +1,12 @@
+-- IF NOT EXISTS, like 0001, for databases that predate migrations
+CREATE TABLE IF NOT EXISTS transactions (
+    id               SERIAL PRIMARY KEY,
+    subscription_id  INTEGER       NOT NULL REFERENCES subscriptions (id),
+    transaction_date TIMESTAMPTZ   NOT NULL DEFAULT now(),
+    amount           NUMERIC(12,2) NOT NULL,
+    status           TEXT          NOT NULL DEFAULT '',
+    created_at       TIMESTAMPTZ   NOT NULL DEFAULT now(),
+    updated_at       TIMESTAMPTZ   NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS transactions_subscription_id_idx ON transactions (subscription_id);
@@ -0,0 +1,12 @@
+CREATE TABLE api_keys (
+    id           SERIAL PRIMARY KEY,
+    partner_id   INTEGER     NOT NULL REFERENCES partners (id),
+    name         TEXT        NOT NULL DEFAULT '',
+    prefix       TEXT        NOT NULL,
+    key_hash     TEXT        NOT NULL UNIQUE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX api_keys_partner_id_idx ON api_keys (partner_id);
@@ -0,0 +1,3 @@
+ALTER TABLE transactions  DROP COLUMN version;
+ALTER TABLE subscriptions DROP COLUMN version;
+ALTER TABLE partners      DROP COLUMN version;
@@ -0,0 +1,3 @@
+ALTER TABLE partners      ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE subscriptions ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE transactions  ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
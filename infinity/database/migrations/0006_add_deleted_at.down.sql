@@ -0,0 +1,4 @@
+DROP INDEX subscriptions_live_partner_id_idx;
+
+ALTER TABLE subscriptions DROP COLUMN deleted_at;
+ALTER TABLE partners      DROP COLUMN deleted_at;
@@ -0,0 +1,4 @@
+ALTER TABLE partners      ADD COLUMN deleted_at TIMESTAMPTZ;
+ALTER TABLE subscriptions ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE INDEX subscriptions_live_partner_id_idx ON subscriptions (partner_id) WHERE deleted_at IS NULL;
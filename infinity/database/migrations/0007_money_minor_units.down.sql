@@ -0,0 +1,8 @@
+-- Assumes every amount is in a currency with two decimal places
+ALTER TABLE transactions ALTER COLUMN amount TYPE NUMERIC(12,2) USING amount / 100.0;
+ALTER TABLE transactions DROP COLUMN currency;
+
+ALTER TABLE subscriptions ALTER COLUMN billing_amount DROP DEFAULT;
+ALTER TABLE subscriptions ALTER COLUMN billing_amount TYPE NUMERIC(12,2) USING billing_amount / 100.0;
+ALTER TABLE subscriptions ALTER COLUMN billing_amount SET DEFAULT 0;
+ALTER TABLE subscriptions DROP COLUMN currency;
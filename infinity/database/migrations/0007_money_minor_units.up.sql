@@ -0,0 +1,11 @@
+-- Amounts move from NUMERIC(12,2) to integer minor units of a per-row currency.
+-- Every existing row was priced in KES, whose minor unit is the cent
+ALTER TABLE subscriptions ADD COLUMN currency TEXT NOT NULL DEFAULT 'KES';
+ALTER TABLE subscriptions ALTER COLUMN currency DROP DEFAULT;
+ALTER TABLE subscriptions ALTER COLUMN billing_amount DROP DEFAULT;
+ALTER TABLE subscriptions ALTER COLUMN billing_amount TYPE BIGINT USING round(billing_amount * 100);
+ALTER TABLE subscriptions ALTER COLUMN billing_amount SET DEFAULT 0;
+
+ALTER TABLE transactions ADD COLUMN currency TEXT NOT NULL DEFAULT 'KES';
+ALTER TABLE transactions ALTER COLUMN currency DROP DEFAULT;
+ALTER TABLE transactions ALTER COLUMN amount TYPE BIGINT USING round(amount * 100);
@@ -0,0 +1,13 @@
+CREATE TABLE idempotency_keys (
+    scope           TEXT        NOT NULL,
+    key             TEXT        NOT NULL,
+    request_hash    TEXT        NOT NULL,
+    response_status INTEGER     NOT NULL DEFAULT 0,
+    response_header JSONB,
+    response_body   BYTEA,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at      TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
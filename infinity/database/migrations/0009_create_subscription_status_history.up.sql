@@ -0,0 +1,11 @@
+CREATE TABLE subscription_status_history (
+    id              SERIAL PRIMARY KEY,
+    subscription_id INTEGER     NOT NULL REFERENCES subscriptions (id),
+    from_status     TEXT        NOT NULL,
+    to_status       TEXT        NOT NULL,
+    reason          TEXT        NOT NULL DEFAULT '',
+    actor           TEXT        NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX subscription_status_history_subscription_id_idx ON subscription_status_history (subscription_id, id);
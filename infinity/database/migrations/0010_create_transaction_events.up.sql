@@ -0,0 +1,13 @@
+CREATE TABLE transaction_events (
+    id             SERIAL PRIMARY KEY,
+    transaction_id INTEGER     NOT NULL REFERENCES transactions (id) ON DELETE CASCADE,
+    from_status    TEXT        NOT NULL,
+    to_status      TEXT        NOT NULL,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX transaction_events_transaction_id_idx ON transaction_events (transaction_id, id);
+
+-- existing transactions start their timeline at the status they have now
+INSERT INTO transaction_events (transaction_id, from_status, to_status, created_at)
+SELECT id, '', status, created_at FROM transactions ORDER BY id;
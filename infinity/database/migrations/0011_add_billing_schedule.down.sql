@@ -0,0 +1,2 @@
+ALTER TABLE transactions  DROP COLUMN billing_period;
+ALTER TABLE subscriptions DROP COLUMN next_billing_date;
@@ -0,0 +1,14 @@
+-- The billing engine charges a subscription when its next billing date comes and
+-- then moves the date on by one cycle. Existing subscriptions start from their
+-- start date; the engine skips ahead to the current period rather than billing
+-- every period since
+ALTER TABLE subscriptions ADD COLUMN next_billing_date TIMESTAMPTZ;
+UPDATE subscriptions SET next_billing_date = start_date;
+ALTER TABLE subscriptions ALTER COLUMN next_billing_date SET NOT NULL;
+
+CREATE INDEX subscriptions_live_next_billing_date_idx ON subscriptions (next_billing_date) WHERE deleted_at IS NULL;
+
+-- A charge made by the engine names the period it pays for; one per subscription and period
+ALTER TABLE transactions ADD COLUMN billing_period TIMESTAMPTZ;
+
+CREATE UNIQUE INDEX transactions_subscription_billing_period_idx ON transactions (subscription_id, billing_period) WHERE billing_period IS NOT NULL;
@@ -0,0 +1,13 @@
+DROP INDEX transactions_subscription_billing_attempt_idx;
+-- retries are kept, but no longer as charges of their period
+UPDATE transactions SET billing_period = NULL WHERE attempt > 1;
+CREATE UNIQUE INDEX transactions_subscription_billing_period_idx ON transactions (subscription_id, billing_period) WHERE billing_period IS NOT NULL;
+ALTER TABLE transactions DROP COLUMN attempt;
+
+ALTER TABLE subscriptions DROP COLUMN dunning_period;
+ALTER TABLE subscriptions DROP COLUMN next_attempt_at;
+ALTER TABLE subscriptions DROP COLUMN failed_attempts;
+ALTER TABLE subscriptions DROP COLUMN dunning_state;
+
+ALTER TABLE partners DROP COLUMN suspend_after;
+ALTER TABLE partners DROP COLUMN retry_schedule;
@@ -0,0 +1,18 @@
+-- Each partner sets how failed charges are retried and after how many failures
+-- in a row a subscription is suspended
+ALTER TABLE partners ADD COLUMN retry_schedule TEXT[]  NOT NULL DEFAULT '{1h,6h,24h}';
+ALTER TABLE partners ADD COLUMN suspend_after  INTEGER NOT NULL DEFAULT 3;
+
+ALTER TABLE subscriptions ADD COLUMN dunning_state   TEXT    NOT NULL DEFAULT 'current';
+ALTER TABLE subscriptions ADD COLUMN failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE subscriptions ADD COLUMN next_attempt_at TIMESTAMPTZ;
+ALTER TABLE subscriptions ADD COLUMN dunning_period  TIMESTAMPTZ;
+
+CREATE INDEX subscriptions_next_attempt_at_idx ON subscriptions (next_attempt_at) WHERE next_attempt_at IS NOT NULL;
+
+-- A retry charges the same period again, so charges are unique per attempt
+ALTER TABLE transactions ADD COLUMN attempt INTEGER NOT NULL DEFAULT 0;
+UPDATE transactions SET attempt = 1 WHERE billing_period IS NOT NULL;
+
+DROP INDEX transactions_subscription_billing_period_idx;
+CREATE UNIQUE INDEX transactions_subscription_billing_attempt_idx ON transactions (subscription_id, billing_period, attempt) WHERE billing_period IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP INDEX subscriptions_live_end_date_idx;
+
+ALTER TABLE subscriptions DROP COLUMN auto_renew;
@@ -0,0 +1,6 @@
+-- A subscription that renews automatically is charged for the first period of
+-- its next term at its end date, and extended by one cycle once that charge
+-- succeeds, instead of expiring
+ALTER TABLE subscriptions ADD COLUMN auto_renew BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX subscriptions_live_end_date_idx ON subscriptions (end_date) WHERE deleted_at IS NULL;
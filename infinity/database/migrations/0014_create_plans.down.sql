@@ -0,0 +1,6 @@
+DROP INDEX subscriptions_plan_id_idx;
+
+ALTER TABLE subscriptions DROP COLUMN trial_ends_at;
+ALTER TABLE subscriptions DROP COLUMN plan_id;
+
+DROP TABLE plans;
@@ -0,0 +1,24 @@
+CREATE TABLE plans (
+    id            SERIAL PRIMARY KEY,
+    partner_id    INTEGER     NOT NULL REFERENCES partners (id),
+    name          TEXT        NOT NULL,
+    description   TEXT        NOT NULL DEFAULT '',
+    price         BIGINT      NOT NULL,
+    currency      TEXT        NOT NULL,
+    billing_cycle TEXT        NOT NULL,
+    trial_days    INTEGER     NOT NULL DEFAULT 0,
+    version       INTEGER     NOT NULL DEFAULT 1,
+    deleted_at    TIMESTAMPTZ,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- names are unique within a partner's live catalog
+CREATE UNIQUE INDEX plans_partner_id_name_idx ON plans (partner_id, name) WHERE deleted_at IS NULL;
+
+-- A subscription sold under a plan keeps a snapshot of its price; the trial
+-- pushes the first billing date back
+ALTER TABLE subscriptions ADD COLUMN plan_id INTEGER REFERENCES plans (id);
+ALTER TABLE subscriptions ADD COLUMN trial_ends_at TIMESTAMPTZ;
+
+CREATE INDEX subscriptions_plan_id_idx ON subscriptions (plan_id);
@@ -2,11 +2,11 @@ module infinity
 
 go 1.20
 
-require github.com/lib/pq v1.10.7
-
 require (
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible // indirect
-	github.com/go-redis/redis v6.15.9+incompatible // indirect
-	github.com/gorilla/mux v1.8.0 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/gorilla/mux v1.8.0
+	github.com/joho/godotenv v1.5.1
+	github.com/lib/pq v1.10.7
 )
+
+require github.com/go-redis/redis v6.15.9+incompatible
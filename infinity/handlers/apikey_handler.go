@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"infinity/models"
+	"infinity/store"
+	"net/http"
+)
+
+// apiKeyPrefix marks strings as infinity API keys so they are easy to spot in leaks
+const apiKeyPrefix = "inf_"
+
+// generateAPIKey returns a new random key and the short prefix shown in listings
+func generateAPIKey() (key, prefix string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+	return key, key[:len(apiKeyPrefix)+6], nil
+}
+
+// hashAPIKey is the value stored for a key; the keys are random enough that a plain SHA-256 suffices
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// issue an API key for a partner
+func createAPIKey(stores store.Stores) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		partnerID, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		// The body is optional and only carries a label for the key
+		var body struct {
+			Name string `json:"name"`
+		}
+		if r.ContentLength != 0 {
+			if err := decodeBody(r, &body); err != nil {
+				badRequest(w, r, err)
+				return
+			}
+		}
+
+		// Make sure the partner exists before issuing it a key
+		if _, err := stores.Partners.Get(r.Context(), partnerID); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				notFound(w, r, "partner")
+				return
+			}
+			writeError(w, r, err)
+			return
+		}
+
+		plain, prefix, err := generateAPIKey()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		key := models.APIKey{PartnerID: partnerID, Name: body.Name, Prefix: prefix, KeyHash: hashAPIKey(plain)}
+		if err := stores.APIKeys.Create(r.Context(), &key); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// The plain key is only ever returned here
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(struct {
+			models.APIKey
+			Key string `json:"key"`
+		}{key, plain})
+	}
+}
+
+// list a partner's API keys, including revoked ones
+func getAPIKeys(keys store.APIKeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		partnerID, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		list, err := keys.ListByPartner(r.Context(), partnerID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if list == nil {
+			list = []models.APIKey{}
+		}
+
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			writeError(w, r, err)
+		}
+	}
+}
+
+// revoke an API key; its refresh tokens stop working at once, and access tokens
+// already issued for it stay valid until they expire within the hour
+func revokeAPIKey(keys store.APIKeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		partnerID, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+		keyID, err := pathID(r, "keyID")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		err = keys.Revoke(r.Context(), partnerID, keyID)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "API key")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
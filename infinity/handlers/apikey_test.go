@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issuedKey is the response to issuing an API key, the only one that carries the key itself
+type issuedKey struct {
+	ID        int        `json:"id"`
+	PartnerID int        `json:"partner_id"`
+	Prefix    string     `json:"prefix"`
+	Key       string     `json:"key"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+func (api *testAPI) issueKey(t *testing.T, partnerID int) issuedKey {
+	t.Helper()
+	rec := api.do(t, "POST", fmt.Sprintf("/partners/%d/api-keys", partnerID), api.admin, `{"name":"ci"}`)
+	api.expect(t, rec, http.StatusCreated)
+	var key issuedKey
+	api.decode(t, rec, &key)
+	return key
+}
+
+func TestAPIKeysAreHashedAtRest(t *testing.T) {
+	api := newTestAPI(t)
+	partnerID := api.createPartner(t, "c")
+	key := api.issueKey(t, partnerID)
+	if !strings.HasPrefix(key.Key, apiKeyPrefix) || !strings.HasPrefix(key.Key, key.Prefix) || len(key.Prefix) >= len(key.Key) {
+		t.Fatalf("got key %q with prefix %q", key.Key, key.Prefix)
+	}
+
+	stored, err := api.stores.APIKeys.Get(context.Background(), key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.KeyHash != hashAPIKey(key.Key) || strings.Contains(stored.KeyHash, key.Key[len(apiKeyPrefix):]) {
+		t.Errorf("stored %q for key %q, want its SHA-256 only", stored.KeyHash, key.Key)
+	}
+	if other := api.issueKey(t, partnerID); other.Key == key.Key {
+		t.Error("two keys came out the same")
+	}
+
+	// listings show the prefix, never the key or its hash
+	rec := api.do(t, "GET", fmt.Sprintf("/partners/%d/api-keys", partnerID), api.admin, "")
+	api.expect(t, rec, http.StatusOK)
+	if body := rec.Body.String(); strings.Contains(body, key.Key) || strings.Contains(body, stored.KeyHash) || !strings.Contains(body, key.Prefix) {
+		t.Errorf("listing leaks the key: %s", body)
+	}
+
+	// exchanging the key finds it by hash and records its use
+	api.expect(t, api.do(t, "GET", "/jwt", "", "", "Access", key.Key), http.StatusOK)
+	if stored, err = api.stores.APIKeys.Get(context.Background(), key.ID); err != nil || stored.LastUsedAt == nil {
+		t.Errorf("got last use %v, %v after an exchange", stored.LastUsedAt, err)
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	api := newTestAPI(t)
+	partnerID := api.createPartner(t, "c")
+	key := api.issueKey(t, partnerID)
+	kept := api.issueKey(t, partnerID)
+	login := api.do(t, "GET", "/jwt", "", "", "Access", key.Key)
+	api.expect(t, login, http.StatusOK)
+	path := fmt.Sprintf("/partners/%d/api-keys/%d", partnerID, key.ID)
+
+	// only an admin revokes, and only through the key's own partner
+	api.expect(t, api.do(t, "DELETE", path, api.partnerA, ""), http.StatusForbidden)
+	api.expect(t, api.do(t, "DELETE", fmt.Sprintf("/partners/%d/api-keys/%d", partnerID+1, key.ID), api.admin, ""), http.StatusNotFound)
+
+	api.expect(t, api.do(t, "DELETE", path, api.admin, ""), http.StatusNoContent)
+	api.expect(t, api.do(t, "DELETE", path, api.admin, ""), http.StatusNotFound)
+
+	// the key no longer logs in and its logins can't be refreshed
+	api.expect(t, api.do(t, "GET", "/jwt", "", "", "Access", key.Key), http.StatusUnauthorized)
+	api.expect(t, api.do(t, "GET", "/jwt", "", "", "Refresh", login.Header().Get("Refresh-Token")), http.StatusUnauthorized)
+	// the access token already issued runs out on its own
+	api.expect(t, api.do(t, "GET", "/api", login.Body.String(), ""), http.StatusOK)
+
+	// the partner's other keys carry on
+	api.expect(t, api.do(t, "GET", "/jwt", "", "", "Access", kept.Key), http.StatusOK)
+
+	rec := api.do(t, "GET", fmt.Sprintf("/partners/%d/api-keys", partnerID), api.admin, "")
+	api.expect(t, rec, http.StatusOK)
+	var listed []issuedKey
+	api.decode(t, rec, &listed)
+	if len(listed) != 2 || listed[0].ID != key.ID || listed[0].RevokedAt == nil || listed[1].RevokedAt != nil {
+		t.Errorf("got %+v, want the revoked key listed as revoked next to the live one", listed)
+	}
+}
+
+func TestGetJWTRefusesUnknownKeys(t *testing.T) {
+	api := newTestAPI(t)
+	partnerID := api.createPartner(t, "c")
+	key := api.issueKey(t, partnerID)
+	gone := api.issueKey(t, api.createPartner(t, "d"))
+	if err := api.stores.Partners.Delete(context.Background(), gone.PartnerID, 0, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{"no key", ""},
+		{"unknown key", apiKeyPrefix + "unknown"},
+		{"the key's hash", hashAPIKey(key.Key)},
+		{"the key's prefix", key.Prefix},
+		{"a key of a deleted partner", gone.Key},
+		{"a near miss of the admin key", "test-admin-key-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := api.do(t, "GET", "/jwt", "", "", "Access", tt.key)
+			api.expect(t, rec, http.StatusUnauthorized)
+			if rec.Header().Get("Refresh-Token") != "" {
+				t.Error("a refused exchange issued a refresh token")
+			}
+		})
+	}
+
+	// the exchanged token acts for the key's partner
+	rec := api.do(t, "GET", "/jwt", "", "", "Access", key.Key)
+	api.expect(t, rec, http.StatusOK)
+	claims, err := parseToken(rec.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["partner_id"] != float64(partnerID) || claims["sub"] != fmt.Sprintf("partner:%d", partnerID) {
+		t.Errorf("got claims %v, want partner %d", claims, partnerID)
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"infinity/billing"
+	"infinity/models"
+	"infinity/store"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// billingCharge is a charge of a billing simulation as the API renders it
+type billingCharge struct {
+	SubscriptionID int       `json:"subscription_id"`
+	PartnerID      int       `json:"partner_id"`
+	BillingPeriod  time.Time `json:"billing_period"`
+	Amount         string    `json:"amount"`
+	Currency       string    `json:"currency"`
+	Retry          bool      `json:"retry"`
+}
+
+type billingSkip struct {
+	SubscriptionID int    `json:"subscription_id"`
+	PartnerID      int    `json:"partner_id"`
+	Reason         string `json:"reason"`
+}
+
+// billingTotal is a per partner total, or a per currency one without partner_id
+type billingTotal struct {
+	PartnerID int    `json:"partner_id,omitempty"`
+	Currency  string `json:"currency"`
+	Charges   int    `json:"charges"`
+	Amount    string `json:"amount"`
+}
+
+type billingTotals struct {
+	ByPartner  []billingTotal `json:"by_partner"`
+	ByCurrency []billingTotal `json:"by_currency"`
+}
+
+// billingSimulation is the body of GET /billing/simulation
+type billingSimulation struct {
+	At      time.Time       `json:"at"`
+	Charges []billingCharge `json:"charges"`
+	Skipped []billingSkip   `json:"skipped"`
+	Totals  billingTotals   `json:"totals"`
+}
+
+// simulate a billing run for a date without charging anything
+func simulateBilling(engine *billing.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
+
+		// The run happens at the end of the given day, today by default
+		if err := checkParams(r, "date"); err != nil {
+			badRequest(w, r, err)
+			return
+		}
+		date := r.URL.Query().Get("date")
+		if date == "" {
+			date = time.Now().UTC().Format("2006-01-02")
+		}
+		at, err := billing.ParseDate(date)
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		report, err := engine.Simulate(r.Context(), at)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Render the report with decimal amounts, like every other amount in the API
+		body := billingSimulation{At: report.At, Charges: []billingCharge{}, Skipped: []billingSkip{}}
+		for _, c := range report.Charges {
+			body.Charges = append(body.Charges, billingCharge{c.SubscriptionID, c.PartnerID, c.Period, models.FormatMoney(c.Amount, c.Currency), c.Currency, c.Retry})
+		}
+		for _, s := range report.Skipped {
+			body.Skipped = append(body.Skipped, billingSkip{s.SubscriptionID, s.PartnerID, s.Reason})
+		}
+		byPartner, byCurrency := report.Totals()
+		body.Totals = billingTotals{ByPartner: []billingTotal{}, ByCurrency: []billingTotal{}}
+		for _, t := range byPartner {
+			body.Totals.ByPartner = append(body.Totals.ByPartner, billingTotal{t.PartnerID, t.Currency, t.Charges, models.FormatMoney(t.Amount, t.Currency)})
+		}
+		for _, t := range byCurrency {
+			body.Totals.ByCurrency = append(body.Totals.ByCurrency, billingTotal{0, t.Currency, t.Charges, models.FormatMoney(t.Amount, t.Currency)})
+		}
+
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			writeError(w, r, err)
+		}
+	}
+}
+
+func BillingRouter(stores store.Stores) *mux.Router {
+	router := mux.NewRouter()
+
+	// billing endpoints are for operators only
+	router.Handle("/billing/simulation", ValidateJWT(simulateBilling(billing.NewEngine(stores)), ScopeAdmin)).Methods("GET")
+
+	return router
+}
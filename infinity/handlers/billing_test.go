@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// simulate runs GET /billing/simulation with the query as an admin
+func (api *testAPI) simulate(t *testing.T, query string) billingSimulation {
+	t.Helper()
+	rec := api.do(t, "GET", "/billing/simulation"+query, api.admin, "")
+	api.expect(t, rec, http.StatusOK)
+	var body billingSimulation
+	api.decode(t, rec, &body)
+	return body
+}
+
+func TestBillingSimulation(t *testing.T) {
+	api := newTestAPI(t)
+	first := api.createSubscription(t, api.partnerA)
+	second := api.createSubscription(t, api.partnerA)
+	rec := api.do(t, "POST", "/subscriptions", api.partnerB, `{
+		"customer_msisdn": "+254700000002",
+		"subscription_date": "2026-01-15T00:00:00Z",
+		"status": "active",
+		"billing_amount": "5.50",
+		"currency": "USD",
+		"billing_cycle": "weekly",
+		"start_date": "2026-01-15T00:00:00Z",
+		"end_date": "2027-01-15T00:00:00Z"
+	}`)
+	api.expect(t, rec, http.StatusCreated)
+	var created struct {
+		ID int `json:"id"`
+	}
+	api.decode(t, rec, &created)
+	jan1, jan15 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	// the run happens at the end of the day, when partner b's subscription hasn't started
+	got := api.simulate(t, "?date=2026-01-01")
+	if want := jan1.AddDate(0, 0, 1).Add(-time.Nanosecond); !got.At.Equal(want) {
+		t.Errorf("got a run at %v, want %v", got.At, want)
+	}
+	wantCharges := []billingCharge{
+		{first, 1, jan1, "10.00", "KES", false},
+		{second, 1, jan1, "10.00", "KES", false},
+	}
+	if !reflect.DeepEqual(got.Charges, wantCharges) || len(got.Skipped) != 0 {
+		t.Errorf("got charges %+v, skipped %+v; want %+v", got.Charges, got.Skipped, wantCharges)
+	}
+	wantTotals := billingTotals{
+		ByPartner:  []billingTotal{{1, "KES", 2, "20.00"}},
+		ByCurrency: []billingTotal{{0, "KES", 2, "20.00"}},
+	}
+	if !reflect.DeepEqual(got.Totals, wantTotals) {
+		t.Errorf("got totals %+v, want %+v", got.Totals, wantTotals)
+	}
+
+	// nothing was charged, so a later date still sees partner a's first period, next to partner b's
+	got = api.simulate(t, "?date=2026-01-15")
+	wantCharges = append(wantCharges, billingCharge{created.ID, 2, jan15, "5.50", "USD", false})
+	if !reflect.DeepEqual(got.Charges, wantCharges) {
+		t.Errorf("got charges %+v, want %+v", got.Charges, wantCharges)
+	}
+	wantTotals = billingTotals{
+		ByPartner:  []billingTotal{{1, "KES", 2, "20.00"}, {2, "USD", 1, "5.50"}},
+		ByCurrency: []billingTotal{{0, "KES", 2, "20.00"}, {0, "USD", 1, "5.50"}},
+	}
+	if !reflect.DeepEqual(got.Totals, wantTotals) {
+		t.Errorf("got totals %+v, want %+v", got.Totals, wantTotals)
+	}
+	if ids := api.listIDs(t, "/transactions?sort=id", "10", api.admin); len(ids) != 0 {
+		t.Errorf("the simulation created transactions %v", ids)
+	}
+
+	// before anything is due the lists are empty, not null
+	rec = api.do(t, "GET", "/billing/simulation?date=2025-12-31", api.admin, "")
+	api.expect(t, rec, http.StatusOK)
+	if want := `"charges":[],"skipped":[],"totals":{"by_partner":[],"by_currency":[]}`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("got %s, want %s", rec.Body.String(), want)
+	}
+}
+
+func TestBillingSimulationDate(t *testing.T) {
+	api := newTestAPI(t)
+
+	// without a date the run is for today
+	got := api.simulate(t, "")
+	today := time.Now().UTC().Format("2006-01-02")
+	if got.At.Format("2006-01-02") != today || got.At.Hour() != 23 {
+		t.Errorf("got a run at %v, want the end of %s", got.At, today)
+	}
+
+	for _, query := range []string{"?date=2026-13-01", "?date=01/02/2026", "?date=2026-01-01T00:00:00Z", "?day=2026-01-01"} {
+		t.Run(query, func(t *testing.T) {
+			api.expect(t, api.do(t, "GET", "/billing/simulation"+query, api.admin, ""), http.StatusBadRequest)
+		})
+	}
+}
+
+func TestBillingSimulationIsForAdmins(t *testing.T) {
+	api := newTestAPI(t)
+	api.createSubscription(t, api.partnerA)
+	for name, token := range map[string]string{"partner": api.partnerA, "no token": ""} {
+		rec := api.do(t, "GET", "/billing/simulation?date=2026-01-01", token, "")
+		want := http.StatusForbidden
+		if token == "" {
+			want = http.StatusUnauthorized
+		}
+		if rec.Code != want {
+			t.Errorf("%s: got status %d, want %d", name, rec.Code, want)
+		}
+	}
+	api.expect(t, api.do(t, "POST", "/billing/simulation", api.admin, ""), http.StatusMethodNotAllowed)
+}
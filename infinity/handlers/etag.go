@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"infinity/store"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag renders a row version as a strong entity tag
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// setETag advertises the version of the row in the response
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", etag(version))
+}
+
+// etagMatches reports whether a comma separated If-Match or If-None-Match list
+// names the version. If-Match compares strongly, so weak tags never match it
+func etagMatches(header string, version int, weak bool) bool {
+	want := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == want {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified answers a GET with 304 Not Modified when If-None-Match names the
+// current version; the caller must not write anything else when it returns true
+func notModified(w http.ResponseWriter, r *http.Request, version int) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !etagMatches(header, version, true) {
+		return false
+	}
+	w.Header().Del("Content-Type")
+	setETag(w, version)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// ifMatch checks the If-Match header of a write against the stored version,
+// loaded through current only when the header is present. It returns the
+// version the store must pin the write to, 0 when the request sets no precondition,
+// and store.ErrVersionMismatch when the header names another version. The store
+// also refuses the write if the version moves on meanwhile, so the check holds
+// until the write lands
+func ifMatch(r *http.Request, current func() (int, error)) (int, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, nil
+	}
+	version, err := current()
+	if err != nil {
+		return 0, err
+	}
+	if !etagMatches(header, version, false) {
+		return 0, store.ErrVersionMismatch
+	}
+	return version, nil
+}
+
+// matchVersion is ifMatch for a row the handler has already loaded
+func matchVersion(r *http.Request, version int) (int, error) {
+	return ifMatch(r, func() (int, error) { return version, nil })
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		ifMatch  string
+		want     int
+		wantETag string
+	}{
+		{"patch without precondition", "PATCH", `{"auto_renew":true}`, "", http.StatusOK, `"2"`},
+		{"patch current version", "PATCH", `{"auto_renew":true}`, `"1"`, http.StatusOK, `"2"`},
+		{"patch any version", "PATCH", `{"auto_renew":true}`, "*", http.StatusOK, `"2"`},
+		{"patch one of several", "PATCH", `{"auto_renew":true}`, `"7", "1"`, http.StatusOK, `"2"`},
+		{"patch stale version", "PATCH", `{"auto_renew":true}`, `"2"`, http.StatusPreconditionFailed, ""},
+		{"patch weak tag", "PATCH", `{"auto_renew":true}`, `W/"1"`, http.StatusPreconditionFailed, ""},
+		{"delete stale version", "DELETE", "", `"2"`, http.StatusPreconditionFailed, ""},
+		{"delete current version", "DELETE", "", `"1"`, http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			path := fmt.Sprintf("/subscriptions/%d", api.createSubscription(t, api.partnerA))
+
+			header := []string{"Content-Type", "application/merge-patch+json"}
+			if tt.ifMatch != "" {
+				header = append(header, "If-Match", tt.ifMatch)
+			}
+			rec := api.do(t, tt.method, path, api.partnerA, tt.body, header...)
+			api.expect(t, rec, tt.want)
+			if got := rec.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("got ETag %q, want %q", got, tt.wantETag)
+			}
+			if tt.want == http.StatusPreconditionFailed {
+				// the refused write left the subscription alone
+				rec := api.do(t, "GET", path, api.partnerA, "")
+				if got := rec.Header().Get("ETag"); got != `"1"` {
+					t.Errorf("got ETag %q after a refused write, want \"1\"", got)
+				}
+			}
+		})
+	}
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	api := newTestAPI(t)
+	path := fmt.Sprintf("/subscriptions/%d", api.createSubscription(t, api.partnerA))
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        int
+	}{
+		{"no precondition", "", http.StatusOK},
+		{"current version", `"1"`, http.StatusNotModified},
+		{"weak current version", `W/"1"`, http.StatusNotModified},
+		{"other version", `"2"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := api.do(t, "GET", path, api.partnerA, "", "If-None-Match", tt.ifNoneMatch)
+			api.expect(t, rec, tt.want)
+			if got := rec.Header().Get("ETag"); got != `"1"` {
+				t.Errorf("got ETag %q, want \"1\"", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"fmt"
+	"infinity/models"
+	"infinity/store"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Query parameters every list endpoint understands
+var pageParams = []string{"limit", "cursor", "sort"}
+
+// checkParams rejects query parameters the endpoint doesn't know, so a typo
+// in a filter name fails loudly instead of silently returning everything
+func checkParams(r *http.Request, known ...string) error {
+	allowed := make(map[string]bool)
+	for _, k := range append(known, pageParams...) {
+		allowed[k] = true
+	}
+	for k := range r.URL.Query() {
+		if !allowed[k] {
+			return fmt.Errorf("unknown query parameter %q", k)
+		}
+	}
+	return nil
+}
+
+// queryID reads an optional positive integer ID parameter
+func queryID(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	id, err := strconv.Atoi(v)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	return id, nil
+}
+
+// queryBool reads an optional true/false parameter
+func queryBool(r *http.Request, name string) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", name)
+	}
+	return b, nil
+}
+
+// queryEnum reads an optional parameter that must be one of values
+func queryEnum(r *http.Request, name string, values []string) (string, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return "", nil
+	}
+	for _, allowed := range values {
+		if v == allowed {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("%s must be one of %s", name, strings.Join(values, ", "))
+}
+
+// includeDeleted reads include_deleted, which brings soft-deleted rows back into
+// a read. Only admin tokens may set it
+func includeDeleted(r *http.Request) (bool, error) {
+	include, err := queryBool(r, "include_deleted")
+	if err != nil {
+		return false, err
+	}
+	if _, confined := callerPartner(r); include && confined {
+		return false, newProblem(http.StatusForbidden, CodeForbidden, "include_deleted requires an admin token")
+	}
+	return include, nil
+}
+
+// queryAmount reads an optional decimal amount parameter in currency
+func queryAmount(r *http.Request, name string, currency models.Currency) (*models.Money, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	m, err := currency.Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an amount in %s: %v", name, currency.Code, err)
+	}
+	return &m, nil
+}
+
+// queryCurrency reads an optional ISO 4217 currency parameter
+func queryCurrency(r *http.Request, name string) (string, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return "", nil
+	}
+	if _, ok := models.LookupCurrency(v); !ok {
+		return "", fmt.Errorf("%s must be a supported ISO 4217 currency code such as %s", name, models.DefaultCurrency)
+	}
+	return v, nil
+}
+
+// queryTime reads an optional RFC 3339 timestamp or YYYY-MM-DD date.
+// A bare date used as an upper bound covers the whole day
+func queryTime(r *http.Request, name string, upper bool) (time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp or a YYYY-MM-DD date", name)
+	}
+	if upper {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// parsePartnerFilter reads the GET /partners filters
+func parsePartnerFilter(r *http.Request) (store.PartnerFilter, error) {
+	var f store.PartnerFilter
+	if err := checkParams(r, "include_deleted"); err != nil {
+		return f, err
+	}
+
+	var err error
+	f.IncludeDeleted, err = includeDeleted(r)
+	return f, err
+}
+
+// parseSubscriptionFilter reads the GET /subscriptions filters
+func parseSubscriptionFilter(r *http.Request) (store.SubscriptionFilter, error) {
+	var f store.SubscriptionFilter
+	if err := checkParams(r, "partner_id", "status", "billing_cycle", "customer_msisdn",
+		"start_date_from", "start_date_to", "end_date_from", "end_date_to", "include_deleted"); err != nil {
+		return f, err
+	}
+
+	var err error
+	if f.IncludeDeleted, err = includeDeleted(r); err != nil {
+		return f, err
+	}
+	if f.Status, err = queryEnum(r, "status", models.SubscriptionStatuses); err != nil {
+		return f, err
+	}
+	if f.BillingCycle, err = queryEnum(r, "billing_cycle", models.BillingCycles); err != nil {
+		return f, err
+	}
+	f.CustomerMSISDN = r.URL.Query().Get("customer_msisdn")
+	if f.PartnerID, err = queryID(r, "partner_id"); err != nil {
+		return f, err
+	}
+	if f.StartFrom, err = queryTime(r, "start_date_from", false); err != nil {
+		return f, err
+	}
+	if f.StartTo, err = queryTime(r, "start_date_to", true); err != nil {
+		return f, err
+	}
+	if f.EndFrom, err = queryTime(r, "end_date_from", false); err != nil {
+		return f, err
+	}
+	if f.EndTo, err = queryTime(r, "end_date_to", true); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+// parseTransactionFilter reads the GET /transactions filters. Amount bounds are
+// in the currency parameter and only match transactions in it, DefaultCurrency
+// when it is absent
+func parseTransactionFilter(r *http.Request) (store.TransactionFilter, error) {
+	var f store.TransactionFilter
+	if err := checkParams(r, "subscription_id", "status", "currency", "amount_min", "amount_max",
+		"transaction_date_from", "transaction_date_to"); err != nil {
+		return f, err
+	}
+
+	var err error
+	if f.Status, err = queryEnum(r, "status", models.TransactionStatuses); err != nil {
+		return f, err
+	}
+	if f.SubscriptionID, err = queryID(r, "subscription_id"); err != nil {
+		return f, err
+	}
+	if f.Currency, err = queryCurrency(r, "currency"); err != nil {
+		return f, err
+	}
+	q := r.URL.Query()
+	if f.Currency == "" && (q.Get("amount_min") != "" || q.Get("amount_max") != "") {
+		f.Currency = models.DefaultCurrency
+	}
+	currency, _ := models.LookupCurrency(f.Currency)
+	if f.AmountMin, err = queryAmount(r, "amount_min", currency); err != nil {
+		return f, err
+	}
+	if f.AmountMax, err = queryAmount(r, "amount_max", currency); err != nil {
+		return f, err
+	}
+	if f.AmountMin != nil && f.AmountMax != nil && *f.AmountMin > *f.AmountMax {
+		return f, fmt.Errorf("amount_min must not exceed amount_max")
+	}
+	if f.DateFrom, err = queryTime(r, "transaction_date_from", false); err != nil {
+		return f, err
+	}
+	if f.DateTo, err = queryTime(r, "transaction_date_to", true); err != nil {
+		return f, err
+	}
+	return f, nil
+}
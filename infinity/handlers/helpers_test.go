@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"infinity/models"
+	"infinity/store"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// testAPI is the API over in-memory stores with two partners, "a" and "b",
+// and a token for each of them and for an admin
+type testAPI struct {
+	stores   store.Stores
+	router   http.Handler
+	admin    string
+	partnerA string
+	partnerB string
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	t.Setenv("SECRET_KEY", "test-secret")
+	t.Setenv("ADMIN_API_KEY", "test-admin-key")
+
+	stores := store.NewMemory()
+	router := mux.NewRouter()
+	router.Handle("/api", ValidateJWT(http.HandlerFunc(Home)))
+	router.HandleFunc("/jwt", GetJWT(stores.APIKeys))
+	router.Handle("/logout", ValidateJWT(http.HandlerFunc(Logout))).Methods("POST")
+	router.PathPrefix("/partners").Handler(PartnersRouter(stores))
+	router.PathPrefix("/subscriptions").Handler(SubscriptionsRouter(stores))
+	router.PathPrefix("/transactions").Handler(TransactionsRouter(stores))
+	router.PathPrefix("/billing").Handler(BillingRouter(stores))
+	api := &testAPI{stores: stores, router: router}
+
+	api.admin = api.do(t, "GET", "/jwt", "", "", "Access", "test-admin-key").Body.String()
+	api.partnerA = api.partnerToken(t, api.createPartner(t, "a"))
+	api.partnerB = api.partnerToken(t, api.createPartner(t, "b"))
+	return api
+}
+
+// do sends a request with the token and header name/value pairs through the router
+func (api *testAPI) do(t *testing.T, method, path, token, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Token", token)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	api.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// expect fails the test unless the response has the status
+func (api *testAPI) expect(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("got status %d, want %d: %s", rec.Code, status, rec.Body.String())
+	}
+}
+
+// decode reads the JSON response body into v
+func (api *testAPI) decode(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+	}
+}
+
+func (api *testAPI) createPartner(t *testing.T, name string) int {
+	t.Helper()
+	p := models.Partner{Name: name, Email: name + "@example.com"}
+	if err := api.stores.Partners.Create(context.Background(), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p.ID
+}
+
+// partnerToken issues an API key for the partner and exchanges it for an access token
+func (api *testAPI) partnerToken(t *testing.T, partnerID int) string {
+	t.Helper()
+	rec := api.do(t, "POST", fmt.Sprintf("/partners/%d/api-keys", partnerID), api.admin, `{"name":"test"}`)
+	api.expect(t, rec, http.StatusCreated)
+	var key struct {
+		Key string `json:"key"`
+	}
+	api.decode(t, rec, &key)
+	rec = api.do(t, "GET", "/jwt", "", "", "Access", key.Key)
+	api.expect(t, rec, http.StatusOK)
+	return rec.Body.String()
+}
+
+// createSubscription creates an active monthly subscription with the token and returns its ID
+func (api *testAPI) createSubscription(t *testing.T, token string) int {
+	t.Helper()
+	rec := api.do(t, "POST", "/subscriptions", token, `{
+		"customer_msisdn": "+254700000001",
+		"subscription_date": "2026-01-01T00:00:00Z",
+		"status": "active",
+		"billing_amount": "10.00",
+		"currency": "KES",
+		"billing_cycle": "monthly",
+		"start_date": "2026-01-01T00:00:00Z",
+		"end_date": "2027-01-01T00:00:00Z"
+	}`)
+	api.expect(t, rec, http.StatusCreated)
+	var created struct {
+		ID int `json:"id"`
+	}
+	api.decode(t, rec, &created)
+	return created.ID
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"infinity/models"
+	"infinity/store"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// maxIdempotencyKeyLength bounds the Idempotency-Key header
+const maxIdempotencyKeyLength = 255
+
+// replayedHeaders are the response headers stored with a key and sent again on a replay
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// idempotencyKeyTTL reads IDEMPOTENCY_KEY_TTL (a Go duration such as "48h"), defaulting to 24 hours
+func idempotencyKeyTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_KEY_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 24 * time.Hour
+}
+
+// responseRecorder passes a response through to the client while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotencyScope names the caller a key belongs to, so two callers picking
+// the same key never see each other's responses
+func idempotencyScope(r *http.Request) string {
+	if own, confined := callerPartner(r); confined {
+		return "partner:" + strconv.Itoa(own)
+	}
+	sub, _ := claimsFromRequest(r)["sub"].(string)
+	return "admin:" + sub
+}
+
+// idempotent makes a create endpoint safe to retry. A request carrying an
+// Idempotency-Key runs once; the response is stored with the key and a hash of
+// the request, and a retry with the same key gets that response again instead
+// of creating a second row. Reusing a key for a different request is a 422, and a
+// retry that arrives while the first request is still running a 409. Responses
+// with a 5xx status aren't kept, nor is a reservation whose handler panicked, so
+// the retry runs the request again
+func idempotent(keys store.IdempotencyStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeProblem(w, r, http.StatusBadRequest, CodeBadRequest,
+				fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength))
+			return
+		}
+
+		// Hash the request so the key can't be replayed for a different one
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			badRequest(w, r, fmt.Errorf("invalid request body: %v", err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.New()
+		fmt.Fprintf(sum, "%s %s\n", r.Method, r.URL.Path)
+		sum.Write(body)
+
+		reservation := models.IdempotencyKey{
+			Scope:       idempotencyScope(r),
+			Key:         key,
+			RequestHash: hex.EncodeToString(sum.Sum(nil)),
+			ExpiresAt:   time.Now().Add(idempotencyKeyTTL()),
+		}
+		err = keys.Reserve(r.Context(), &reservation)
+		if errors.Is(err, store.ErrConflict) {
+			replayIdempotent(w, r, keys, reservation)
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		release := func() {
+			if err := keys.Release(r.Context(), reservation.Scope, reservation.Key); err != nil {
+				log.Printf("releasing Idempotency-Key %q: %v", key, err)
+			}
+		}
+		// A panic would otherwise leave the key reserved, and every retry a 409, until it expired
+		defer func() {
+			if p := recover(); p != nil {
+				release()
+				panic(p)
+			}
+		}()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			release()
+			return
+		}
+		reservation.ResponseStatus = rec.status
+		reservation.ResponseHeader = make(http.Header)
+		for _, h := range replayedHeaders {
+			if v := w.Header().Values(h); len(v) > 0 {
+				reservation.ResponseHeader[http.CanonicalHeaderKey(h)] = v
+			}
+		}
+		reservation.ResponseBody = rec.body.Bytes()
+		if err := keys.Complete(r.Context(), &reservation); err != nil {
+			log.Printf("storing the response to Idempotency-Key %q: %v", key, err)
+		}
+	})
+}
+
+// replayIdempotent answers a request whose Idempotency-Key is already reserved
+func replayIdempotent(w http.ResponseWriter, r *http.Request, keys store.IdempotencyStore, k models.IdempotencyKey) {
+	stored, err := keys.Get(r.Context(), k.Scope, k.Key)
+	if errors.Is(err, store.ErrNotFound) {
+		// the key expired between the reservation attempt and now
+		writeProblem(w, r, http.StatusConflict, CodeIdempotencyKeyInUse, "the Idempotency-Key expired while being checked; retry the request")
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	switch {
+	case stored.RequestHash != k.RequestHash:
+		writeProblem(w, r, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused,
+			"the Idempotency-Key was already used for a different request")
+	case stored.ResponseStatus == 0:
+		writeProblem(w, r, http.StatusConflict, CodeIdempotencyKeyInUse,
+			"a request with this Idempotency-Key is still being processed")
+	default:
+		for h, v := range stored.ResponseHeader {
+			w.Header()[http.CanonicalHeaderKey(h)] = v
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(stored.ResponseStatus)
+		w.Write(stored.ResponseBody)
+	}
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"fmt"
+	"infinity/store"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// countingHandler creates "rows" by counting its calls and answering with the count
+func countingHandler(calls *int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		w.Header().Set("Location", fmt.Sprintf("/rows/%d", n))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"id":%d}`, n)
+	})
+}
+
+// post sends a request with the Idempotency-Key through h
+func post(h http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/rows", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotentReplaysTheStoredResponse(t *testing.T) {
+	var calls int32
+	h := idempotent(store.NewMemory().IdempotencyKeys, countingHandler(&calls))
+
+	first := post(h, "k1", `{"a":1}`)
+	if first.Code != http.StatusCreated || first.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("got %d %v on the first request", first.Code, first.Header())
+	}
+	again := post(h, "k1", `{"a":1}`)
+	if again.Code != http.StatusCreated || again.Body.String() != first.Body.String() ||
+		again.Header().Get("Location") != first.Header().Get("Location") || again.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("got %d %s %v, want the first response replayed", again.Code, again.Body.String(), again.Header())
+	}
+	if calls != 1 {
+		t.Errorf("the handler ran %d times, want once", calls)
+	}
+
+	// a new key, or none, runs the request
+	post(h, "k2", `{"a":1}`)
+	post(h, "", `{"a":1}`)
+	post(h, "", `{"a":1}`)
+	if calls != 4 {
+		t.Errorf("the handler ran %d times, want 4", calls)
+	}
+}
+
+func TestIdempotentRefusesAReusedKey(t *testing.T) {
+	var calls int32
+	h := idempotent(store.NewMemory().IdempotencyKeys, countingHandler(&calls))
+	post(h, "k1", `{"a":1}`)
+
+	for name, body := range map[string]string{"different body": `{"a":2}`, "no body": ""} {
+		rec := post(h, "k1", body)
+		if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), CodeIdempotencyKeyReused) {
+			t.Errorf("%s: got %d %s, want 422 %s", name, rec.Code, rec.Body.String(), CodeIdempotencyKeyReused)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("the handler ran %d times, want once", calls)
+	}
+	if rec := post(h, strings.Repeat("k", maxIdempotencyKeyLength+1), `{"a":1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("got %d for an overlong key, want 400", rec.Code)
+	}
+}
+
+func TestIdempotentRefusesARetryInFlight(t *testing.T) {
+	started, finish := make(chan struct{}), make(chan struct{})
+	var calls int32
+	h := idempotent(store.NewMemory().IdempotencyKeys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+			<-finish
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post(h, "k1", `{"a":1}`) }()
+	<-started
+
+	rec := post(h, "k1", `{"a":1}`)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), CodeIdempotencyKeyInUse) {
+		t.Errorf("got %d %s while the first request ran, want 409 %s", rec.Code, rec.Body.String(), CodeIdempotencyKeyInUse)
+	}
+	close(finish)
+	if first := <-done; first.Code != http.StatusCreated {
+		t.Fatalf("got %d for the first request", first.Code)
+	}
+	if rec := post(h, "k1", `{"a":1}`); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("got %d once the first request finished, want its response replayed", rec.Code)
+	}
+	if calls != 1 {
+		t.Errorf("the handler ran %d times, want once", calls)
+	}
+}
+
+func TestIdempotentReleasesFailedRequests(t *testing.T) {
+	var calls int32
+	fail := true
+	h := idempotent(store.NewMemory().IdempotencyKeys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	if rec := post(h, "k1", `{"a":1}`); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d, want the 503 passed through", rec.Code)
+	}
+	fail = false
+	if rec := post(h, "k1", `{"a":1}`); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("got %d, want the retry run again", rec.Code)
+	}
+	if calls != 2 {
+		t.Errorf("the handler ran %d times, want twice", calls)
+	}
+}
+
+func TestIdempotentReleasesOnPanic(t *testing.T) {
+	var calls int32
+	panicking := true
+	h := idempotent(store.NewMemory().IdempotencyKeys, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if panicking {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	func() {
+		// the panic still reaches the server, which logs it and drops the connection
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Fatalf("recovered %v, want the handler's panic", p)
+			}
+		}()
+		post(h, "k1", `{"a":1}`)
+	}()
+
+	panicking = false
+	if rec := post(h, "k1", `{"a":1}`); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("got %d %s, want the retry run again", rec.Code, rec.Body.String())
+	}
+	if calls != 2 {
+		t.Errorf("the handler ran %d times, want twice", calls)
+	}
+}
+
+func TestIdempotentCreateSubscription(t *testing.T) {
+	api := newTestAPI(t)
+	body := `{
+		"customer_msisdn": "+254700000001",
+		"subscription_date": "2026-01-01T00:00:00Z",
+		"status": "active",
+		"billing_amount": "10.00",
+		"currency": "KES",
+		"billing_cycle": "monthly",
+		"start_date": "2026-01-01T00:00:00Z",
+		"end_date": "2027-01-01T00:00:00Z"
+	}`
+
+	first := api.do(t, "POST", "/subscriptions", api.partnerA, body, "Idempotency-Key", "signup-1")
+	api.expect(t, first, http.StatusCreated)
+	again := api.do(t, "POST", "/subscriptions", api.partnerA, body, "Idempotency-Key", "signup-1")
+	api.expect(t, again, http.StatusCreated)
+	if again.Body.String() != first.Body.String() || again.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Errorf("got %s, want the first response %s", again.Body.String(), first.Body.String())
+	}
+	// keys are the caller's own, so another partner's key of the same name is unrelated
+	other := api.do(t, "POST", "/subscriptions", api.partnerB, body, "Idempotency-Key", "signup-1")
+	api.expect(t, other, http.StatusCreated)
+	if other.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("partner b got partner a's response")
+	}
+
+	if ids := api.listIDs(t, "/subscriptions?sort=id", "10", api.admin); len(ids) != 2 {
+		t.Errorf("got subscriptions %v, want one per partner", ids)
+	}
+}
@@ -1,87 +1,349 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"os"
-	"github.com/dgrijalva/jwt-go"
+	"infinity/store"
+	"log"
 	"net/http"
+	"os"
+	"strings"
 	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// accessTokenTTL is how long an access token issued by /jwt stays valid
+const accessTokenTTL = time.Hour
+
+// Values of the "token_use" claim, so a refresh token can't be presented as an access token
+const (
+	tokenUseAccess  = "access"
+	tokenUseRefresh = "refresh"
 )
 
+// revocations is consulted by ValidateJWT and the refresh flow.
+// It defaults to an in-memory list; main swaps in Redis when REDIS_URL is set
+var revocations store.RevocationList = store.NewMemoryRevocationList()
+
+// SetRevocationList replaces the revocation list used for every token check
+func SetRevocationList(l store.RevocationList) {
+	revocations = l
+}
+
+// refreshTokenTTL reads REFRESH_TOKEN_TTL (a Go duration such as "720h"), defaulting to 30 days
+func refreshTokenTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 30 * 24 * time.Hour
+}
+
 // Home is a handler function that writes "super secret area" to the ResponseWriter
 func Home(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "super secret area")
 }
 
+// newTokenID returns a random identifier for the jti claim and refresh token families
+func newTokenID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// signClaims signs the claims with the active key set
+func signClaims(claims jwt.MapClaims) (string, error) {
+	return keys.sign(claims)
+}
+
+// parseToken verifies the token's signature and expiry and returns its claims.
+// It does not consult the revocation list
+func parseToken(tokenStr string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenStr, keys.keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !token.Valid || !ok {
+		return nil, errors.New("invalid token")
+	}
+	if jti, _ := claims["jti"].(string); jti == "" {
+		return nil, errors.New("token has no jti")
+	}
+	return claims, nil
+}
+
+// claimExpiry returns the exp claim as a time
+func claimExpiry(claims jwt.MapClaims) time.Time {
+	exp, _ := claims["exp"].(float64)
+	return time.Unix(int64(exp), 0)
+}
+
 // CreateJWT generates a new JWT token with an expiration time of one hour
-func CreateJWT() (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
+// The subject identifies the caller, partnerID (when non-zero) the partner it acts for,
+// and the granted scopes are stored space separated in the "scope" claim
+func CreateJWT(subject string, partnerID int, scopes ...string) (string, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", err
+	}
+
+	claims := jwt.MapClaims{
+		"exp":       time.Now().Add(accessTokenTTL).Unix(),
+		"jti":       jti,
+		"sub":       subject,
+		"scope":     strings.Join(scopes, " "),
+		"token_use": tokenUseAccess,
+	}
+	if partnerID != 0 {
+		claims["partner_id"] = partnerID
+	}
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	return signClaims(claims)
+}
 
-	tokenStr, err := token.SignedString([]byte(os.Getenv("SECRET_KEY")))
+// createRefreshJWT generates a one-time-use refresh token.
+// Every token rotated out of the same login shares a family, so reuse of
+// an old refresh token can revoke the whole chain. No token of a family outlives
+// the refresh TTL counted from authTime, the login that started it.
+// keyID (when non-zero) is the API key that login exchanged
+func createRefreshJWT(subject string, partnerID, keyID int, scope, family string, authTime time.Time) (string, error) {
+	jti, err := newTokenID()
 	if err != nil {
 		return "", err
 	}
 
-	return tokenStr, nil
+	exp := time.Now().Add(refreshTokenTTL())
+	if limit := authTime.Add(refreshTokenTTL()); limit.Before(exp) {
+		exp = limit
+	}
+
+	claims := jwt.MapClaims{
+		"exp":       exp.Unix(),
+		"jti":       jti,
+		"sub":       subject,
+		"scope":     scope,
+		"family":    family,
+		"auth_time": authTime.Unix(),
+		"token_use": tokenUseRefresh,
+	}
+	if partnerID != 0 {
+		claims["partner_id"] = partnerID
+	}
+	if keyID != 0 {
+		claims["key_id"] = keyID
+	}
+
+	return signClaims(claims)
+}
+
+type contextKey int
+
+const claimsKey contextKey = iota
+
+// claimsFromRequest returns the claims ValidateJWT attached to the request
+func claimsFromRequest(r *http.Request) jwt.MapClaims {
+	claims, _ := r.Context().Value(claimsKey).(jwt.MapClaims)
+	return claims
 }
 
 // ValidateJWT is a middleware function that validates the JWT token in the "Token" header
-// If the token is valid, the next handler is called. Otherwise, a 401 Unauthorized response is returned
-func ValidateJWT(next http.Handler) http.Handler {
+// If the token is valid, not revoked and carries every one of the given scopes, the next handler is called.
+// A missing, invalid or revoked token gets a 401 Unauthorized response, a missing scope a 403 Forbidden
+func ValidateJWT(next http.Handler, scopes ...string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenStr := r.Header.Get("Token")
 
 		// If there's no token in the request header, return a 401 Unauthorized response
 		if tokenStr == "" {
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprint(w, "not authorized")
+			writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "not authorized")
 			return
 		}
 
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			// Make sure the token's signing method is HMAC
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-			}
-			return []byte(os.Getenv("SECRET_KEY")), nil
-		})
+		// The parser's reason stays in the server log; telling the caller which check
+		// failed would only help someone forging tokens
+		claims, err := parseToken(tokenStr)
+		if err != nil {
+			log.Printf("%s %s: rejected token: %v", r.Method, r.URL.Path, err)
+			writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "not authorized: invalid token")
+			return
+		}
+
+		// Refresh tokens are only good for /jwt
+		if claims["token_use"] == tokenUseRefresh {
+			writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "not authorized: refresh tokens can't be used for API calls")
+			return
+		}
 
+		revoked, err := revocations.IsRevoked(r.Context(), claims["jti"].(string))
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprintf(w, "not authorized: %v", err)
+			writeError(w, r, err)
+			return
+		}
+		if revoked {
+			writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "not authorized: token revoked")
 			return
 		}
 
-		if !token.Valid {
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprint(w, "not authorized")
+		// The token is genuine; make sure it was granted what this route needs
+		if missing := missingScope(claims["scope"], scopes); missing != "" {
+			writeProblem(w, r, http.StatusForbidden, CodeForbidden, fmt.Sprintf("forbidden: missing scope %s", missing))
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
 	})
 }
 
-// GetJWT is a handler function that generates a JWT token and returns it in the ResponseWriter
-func GetJWT(w http.ResponseWriter, r *http.Request) {
-	apiKey := r.Header.Get("Access")
+// issueTokens writes a fresh access token to the body and its refresh token to the "Refresh-Token" header
+func issueTokens(w http.ResponseWriter, r *http.Request, subject string, partnerID, keyID int, scope, family string, authTime time.Time) {
+	refresh, err := createRefreshJWT(subject, partnerID, keyID, scope, family, authTime)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	token, err := CreateJWT(subject, partnerID, strings.Fields(scope)...)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Refresh-Token", refresh)
+	fmt.Fprint(w, token)
+}
+
+// GetJWT is a handler function that issues a JWT token and a refresh token
+// A request either exchanges the API key in the "Access" header, where the ADMIN_API_KEY
+// yields an admin token and any other key must be an active partner key, or rotates the
+// refresh token in the "Refresh" header, which can be used exactly once
+func GetJWT(keys store.APIKeyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if refresh := r.Header.Get("Refresh"); refresh != "" {
+			refreshJWT(w, r, keys, refresh)
+			return
+		}
+
+		apiKey := r.Header.Get("Access")
+
+		// If there's no API key in the request header, return a 401 Unauthorized response
+		if apiKey == "" {
+			writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "not authorized")
+			return
+		}
+
+		family, err := newTokenID()
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	// If the "Access" header doesn't contain the API key, return a 401 Unauthorized response
-	if apiKey != apiKey {
-		w.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprint(w, "not authorized")
+		if adminKey := os.Getenv("ADMIN_API_KEY"); adminKey != "" && subtle.ConstantTimeCompare([]byte(apiKey), []byte(adminKey)) == 1 {
+			issueTokens(w, r, "admin", 0, 0, strings.Join(AdminScopes, " "), family, time.Now())
+			return
+		}
+
+		// Look the key up by its hash and refuse unknown or revoked keys
+		key, err := keys.GetByHash(r.Context(), hashAPIKey(apiKey))
+		if errors.Is(err, store.ErrNotFound) || (err == nil && key.RevokedAt != nil) {
+			writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "not authorized")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if err := keys.MarkUsed(r.Context(), key.ID, time.Now()); err != nil {
+			log.Printf("failed to record use of API key %d: %v", key.ID, err)
+		}
+
+		issueTokens(w, r, fmt.Sprintf("partner:%d", key.PartnerID), key.PartnerID, key.ID, strings.Join(PartnerScopes, " "), family, time.Now())
+	}
+}
+
+// refreshJWT rotates a refresh token: the presented token is spent and a new pair is issued.
+// Presenting a spent token again means it leaked, so its whole family is revoked.
+// A partner's token is refused once the API key it came from is revoked or its partner deleted
+func refreshJWT(w http.ResponseWriter, r *http.Request, keys store.APIKeyStore, refresh string) {
+	claims, err := parseToken(refresh)
+	authTime, _ := claims["auth_time"].(float64)
+	if err != nil || claims["token_use"] != tokenUseRefresh || authTime == 0 {
+		writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "not authorized: invalid refresh token")
 		return
 	}
+	family, _ := claims["family"].(string)
+	partnerID, _ := claims["partner_id"].(float64)
+	keyID, _ := claims["key_id"].(float64)
 
-	token, err := CreateJWT()
+	revoked, err := revocations.IsRevoked(r.Context(), "family:"+family)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "error creating token: %v", err)
+		writeError(w, r, err)
+		return
+	}
+	if revoked {
+		writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "not authorized: token revoked")
 		return
 	}
 
-	fmt.Fprint(w, token)
-}
\ No newline at end of file
+	// The login is only as good as the API key it exchanged
+	if partnerID != 0 {
+		key, err := keys.Get(r.Context(), int(keyID))
+		if errors.Is(err, store.ErrNotFound) || (err == nil && (key.RevokedAt != nil || key.PartnerID != int(partnerID))) {
+			writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "not authorized: API key revoked")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	// Spend the token; losing this race means somebody else already used it
+	fresh, err := revocations.Revoke(r.Context(), claims["jti"].(string), claimExpiry(claims))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if !fresh {
+		if _, err := revocations.Revoke(r.Context(), "family:"+family, time.Now().Add(refreshTokenTTL())); err != nil {
+			log.Printf("failed to revoke token family %s: %v", family, err)
+		}
+		writeProblem(w, r, http.StatusUnauthorized, CodeUnauthorized, "not authorized: refresh token already used")
+		return
+	}
+
+	subject, _ := claims["sub"].(string)
+	scope, _ := claims["scope"].(string)
+	issueTokens(w, r, subject, int(partnerID), int(keyID), scope, family, time.Unix(int64(authTime), 0))
+}
+
+// Logout revokes the access token in the "Token" header and, when a refresh
+// token is passed in the "Refresh" header, every token rotated from it
+// It must be mounted behind ValidateJWT
+func Logout(w http.ResponseWriter, r *http.Request) {
+	claims := claimsFromRequest(r)
+	if _, err := revocations.Revoke(r.Context(), claims["jti"].(string), claimExpiry(claims)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if refresh := r.Header.Get("Refresh"); refresh != "" {
+		refreshClaims, err := parseToken(refresh)
+		if err != nil || refreshClaims["token_use"] != tokenUseRefresh || refreshClaims["sub"] != claims["sub"] {
+			writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "invalid refresh token")
+			return
+		}
+		family, _ := refreshClaims["family"].(string)
+		if _, err := revocations.Revoke(r.Context(), "family:"+family, time.Now().Add(refreshTokenTTL())); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	fmt.Fprint(w, "logged out")
+}
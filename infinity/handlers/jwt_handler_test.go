@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestValidateJWTHidesTheReason(t *testing.T) {
+	api := newTestAPI(t)
+	useKeySet(t, &KeySet{verify: map[string]*jwtKey{}})
+	var logged bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(previous) })
+
+	expired := testClaims()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	noJTI := testClaims()
+	delete(noJTI, "jti")
+	sign := func(claims jwt.MapClaims, secret string) string {
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		reason string // what the log says, and the response must not
+	}{
+		{"expired", sign(expired, "test-secret"), "expired"},
+		{"wrong secret", sign(testClaims(), "other-secret"), "signature"},
+		{"no jti", sign(noJTI, "test-secret"), "jti"},
+		{"garbage", "not-a-token", "segments"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged.Reset()
+			rec := api.do(t, "GET", "/api", tt.token, "")
+			api.expect(t, rec, http.StatusUnauthorized)
+			var problem Problem
+			api.decode(t, rec, &problem)
+			if problem.Detail != "not authorized: invalid token" || problem.Code != CodeUnauthorized {
+				t.Errorf("got %s %q, want the fixed detail", problem.Code, problem.Detail)
+			}
+			if !strings.Contains(logged.String(), tt.reason) {
+				t.Errorf("logged %q, want the reason %q", logged.String(), tt.reason)
+			}
+		})
+	}
+}
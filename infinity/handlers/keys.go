@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// signingMethodEdDSA implements the "EdDSA" algorithm (RFC 8037) with Ed25519 keys,
+// which this version of jwt-go does not ship
+type signingMethodEdDSA struct{}
+
+// SigningMethodEdDSA signs tokens with an ed25519.PrivateKey
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// jwtKey is one asymmetric key identified by its kid
+type jwtKey struct {
+	id      string
+	method  jwt.SigningMethod
+	public  crypto.PublicKey
+	private crypto.Signer // nil for verification-only keys
+}
+
+// KeySet holds the key new tokens are signed with and every key tokens are still accepted from
+type KeySet struct {
+	signing *jwtKey
+	verify  map[string]*jwtKey
+	// acceptHMAC keeps HS256 tokens signed with SECRET_KEY valid after a signing key
+	// is loaded, while tokens issued before the switch run out
+	acceptHMAC bool
+}
+
+// keys is the active key set; when it has no signing key tokens fall back to HS256 with SECRET_KEY
+var keys = &KeySet{verify: map[string]*jwtKey{}}
+
+// SetKeySet replaces the keys used to sign and verify tokens
+func SetKeySet(ks *KeySet) {
+	keys = ks
+}
+
+// LoadKeySetFromEnv reads PEM files named by the environment:
+// JWT_SIGNING_KEY_FILE is the RSA or Ed25519 private key new tokens are signed with and
+// JWT_SIGNING_KEY_ID its kid; JWT_VERIFY_KEY_FILES is a comma separated list of
+// "kid=path" entries for older keys that are still accepted while keys rotate.
+// A kid left out is derived from the public key. Once a signing key is loaded HMAC
+// tokens are refused, unless JWT_ACCEPT_HMAC=true keeps them valid during the switch
+func LoadKeySetFromEnv() (*KeySet, error) {
+	ks := &KeySet{verify: map[string]*jwtKey{}}
+
+	if v := os.Getenv("JWT_ACCEPT_HMAC"); v != "" {
+		accept, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_ACCEPT_HMAC: %v", err)
+		}
+		ks.acceptHMAC = accept
+	}
+
+	if path := os.Getenv("JWT_SIGNING_KEY_FILE"); path != "" {
+		k, err := loadKeyFile(os.Getenv("JWT_SIGNING_KEY_ID"), path)
+		if err != nil {
+			return nil, err
+		}
+		if k.private == nil {
+			return nil, fmt.Errorf("%s: signing key must be a private key", path)
+		}
+		ks.signing = k
+		ks.verify[k.id] = k
+	}
+
+	for _, entry := range strings.Split(os.Getenv("JWT_VERIFY_KEY_FILES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kid, path, ok := strings.Cut(entry, "=")
+		if !ok {
+			kid, path = "", entry
+		}
+		k, err := loadKeyFile(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := ks.verify[k.id]; dup {
+			return nil, fmt.Errorf("%s: duplicate key id %q", path, k.id)
+		}
+		ks.verify[k.id] = k
+	}
+
+	return ks, nil
+}
+
+// loadKeyFile parses a PEM file holding an RSA or Ed25519 key, private or public
+func loadKeyFile(kid, path string) (*jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	k := &jwtKey{id: kid}
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		k.method, k.public, k.private = jwt.SigningMethodRS256, &key.PublicKey, key
+	case *rsa.PublicKey:
+		k.method, k.public = jwt.SigningMethodRS256, key
+	case ed25519.PrivateKey:
+		k.method, k.public, k.private = SigningMethodEdDSA, key.Public(), key
+	case ed25519.PublicKey:
+		k.method, k.public = SigningMethodEdDSA, key
+	default:
+		return nil, fmt.Errorf("%s: only RSA and Ed25519 keys are supported", path)
+	}
+
+	if k.id == "" {
+		der, err := x509.MarshalPKIXPublicKey(k.public)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		sum := sha256.Sum256(der)
+		k.id = hex.EncodeToString(sum[:8])
+	}
+	return k, nil
+}
+
+// sign signs the claims with the active signing key, or HS256 when none is configured
+func (ks *KeySet) sign(claims jwt.MapClaims) (string, error) {
+	if ks.signing == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(os.Getenv("SECRET_KEY")))
+	}
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	token.Header["kid"] = ks.signing.id
+	return token.SignedString(ks.signing.private)
+}
+
+// keyFunc picks the verification key for a token: HMAC tokens use SECRET_KEY when it
+// is set and no signing key has replaced it, asymmetric tokens the key named by their
+// kid header. Refusing HMAC once keys are public stops a token being forged with a
+// public key as its secret
+func (ks *KeySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+		if ks.signing != nil && !ks.acceptHMAC {
+			return nil, errors.New("HMAC signed tokens are not accepted")
+		}
+		secret := os.Getenv("SECRET_KEY")
+		if secret == "" {
+			return nil, errors.New("HMAC signed tokens are not accepted")
+		}
+		return []byte(secret), nil
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	k, ok := ks.verify[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if k.method.Alg() != t.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+	return k.public, nil
+}
+
+// jwk is the JSON Web Key (RFC 7517) form of a public key
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS publishes the public half of every verification key at /.well-known/jwks.json
+// so partner gateways can verify tokens without sharing a secret
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	ids := make([]string, 0, len(keys.verify))
+	for id := range keys.verify {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: []jwk{}}
+	for _, id := range ids {
+		k := keys.verify[id]
+		entry := jwk{Kid: k.id, Use: "sig", Alg: k.method.Alg()}
+		switch pub := k.public.(type) {
+		case *rsa.PublicKey:
+			entry.Kty = "RSA"
+			entry.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			entry.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			entry.Kty = "OKP"
+			entry.Crv = "Ed25519"
+			entry.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(set); err != nil {
+		writeError(w, r, err)
+	}
+}
@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// testKeys are one RSA and one Ed25519 key pair, written as PEM files in every form LoadKeySetFromEnv reads
+type testKeys struct {
+	rsa     *rsa.PrivateKey
+	ed      ed25519.PrivateKey
+	files   map[string]string
+	rsaPEM  []byte
+	edPKIX  []byte
+	rsaPKIX []byte
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPKCS8, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPKIX, err := x509.MarshalPKIXPublicKey(edKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPKIX, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := &testKeys{rsa: rsaKey, ed: edKey, files: map[string]string{}, edPKIX: edPKIX, rsaPKIX: rsaPKIX}
+	dir := t.TempDir()
+	blocks := map[string]*pem.Block{
+		"rsa-private":     {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		"rsa-public":      {Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)},
+		"rsa-public-pkix": {Type: "PUBLIC KEY", Bytes: rsaPKIX},
+		"ed-private":      {Type: "PRIVATE KEY", Bytes: edPKCS8},
+		"ed-public":       {Type: "PUBLIC KEY", Bytes: edPKIX},
+		"certificate":     {Type: "CERTIFICATE", Bytes: []byte("not a key")},
+	}
+	for name, block := range blocks {
+		path := filepath.Join(dir, name+".pem")
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		k.files[name] = path
+	}
+	k.rsaPEM = pem.EncodeToMemory(blocks["rsa-public-pkix"])
+	k.files["not-pem"] = filepath.Join(dir, "not-pem.pem")
+	if err := os.WriteFile(k.files["not-pem"], []byte("hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// derivedKeyID is the kid LoadKeySetFromEnv gives a key whose PKIX encoding is der
+func derivedKeyID(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8])
+}
+
+// useKeySet makes ks the active key set for the rest of the test
+func useKeySet(t *testing.T, ks *KeySet) {
+	t.Helper()
+	previous := keys
+	SetKeySet(ks)
+	t.Cleanup(func() { SetKeySet(previous) })
+}
+
+// loadKeys loads a key set from the environment the test sets
+func loadKeys(t *testing.T, env map[string]string) (*KeySet, error) {
+	t.Helper()
+	for _, name := range []string{"JWT_SIGNING_KEY_FILE", "JWT_SIGNING_KEY_ID", "JWT_VERIFY_KEY_FILES", "JWT_ACCEPT_HMAC"} {
+		t.Setenv(name, env[name])
+	}
+	return LoadKeySetFromEnv()
+}
+
+func TestLoadKeySetFromEnv(t *testing.T) {
+	k := newTestKeys(t)
+	tests := []struct {
+		name      string
+		env       map[string]string
+		wantErr   bool
+		signing   string
+		verify    []string
+		acceptMAC bool
+	}{
+		{"nothing configured", map[string]string{}, false, "", nil, false},
+		{"RSA signing key with its kid", map[string]string{"JWT_SIGNING_KEY_FILE": k.files["rsa-private"], "JWT_SIGNING_KEY_ID": "2026-01"},
+			false, "2026-01", []string{"2026-01"}, false},
+		{"Ed25519 signing key with a derived kid", map[string]string{"JWT_SIGNING_KEY_FILE": k.files["ed-private"]},
+			false, derivedKeyID(k.edPKIX), []string{derivedKeyID(k.edPKIX)}, false},
+		// the PKCS1 and PKIX files hold the same key, so both derive the same kid
+		{"one key listed twice", map[string]string{"JWT_VERIFY_KEY_FILES": k.files["rsa-public"] + ", " + k.files["rsa-public-pkix"]},
+			true, "", nil, false},
+		{"older keys with their own kids", map[string]string{
+			"JWT_SIGNING_KEY_FILE": k.files["ed-private"], "JWT_SIGNING_KEY_ID": "new",
+			"JWT_VERIFY_KEY_FILES": "old=" + k.files["rsa-public"] + ",older=" + k.files["ed-public"],
+		}, false, "new", []string{"new", "old", "older"}, false},
+		{"HMAC kept during the switch", map[string]string{"JWT_SIGNING_KEY_FILE": k.files["rsa-private"], "JWT_ACCEPT_HMAC": "true"},
+			false, derivedKeyID(k.rsaPKIX), []string{derivedKeyID(k.rsaPKIX)}, true},
+		{"public signing key", map[string]string{"JWT_SIGNING_KEY_FILE": k.files["rsa-public"]}, true, "", nil, false},
+		{"unsupported PEM block", map[string]string{"JWT_SIGNING_KEY_FILE": k.files["certificate"]}, true, "", nil, false},
+		{"not PEM", map[string]string{"JWT_VERIFY_KEY_FILES": k.files["not-pem"]}, true, "", nil, false},
+		{"missing file", map[string]string{"JWT_SIGNING_KEY_FILE": k.files["rsa-private"] + ".missing"}, true, "", nil, false},
+		{"invalid JWT_ACCEPT_HMAC", map[string]string{"JWT_ACCEPT_HMAC": "sometimes"}, true, "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks, err := loadKeys(t, tt.env)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if signing := ""; ks.signing != nil {
+				signing = ks.signing.id
+				if signing != tt.signing {
+					t.Errorf("got signing key %q, want %q", signing, tt.signing)
+				}
+			} else if tt.signing != "" {
+				t.Errorf("got no signing key, want %q", tt.signing)
+			}
+			if len(ks.verify) != len(tt.verify) {
+				t.Errorf("got %d verification keys, want %v", len(ks.verify), tt.verify)
+			}
+			for _, id := range tt.verify {
+				if ks.verify[id] == nil {
+					t.Errorf("key %q isn't accepted", id)
+				}
+			}
+			if ks.acceptHMAC != tt.acceptMAC {
+				t.Errorf("got acceptHMAC %v, want %v", ks.acceptHMAC, tt.acceptMAC)
+			}
+		})
+	}
+}
+
+// testClaims are the claims of an access token parseToken accepts
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{"exp": time.Now().Add(time.Hour).Unix(), "jti": "test", "sub": "admin", "scope": "", "token_use": tokenUseAccess}
+}
+
+// signed signs the claims with method and key, naming kid in the header when it isn't empty
+func signed(t *testing.T, method jwt.SigningMethod, key interface{}, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, testClaims())
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestKeyFunc(t *testing.T) {
+	k := newTestKeys(t)
+	t.Setenv("SECRET_KEY", "test-secret")
+	ks, err := loadKeys(t, map[string]string{
+		"JWT_SIGNING_KEY_FILE": k.files["rsa-private"], "JWT_SIGNING_KEY_ID": "rsa",
+		"JWT_VERIFY_KEY_FILES": "ed=" + k.files["ed-public"],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeySet(t, ks)
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"RS256 with the signing key", signed(t, jwt.SigningMethodRS256, k.rsa, "rsa"), true},
+		{"EdDSA with a verification key", signed(t, SigningMethodEdDSA, k.ed, "ed"), true},
+		// the alg-confusion attack: the public key, which anyone can fetch, used as an HMAC secret
+		{"HS256 keyed with the public key PEM", signed(t, jwt.SigningMethodHS256, k.rsaPEM, "rsa"), false},
+		{"HS256 keyed with the public key DER", signed(t, jwt.SigningMethodHS256, k.rsaPKIX, "rsa"), false},
+		{"HS256 keyed with SECRET_KEY", signed(t, jwt.SigningMethodHS256, []byte("test-secret"), ""), false},
+		{"RS256 naming the Ed25519 key", signed(t, jwt.SigningMethodRS256, k.rsa, "ed"), false},
+		{"EdDSA naming the RSA key", signed(t, SigningMethodEdDSA, k.ed, "rsa"), false},
+		{"unknown kid", signed(t, jwt.SigningMethodRS256, k.rsa, "other"), false},
+		{"no kid", signed(t, jwt.SigningMethodRS256, k.rsa, ""), false},
+		{"alg none", signed(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "rsa"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseToken(tt.token)
+			if (err == nil) != tt.valid {
+				t.Errorf("got error %v, want the token accepted: %v", err, tt.valid)
+			}
+		})
+	}
+
+	t.Run("tokens the key set signs", func(t *testing.T) {
+		token, err := CreateJWT("admin", 0, ScopeAdmin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, _ := jwt.Parse(token, ks.keyFunc)
+		if parsed == nil || parsed.Header["kid"] != "rsa" || parsed.Method.Alg() != "RS256" {
+			t.Fatalf("got %+v, want an RS256 token naming kid rsa", parsed)
+		}
+		if _, err := parseToken(token); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestAcceptHMAC(t *testing.T) {
+	k := newTestKeys(t)
+	t.Setenv("SECRET_KEY", "test-secret")
+	legacy := signed(t, jwt.SigningMethodHS256, []byte("test-secret"), "")
+	forged := signed(t, jwt.SigningMethodHS256, k.rsaPEM, "")
+
+	tests := []struct {
+		name        string
+		env         map[string]string
+		legacyValid bool
+	}{
+		{"no signing key", map[string]string{}, true},
+		{"signing key", map[string]string{"JWT_SIGNING_KEY_FILE": k.files["rsa-private"]}, false},
+		{"signing key while switching", map[string]string{"JWT_SIGNING_KEY_FILE": k.files["rsa-private"], "JWT_ACCEPT_HMAC": "true"}, true},
+		{"signing key, switch over", map[string]string{"JWT_SIGNING_KEY_FILE": k.files["rsa-private"], "JWT_ACCEPT_HMAC": "false"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks, err := loadKeys(t, tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			useKeySet(t, ks)
+			if _, err := parseToken(legacy); (err == nil) != tt.legacyValid {
+				t.Errorf("a SECRET_KEY token got %v, want it accepted: %v", err, tt.legacyValid)
+			}
+			// accepting HMAC never means accepting the public key as its secret
+			if _, err := parseToken(forged); err == nil {
+				t.Error("a token keyed with the public key was accepted")
+			}
+		})
+	}
+
+	t.Run("without SECRET_KEY", func(t *testing.T) {
+		t.Setenv("SECRET_KEY", "")
+		useKeySet(t, &KeySet{verify: map[string]*jwtKey{}})
+		if _, err := parseToken(signed(t, jwt.SigningMethodHS256, []byte(""), "")); err == nil {
+			t.Error("a token keyed with an empty secret was accepted")
+		}
+	})
+}
+
+func TestValidateJWTRefusesForgedTokens(t *testing.T) {
+	k := newTestKeys(t)
+	ks, err := loadKeys(t, map[string]string{"JWT_SIGNING_KEY_FILE": k.files["rsa-private"], "JWT_SIGNING_KEY_ID": "rsa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeySet(t, ks)
+
+	handler := ValidateJWT(http.HandlerFunc(Home))
+	for name, token := range map[string]string{
+		"forged": signed(t, jwt.SigningMethodHS256, k.rsaPEM, "rsa"),
+		"signed": signed(t, jwt.SigningMethodRS256, k.rsa, "rsa"),
+	} {
+		req := httptest.NewRequest("GET", "/api", nil)
+		req.Header.Set("Token", token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		want := http.StatusOK
+		if name == "forged" {
+			want = http.StatusUnauthorized
+		}
+		if rec.Code != want {
+			t.Errorf("%s token: got status %d, want %d", name, rec.Code, want)
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	k := newTestKeys(t)
+	ks, err := loadKeys(t, map[string]string{
+		"JWT_SIGNING_KEY_FILE": k.files["rsa-private"], "JWT_SIGNING_KEY_ID": "b-rsa",
+		"JWT_VERIFY_KEY_FILES": "a-ed=" + k.files["ed-public"],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	useKeySet(t, ks)
+
+	rec := httptest.NewRecorder()
+	JWKS(rec, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("got status %d and content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+	if len(set.Keys) != 2 {
+		t.Fatalf("got keys %v, want 2", set.Keys)
+	}
+
+	// keys are listed by kid and carry nothing but their public half
+	ed, rs := set.Keys[0], set.Keys[1]
+	wantEd := map[string]string{"kty": "OKP", "kid": "a-ed", "use": "sig", "alg": "EdDSA", "crv": "Ed25519",
+		"x": base64.RawURLEncoding.EncodeToString(k.ed.Public().(ed25519.PublicKey))}
+	wantRS := map[string]string{"kty": "RSA", "kid": "b-rsa", "use": "sig", "alg": "RS256",
+		"n": base64.RawURLEncoding.EncodeToString(k.rsa.N.Bytes()), "e": "AQAB"}
+	for _, c := range []struct{ got, want map[string]string }{{ed, wantEd}, {rs, wantRS}} {
+		if len(c.got) != len(c.want) {
+			t.Errorf("got %v, want %v", c.got, c.want)
+			continue
+		}
+		for field, want := range c.want {
+			if c.got[field] != want {
+				t.Errorf("key %s: got %s %q, want %q", c.got["kid"], field, c.got[field], want)
+			}
+		}
+	}
+
+	// a gateway can rebuild the RSA key from the JWK and verify our tokens with it
+	n, err := base64.RawURLEncoding.DecodeString(rs["n"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+	token, err := CreateJWT("admin", 0, ScopeAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(token, func(*jwt.Token) (interface{}, error) { return pub, nil }); err != nil {
+		t.Errorf("the published key doesn't verify a token: %v", err)
+	}
+
+	t.Run("no keys", func(t *testing.T) {
+		useKeySet(t, &KeySet{verify: map[string]*jwtKey{}})
+		rec := httptest.NewRecorder()
+		JWKS(rec, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+		if body := rec.Body.String(); body != "{\"keys\":[]}\n" {
+			t.Errorf("got %s, want an empty key list", body)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestSubscriptionLifecycleHistory(t *testing.T) {
+	api := newTestAPI(t)
+	id := api.createSubscription(t, api.partnerA)
+	path := fmt.Sprintf("/subscriptions/%d", id)
+
+	steps := []struct {
+		action string
+		reason string
+		want   int
+	}{
+		{"suspend", "customer asked for a pause", http.StatusOK},
+		{"suspend", "twice", http.StatusConflict},
+		{"activate", "only pending subscriptions activate", http.StatusConflict},
+		{"resume", "paid up", http.StatusOK},
+		{"cancel", "customer left", http.StatusOK},
+		{"resume", "cancelled is final", http.StatusConflict},
+	}
+	for _, step := range steps {
+		rec := api.do(t, "POST", path+"/"+step.action, api.partnerA, fmt.Sprintf(`{"reason":%q}`, step.reason))
+		if rec.Code != step.want {
+			t.Fatalf("%s: got status %d, want %d: %s", step.action, rec.Code, step.want, rec.Body.String())
+		}
+	}
+
+	rec := api.do(t, "GET", path+"/history", api.partnerA, "")
+	api.expect(t, rec, http.StatusOK)
+	var history struct {
+		Data []struct {
+			SubscriptionID int    `json:"subscription_id"`
+			FromStatus     string `json:"from_status"`
+			ToStatus       string `json:"to_status"`
+			Reason         string `json:"reason"`
+			Actor          string `json:"actor"`
+		} `json:"data"`
+	}
+	api.decode(t, rec, &history)
+
+	// refused actions leave no record
+	want := []struct{ from, to, reason string }{
+		{"active", "suspended", "customer asked for a pause"},
+		{"suspended", "active", "paid up"},
+		{"active", "cancelled", "customer left"},
+	}
+	if len(history.Data) != len(want) {
+		t.Fatalf("got %d history entries, want %d: %s", len(history.Data), len(want), rec.Body.String())
+	}
+	for i, w := range want {
+		got := history.Data[i]
+		if got.SubscriptionID != id || got.FromStatus != w.from || got.ToStatus != w.to || got.Reason != w.reason {
+			t.Errorf("entry %d: got %+v, want %s -> %s (%q)", i, got, w.from, w.to, w.reason)
+		}
+		if got.Actor != "partner:1" {
+			t.Errorf("entry %d: got actor %q, want the caller", i, got.Actor)
+		}
+	}
+}
+
+func TestTransactionLifecycleEvents(t *testing.T) {
+	api := newTestAPI(t)
+	sub := api.createSubscription(t, api.partnerA)
+	rec := api.do(t, "POST", fmt.Sprintf("/subscriptions/%d/transactions", sub), api.partnerA, `{"amount":"10.00","currency":"KES","status":"pending"}`)
+	api.expect(t, rec, http.StatusCreated)
+	var created struct {
+		ID int `json:"id"`
+	}
+	api.decode(t, rec, &created)
+	path := fmt.Sprintf("/transactions/%d", created.ID)
+
+	steps := []struct {
+		patch string
+		want  int
+	}{
+		{`{"status":"succeeded"}`, http.StatusOK},
+		{`{"status":"pending"}`, http.StatusConflict},
+		{`{"amount":"20.00"}`, http.StatusUnprocessableEntity},
+		{`{"status":"partially_refunded"}`, http.StatusOK},
+		{`{"status":"refunded"}`, http.StatusOK},
+		{`{"status":"succeeded"}`, http.StatusConflict},
+	}
+	for _, step := range steps {
+		rec := api.do(t, "PATCH", path, api.partnerA, step.patch, "Content-Type", "application/merge-patch+json")
+		if rec.Code != step.want {
+			t.Fatalf("%s: got status %d, want %d: %s", step.patch, rec.Code, step.want, rec.Body.String())
+		}
+	}
+
+	rec = api.do(t, "GET", path+"/events", api.partnerA, "")
+	api.expect(t, rec, http.StatusOK)
+	var events struct {
+		Data []struct {
+			TransactionID int    `json:"transaction_id"`
+			FromStatus    string `json:"from_status"`
+			ToStatus      string `json:"to_status"`
+		} `json:"data"`
+	}
+	api.decode(t, rec, &events)
+
+	// the first event records the status the transaction was created with
+	want := []struct{ from, to string }{
+		{"", "pending"},
+		{"pending", "succeeded"},
+		{"succeeded", "partially_refunded"},
+		{"partially_refunded", "refunded"},
+	}
+	if len(events.Data) != len(want) {
+		t.Fatalf("got %d events, want %d: %s", len(events.Data), len(want), rec.Body.String())
+	}
+	for i, w := range want {
+		got := events.Data[i]
+		if got.TransactionID != created.ID || got.FromStatus != w.from || got.ToStatus != w.to {
+			t.Errorf("event %d: got %+v, want %q -> %q", i, got, w.from, w.to)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"infinity/store"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Page sizes for list endpoints; clients may ask for fewer rows but never more than maxPageLimit
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// cursor is the position encoded in next_cursor. Clients treat it as opaque;
+// it remembers the sort it was issued for so it can't be replayed under another
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"id"`
+}
+
+// listResponse is the envelope every list endpoint returns
+type listResponse[T any] struct {
+	Data       []T     `json:"data"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+func encodeCursor(c cursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(raw, &c) != nil || c.ID <= 0 {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	return c, nil
+}
+
+// parseSort reads the sort query parameter: a field name, prefixed with "-" for descending order
+func parseSort(r *http.Request, sortable []string) (store.Sort, error) {
+	v := r.URL.Query().Get("sort")
+	if v == "" {
+		return store.Sort{}, nil
+	}
+	s := store.Sort{Field: strings.TrimPrefix(v, "-"), Desc: strings.HasPrefix(v, "-")}
+	for _, field := range sortable {
+		if s.Field == field {
+			return s, nil
+		}
+	}
+	return s, fmt.Errorf("sort must be one of %s, optionally prefixed with -", strings.Join(sortable, ", "))
+}
+
+// parsePage reads the limit, sort and cursor query parameters.
+// sortable lists the fields the resource can be sorted by
+func parsePage(r *http.Request, sortable ...string) (store.Page, error) {
+	page := store.Page{Limit: defaultPageLimit}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return page, fmt.Errorf("limit must be a positive integer")
+		}
+		if n > maxPageLimit {
+			n = maxPageLimit
+		}
+		page.Limit = n
+	}
+
+	sort, err := parseSort(r, append([]string{"id"}, sortable...))
+	if err != nil {
+		return page, err
+	}
+	page.Sort = sort
+
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			return page, err
+		}
+		if c.Sort != page.Sort.String() {
+			return page, fmt.Errorf("cursor was issued for sort %q", c.Sort)
+		}
+		page.After = &store.Cursor{Value: c.Value, ID: c.ID}
+	}
+
+	return page, nil
+}
+
+// writePage encodes one page of items in the list envelope
+func writePage[T any](w http.ResponseWriter, r *http.Request, items []T, page store.Page, next *store.Cursor) {
+	resp := listResponse[T]{Data: items}
+	if resp.Data == nil {
+		resp.Data = []T{}
+	}
+	if next != nil {
+		encoded := encodeCursor(cursor{Sort: page.Sort.String(), Value: next.Value, ID: next.ID})
+		resp.NextCursor = &encoded
+	}
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		writeError(w, r, err)
+	}
+}
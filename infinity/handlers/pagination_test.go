@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// listIDs walks every page of path, limit rows at a time, and returns the IDs in order
+func (api *testAPI) listIDs(t *testing.T, path string, limit string, token string) []int {
+	t.Helper()
+	var ids []int
+	query := url.Values{"limit": {limit}}
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("paging did not end")
+		}
+		rec := api.do(t, "GET", path+"&"+query.Encode(), token, "")
+		api.expect(t, rec, http.StatusOK)
+		var page struct {
+			Data []struct {
+				ID int `json:"id"`
+			} `json:"data"`
+			NextCursor *string `json:"next_cursor"`
+		}
+		api.decode(t, rec, &page)
+		for _, row := range page.Data {
+			ids = append(ids, row.ID)
+		}
+		if page.NextCursor == nil {
+			return ids
+		}
+		query.Set("cursor", *page.NextCursor)
+	}
+}
+
+func TestCursorPaging(t *testing.T) {
+	api := newTestAPI(t)
+	for i := 0; i < 5; i++ {
+		api.createSubscription(t, api.partnerA)
+	}
+	// another partner's rows never show up on, or shift, the caller's pages
+	api.createSubscription(t, api.partnerB)
+
+	tests := []struct {
+		name  string
+		sort  string
+		limit string
+		want  []int
+	}{
+		{"one page", "id", "10", []int{1, 2, 3, 4, 5}},
+		{"exact pages", "id", "5", []int{1, 2, 3, 4, 5}},
+		{"several pages", "id", "2", []int{1, 2, 3, 4, 5}},
+		{"descending", "-id", "2", []int{5, 4, 3, 2, 1}},
+		{"by a column with ties", "start_date", "2", []int{1, 2, 3, 4, 5}},
+		{"by a column with ties descending", "-start_date", "3", []int{5, 4, 3, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := api.listIDs(t, "/subscriptions?sort="+tt.sort, tt.limit, api.partnerA)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursorPagingSkipsNothingAfterDeletes(t *testing.T) {
+	api := newTestAPI(t)
+	for i := 0; i < 4; i++ {
+		api.createSubscription(t, api.partnerA)
+	}
+
+	rec := api.do(t, "GET", "/subscriptions?limit=2", api.partnerA, "")
+	api.expect(t, rec, http.StatusOK)
+	var page struct {
+		NextCursor *string `json:"next_cursor"`
+	}
+	api.decode(t, rec, &page)
+
+	// the cursor holds a position, not an offset, so removing a row already seen shifts nothing
+	api.expect(t, api.do(t, "DELETE", "/subscriptions/1", api.partnerA, ""), http.StatusNoContent)
+	rec = api.do(t, "GET", "/subscriptions?limit=2&cursor="+*page.NextCursor, api.partnerA, "")
+	api.expect(t, rec, http.StatusOK)
+	var next struct {
+		Data []struct {
+			ID int `json:"id"`
+		} `json:"data"`
+	}
+	api.decode(t, rec, &next)
+	if len(next.Data) != 2 || next.Data[0].ID != 3 || next.Data[1].ID != 4 {
+		t.Errorf("got %v, want subscriptions 3 and 4", next.Data)
+	}
+}
+
+func TestCursorErrors(t *testing.T) {
+	api := newTestAPI(t)
+	for i := 0; i < 3; i++ {
+		api.createSubscription(t, api.partnerA)
+	}
+	rec := api.do(t, "GET", "/subscriptions?limit=1&sort=-id", api.partnerA, "")
+	api.expect(t, rec, http.StatusOK)
+	var page struct {
+		NextCursor *string `json:"next_cursor"`
+	}
+	api.decode(t, rec, &page)
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"not base64", "cursor=!!!"},
+		{"not a cursor", "cursor=" + encodeCursor(cursor{Sort: "id"})[:4]},
+		{"issued for another sort", "sort=id&cursor=" + *page.NextCursor},
+		{"zero limit", "limit=0"},
+		{"unknown sort", "sort=colour"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api.expect(t, api.do(t, "GET", "/subscriptions?"+tt.query, api.partnerA, ""), http.StatusBadRequest)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"infinity/models"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// pathID parses the named route variable as a positive integer ID
+func pathID(r *http.Request, name string) (int, error) {
+	raw, ok := mux.Vars(r)[name]
+	if !ok {
+		return 0, fmt.Errorf("missing %s", name)
+	}
+	id, err := strconv.Atoi(raw)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, raw)
+	}
+	return id, nil
+}
+
+// decodeBody decodes the JSON request body into v.
+// Fields v doesn't have are rejected rather than silently dropped, and
+// a model that rejects a field value while decoding reports it unchanged
+func decodeBody(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var invalid *models.ValidationError
+		if errors.As(err, &invalid) {
+			return err
+		}
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	return nil
+}
@@ -1,241 +1,393 @@
 package handlers
 
 import (
-	"fmt"
-	"net/http"
-	"context"
 	"encoding/json"
-	"time"
-	"infinity/database"
+	"errors"
 	"infinity/models"
+	"infinity/store"
+	"net/http"
+
 	"github.com/gorilla/mux"
 )
 
+// view all partners
+func getAllPartnersHandler(partners store.PartnerStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
 
+		filter, err := parsePartnerFilter(r)
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+		page, err := parsePage(r)
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
 
-// view all partners
-func getAllPartnersHandler(w http.ResponseWriter, r *http.Request) {
-	// Set response header
-	w.Header().Set("Content-Type", "application/json")
-
-	// Create a new database connection
-	db, err := database.Connectdb()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error connecting to database: %v", err), http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
-	// Query the partners table
-	rows, err := db.Query("SELECT id, name, email, phone_number, billing_address, created_at, updated_at FROM partners")
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error querying database: %v", err), http.StatusInternalServerError)
-		return
+		// Load one page of partners from the store; a partner token only sees itself
+		var list []models.Partner
+		var next *store.Cursor
+		if own, confined := callerPartner(r); confined {
+			// a single row never continues onto a second page
+			if page.After == nil {
+				var partner models.Partner
+				partner, err = partners.Get(r.Context(), own)
+				if err == nil {
+					list = append(list, partner)
+				} else if errors.Is(err, store.ErrNotFound) {
+					err = nil
+				}
+			}
+		} else {
+			list, next, err = partners.List(r.Context(), filter, page)
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Encode the page of Partner objects in JSON format and write it to the response
+		writePage(w, r, list, page, next)
 	}
-	defer rows.Close()
+}
 
-	// Build an array of Partner objects
-	var partners []models.Partner
-	for rows.Next() {
-		var partner models.Partner
-		err := rows.Scan(&partner.ID, &partner.Name, &partner.Email, &partner.PhoneNumber, &partner.BillingAddress, &partner.CreatedAt, &partner.UpdatedAt)
+// find a partner
+func getPartner(partners store.PartnerStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
+
+		// Parse partner ID from request URL
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		// Other partners' rows are reported as missing to a partner token
+		if !canAccessPartner(r, id) {
+			notFound(w, r, "partner")
+			return
+		}
+
+		// Soft-deleted partners are missing unless an admin asks for them
+		include, err := includeDeleted(r)
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+		get := partners.Get
+		if include {
+			get = partners.GetWithDeleted
+		}
+		partner, err := get(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "partner")
+			return
+		}
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error scanning row: %v", err), http.StatusInternalServerError)
+			writeError(w, r, err)
 			return
 		}
-		partners = append(partners, partner)
+
+		if notModified(w, r, partner.Version) {
+			return
+		}
+		setETag(w, partner.Version)
+
+		// Encode the Partner object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(partner); err != nil {
+			writeError(w, r, err)
+		}
 	}
+}
+
+// create a partner
+func createPartner(partners store.PartnerStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the request body into a Partner struct
+		var partner models.Partner
+		if err := decodeBody(r, &partner); err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		// Fill in the default dunning policy for whatever the request left out
+		partner.SetDunningDefaults()
+
+		// Validate the partner data
+		if err := models.Validate(&partner); err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	// Encode the array of Partner objects in JSON format and write it to the response
-	if err := json.NewEncoder(w).Encode(partners); err != nil {
-		http.Error(w, fmt.Sprintf("Error encoding JSON: %v", err), http.StatusInternalServerError)
-		return
+		// Insert the new partner
+		if err := partners.Create(r.Context(), &partner); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Set the response status code to 201 Created and include the new partner's ID in the response body
+		w.Header().Set("Content-Type", "application/json")
+		setETag(w, partner.Version)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int{"id": partner.ID})
 	}
 }
 
+// update a partner
+func updatePartner(partners store.PartnerStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
 
-//find a partner
-func getPartner(w http.ResponseWriter, r *http.Request) {
-	// Set response header
-	w.Header().Set("Content-Type", "application/json")
+		// Get the partner ID from the request URL
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
 
-	// Parse partner ID from request URL
-	vars := mux.Vars(r)
-	id := vars["id"]
+		// Parse the request body into a Partner object
+		var partner models.Partner
+		if err := decodeBody(r, &partner); err != nil {
+			badRequest(w, r, err)
+			return
+		}
+		defer r.Body.Close()
 
-	// Get database connection from the pool
-	db, err := database.Connectdb()
+		if !canAccessPartner(r, id) {
+			notFound(w, r, "partner")
+			return
+		}
 
-	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+		version, err := ifMatch(r, func() (int, error) {
+			current, err := partners.Get(r.Context(), id)
+			return current.Version, err
+		})
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "partner")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	// Prepare SQL statement
-	stmt, err := db.PrepareContext(ctx, "SELECT id, name, email, phone_number, billing_address, created_at, updated_at FROM partners WHERE id=$1")
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error preparing statement: %v", err), http.StatusInternalServerError)
-		return
-	}
-	defer stmt.Close()
+		// Fill in the default dunning policy for whatever the request left out
+		partner.SetDunningDefaults()
 
-	// Execute SQL query with the prepared statement
-	row := stmt.QueryRowContext(ctx, id)
+		// Validate the new partner data
+		if err := models.Validate(&partner); err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	// Scan the row into a Partner object
-	var partner models.Partner
-	err = row.Scan(&partner.ID, &partner.Name, &partner.Email, &partner.PhoneNumber, &partner.BillingAddress, &partner.CreatedAt, &partner.UpdatedAt)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error scanning row: %v", err), http.StatusInternalServerError)
-		return
-	}
+		// Update the partner; the store refreshes it with the stored row
+		partner.ID, partner.Version = id, version
+		err = partners.Update(r.Context(), &partner)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "partner")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	// Encode the Partner object in JSON format and write it to the response
-	if err := json.NewEncoder(w).Encode(partner); err != nil {
-		http.Error(w, fmt.Sprintf("Error encoding JSON: %v", err), http.StatusInternalServerError)
+		setETag(w, partner.Version)
+		// Encode the Partner object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(partner); err != nil {
+			writeError(w, r, err)
+		}
 	}
 }
 
-//create a partmer
-func createPartner(w http.ResponseWriter, r *http.Request) {
-	// Parse the request body into a Partner struct
-	var partner models.Partner
-	err := json.NewDecoder(r.Body).Decode(&partner)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+// patch a partner
+func patchPartner(partners store.PartnerStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
 
-	// Validate the partner data
-	if partner.Name == "" || partner.Email == "" {
-		http.Error(w, "Name and Email are required fields", http.StatusBadRequest)
-		return
-	}
+		// Get the partner ID from the request URL
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
 
-	// Create a new database connection
-	db, err := database.Connectdb()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
-
-	// Insert the new partner into the partners table
-	sqlStatement := `INSERT INTO partners (name, email, phone_number, billing_address, created_at, updated_at)
-					 VALUES ($1, $2, $3, $4, $5, $6)
-					 RETURNING id`
-	var id int
-	err = db.QueryRow(sqlStatement, partner.Name, partner.Email, partner.PhoneNumber, partner.BillingAddress, time.Now(), time.Now()).Scan(&id)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+		if !canAccessPartner(r, id) {
+			notFound(w, r, "partner")
+			return
+		}
 
-	// Set the response status code to 201 Created and include the new partner's ID in the response body
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]int{"id": id})
-}
+		current, err := partners.Get(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "partner")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-// update a partner
-func updatePartner(w http.ResponseWriter, r *http.Request) {
-	// Set response header
-	w.Header().Set("Content-Type", "application/json")
-
-	// Get the partner ID from the request URL
-	id, ok := mux.Vars(r)["id"]
-	if !ok {
-		http.Error(w, "missing partner ID", http.StatusBadRequest)
-		return
-	}
+		version, err := matchVersion(r, current.Version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	// Parse the request body into a Partner object
-	var partner models.Partner
-	if err := json.NewDecoder(r.Body).Decode(&partner); err != nil {
-		http.Error(w, fmt.Sprintf("error decoding request body: %v", err), http.StatusBadRequest)
-		return
-	}
-	defer r.Body.Close()
+		// Merge the patch into the stored partner and validate the result
+		partner, columns, err := decodePatch(r, current)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		partner.Version = version
+		partner.SetDunningDefaults()
+		if err := models.Validate(&partner); err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	db, err := database.Connectdb()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
-	
-	// Update the partner in the database
-	res, err := db.ExecContext(r.Context(), `
-		UPDATE partners 
-		SET name=$1, email=$2, phone_number=$3, billing_address=$4, updated_at=$5 
-		WHERE id=$6`,
-		partner.Name, partner.Email, partner.PhoneNumber, partner.BillingAddress, time.Now(), id)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("error updating partner: %v", err), http.StatusInternalServerError)
-		return
-	}
+		// Write only the patched columns; the store refreshes the partner with the stored row
+		err = partners.Patch(r.Context(), &partner, columns)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "partner")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	rowsAffected, err := res.RowsAffected()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("error getting rows affected: %v", err), http.StatusInternalServerError)
-		return
-	}
-	if rowsAffected == 0 {
-		http.Error(w, "partner not found", http.StatusNotFound)
-		return
+		setETag(w, partner.Version)
+		// Encode the Partner object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(partner); err != nil {
+			writeError(w, r, err)
+		}
 	}
+}
 
-	// Fetch the updated partner from the database
-	err = db.QueryRowContext(r.Context(), `
-		SELECT id, name, email, phone_number, billing_address, created_at, updated_at 
-		FROM partners 
-		WHERE id=$1`, id).Scan(&partner.ID, &partner.Name, &partner.Email, &partner.PhoneNumber, &partner.BillingAddress, &partner.CreatedAt, &partner.UpdatedAt)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("error fetching updated partner: %v", err), http.StatusInternalServerError)
-		return
-	}
+// delete a partner
+func deletePartner(partners store.PartnerStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the ID of the partner to delete from the URL parameters
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		if !canAccessPartner(r, id) {
+			notFound(w, r, "partner")
+			return
+		}
+
+		// cascade=true deletes the partner's subscriptions along with it
+		cascade, err := queryBool(r, "cascade")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
 
-	// Encode the Partner object in JSON format and write it to the response
-	if err := json.NewEncoder(w).Encode(partner); err != nil {
-		http.Error(w, fmt.Sprintf("error encoding response: %v", err), http.StatusInternalServerError)
+		version, err := ifMatch(r, func() (int, error) {
+			current, err := partners.Get(r.Context(), id)
+			return current.Version, err
+		})
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "partner")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Soft-delete the partner
+		err = partners.Delete(r.Context(), id, version, cascade)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "partner")
+			return
+		}
+		if errors.Is(err, store.ErrConflict) {
+			writeProblem(w, r, http.StatusConflict, CodeConflict,
+				"the partner has active subscriptions; cancel them first or delete with cascade=true")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// There is nothing left to show
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-//delete a partner
-func deletePartner(w http.ResponseWriter, r *http.Request) {
-	// Set response header
-	w.Header().Set("Content-Type", "application/json")
+// restore a soft-deleted partner
+func restorePartner(partners store.PartnerStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
 
-	// Get the ID of the partner to delete from the URL parameters
-	params := mux.Vars(r)
-	id := params["id"]
+		// Get the partner ID from the request URL
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
 
-	// Create a new database connection
-	db, err := database.Connectdb()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error connecting to database: %v", err), http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
+		// Restore the partner along with the subscriptions a cascading delete took with it;
+		// ones deleted on their own beforehand stay deleted
+		partner, err := partners.Restore(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "partner")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	// Delete the partner from the database
-	_, err = db.Exec("DELETE FROM partners WHERE id=$1", id)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting partner: %v", err), http.StatusInternalServerError)
-		return
+		setETag(w, partner.Version)
+		// Encode the Partner object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(partner); err != nil {
+			writeError(w, r, err)
+		}
 	}
-
-	// Write a success message to the response
-	fmt.Fprintf(w, "Partner %s deleted successfully", id)
 }
 
-func PartnersRouter() * mux.Router {
-	router  := mux.NewRouter()
-
-	// endpoints for partners
-	router.HandleFunc("/partners", getAllPartnersHandler).Methods("GET")
-	router.HandleFunc("/partners", createPartner).Methods("POST")
-	router.HandleFunc("/partners/{id}", getPartner).Methods("GET")
-	router.HandleFunc("/partners/{id}", updatePartner).Methods("PUT")
-	router.HandleFunc("/partners/{id}", deletePartner).Methods("DELETE")
+func PartnersRouter(stores store.Stores) *mux.Router {
+	router := mux.NewRouter()
+
+	// endpoints for partners, each guarded by a token carrying the listed scope
+	router.Handle("/partners", ValidateJWT(getAllPartnersHandler(stores.Partners), ScopePartnersRead)).Methods("GET")
+	router.Handle("/partners", ValidateJWT(createPartner(stores.Partners), ScopePartnersWrite)).Methods("POST")
+	router.Handle("/partners/{id}", ValidateJWT(getPartner(stores.Partners), ScopePartnersRead)).Methods("GET")
+	router.Handle("/partners/{id}", ValidateJWT(updatePartner(stores.Partners), ScopePartnersWrite)).Methods("PUT")
+	router.Handle("/partners/{id}", ValidateJWT(patchPartner(stores.Partners), ScopePartnersWrite)).Methods("PATCH")
+	router.Handle("/partners/{id}", ValidateJWT(deletePartner(stores.Partners), ScopePartnersWrite)).Methods("DELETE")
+	router.Handle("/partners/{id}/restore", ValidateJWT(restorePartner(stores.Partners), ScopeAdmin)).Methods("POST")
+
+	// the partner's plan catalog
+	router.Handle("/partners/{id}/plans", ValidateJWT(getPlans(stores), ScopePlansRead)).Methods("GET")
+	router.Handle("/partners/{id}/plans", ValidateJWT(createPlan(stores.Plans), ScopePlansWrite)).Methods("POST")
+	router.Handle("/partners/{id}/plans/{planID}", ValidateJWT(getPlan(stores.Plans), ScopePlansRead)).Methods("GET")
+	router.Handle("/partners/{id}/plans/{planID}", ValidateJWT(updatePlan(stores.Plans), ScopePlansWrite)).Methods("PUT")
+	router.Handle("/partners/{id}/plans/{planID}", ValidateJWT(patchPlan(stores.Plans), ScopePlansWrite)).Methods("PATCH")
+	router.Handle("/partners/{id}/plans/{planID}", ValidateJWT(deletePlan(stores.Plans), ScopePlansWrite)).Methods("DELETE")
+
+	// subscriptions nested under their partner
+	router.Handle("/partners/{id}/subscriptions", ValidateJWT(getPartnerSubscriptions(stores), ScopeSubscriptionsRead)).Methods("GET")
+
+	// admin endpoints for managing a partner's API keys
+	router.Handle("/partners/{id}/api-keys", ValidateJWT(createAPIKey(stores), ScopeAdmin)).Methods("POST")
+	router.Handle("/partners/{id}/api-keys", ValidateJWT(getAPIKeys(stores.APIKeys), ScopeAdmin)).Methods("GET")
+	router.Handle("/partners/{id}/api-keys/{keyID}", ValidateJWT(revokeAPIKey(stores.APIKeys), ScopeAdmin)).Methods("DELETE")
 
 	return router
-}
\ No newline at end of file
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"infinity/models"
+	"mime"
+	"net/http"
+	"sort"
+)
+
+// mergePatchContentType is the media type of RFC 7396 JSON merge patches.
+// Plain application/json is accepted as well
+const mergePatchContentType = "application/merge-patch+json"
+
+// readOnlyFields are set by the server and can't be patched
+var readOnlyFields = map[string]bool{
+	"id": true, "created_at": true, "updated_at": true, "deleted_at": true,
+	"next_billing_date": true, "billing_period": true,
+	"plan_id": true, "trial_ends_at": true,
+	"dunning_state": true, "failed_attempts": true, "next_attempt_at": true, "dunning_period": true, "attempt": true,
+}
+
+// mergePatch applies an RFC 7396 merge patch to target: members of an object
+// patch are merged recursively, null removes a member and anything else replaces it
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// decodePatch reads a merge patch from the request body and applies it to current.
+// It returns the merged model and the fields the patch sets, sorted; a field set
+// to null goes back to its zero value. Unknown and read-only fields are rejected
+func decodePatch[T any](r *http.Request, current T) (T, []string, error) {
+	var merged T
+
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mt != mergePatchContentType && mt != "application/json") {
+			return merged, nil, newProblem(http.StatusUnsupportedMediaType, CodeUnsupportedMediaType,
+				fmt.Sprintf("patches must be sent as %s", mergePatchContentType))
+		}
+	}
+
+	var patch map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		return merged, nil, newProblem(http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("invalid merge patch: %v", err))
+	}
+
+	var readOnly []models.FieldError
+	columns := make([]string, 0, len(patch))
+	for field := range patch {
+		if readOnlyFields[field] {
+			readOnly = append(readOnly, models.FieldError{Field: field, Rule: "readonly", Message: "cannot be changed"})
+			continue
+		}
+		columns = append(columns, field)
+	}
+	if len(readOnly) > 0 {
+		return merged, nil, &models.ValidationError{Fields: readOnly}
+	}
+	sort.Strings(columns)
+
+	// Round-trip the stored row through JSON so the patch merges into exactly what GET returns
+	raw, err := json.Marshal(current)
+	if err != nil {
+		return merged, nil, err
+	}
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return merged, nil, err
+	}
+	if raw, err = json.Marshal(mergePatch(doc, patch)); err != nil {
+		return merged, nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&merged); err != nil {
+		var invalid *models.ValidationError
+		if errors.As(err, &invalid) {
+			return merged, nil, err
+		}
+		return merged, nil, newProblem(http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("invalid merge patch: %v", err))
+	}
+	return merged, columns, nil
+}
+
+// withoutColumn drops column from the columns a patch writes
+func withoutColumn(columns []string, column string) []string {
+	kept := columns[:0]
+	for _, c := range columns {
+		if c != column {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// withAmount adds the amount column to a patch that changes the currency: amounts
+// are stored in minor units, so the stored amount is rewritten along with it
+func withAmount(columns []string, amount string) []string {
+	var currency bool
+	for _, c := range columns {
+		if c == amount {
+			return columns
+		}
+		currency = currency || c == "currency"
+	}
+	if !currency {
+		return columns
+	}
+	columns = append(columns, amount)
+	sort.Strings(columns)
+	return columns
+}
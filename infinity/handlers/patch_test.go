@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		target any
+		patch  any
+		want   any
+	}{
+		{"replace member", map[string]any{"a": "b"}, map[string]any{"a": "c"}, map[string]any{"a": "c"}},
+		{"add member", map[string]any{"a": "b"}, map[string]any{"b": "c"}, map[string]any{"a": "b", "b": "c"}},
+		{"null removes member", map[string]any{"a": "b", "b": "c"}, map[string]any{"a": nil}, map[string]any{"b": "c"}},
+		{"nested merge", map[string]any{"a": map[string]any{"b": "c", "d": "e"}}, map[string]any{"a": map[string]any{"d": nil, "f": "g"}}, map[string]any{"a": map[string]any{"b": "c", "f": "g"}}},
+		{"array replaced whole", map[string]any{"a": []any{"b", "c"}}, map[string]any{"a": []any{"d"}}, map[string]any{"a": []any{"d"}}},
+		{"non-object patch replaces", map[string]any{"a": "b"}, "c", "c"},
+		{"object patch over scalar", "a", map[string]any{"b": "c"}, map[string]any{"b": "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergePatch(tt.target, tt.patch); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchSubscription(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+		check       func(t *testing.T, sub map[string]any)
+	}{
+		{
+			name:        "sets only the patched fields",
+			contentType: "application/merge-patch+json",
+			body:        `{"customer_msisdn":"+254700000002","auto_renew":true}`,
+			want:        http.StatusOK,
+			check: func(t *testing.T, sub map[string]any) {
+				if sub["customer_msisdn"] != "+254700000002" || sub["auto_renew"] != true {
+					t.Errorf("patched fields not written: %v", sub)
+				}
+				if sub["billing_amount"] != "10.00" || sub["billing_cycle"] != "monthly" {
+					t.Errorf("fields left out of the patch changed: %v", sub)
+				}
+			},
+		},
+		{
+			name:        "plain JSON is accepted",
+			contentType: "application/json",
+			body:        `{"auto_renew":true}`,
+			want:        http.StatusOK,
+		},
+		{
+			name:        "null clears a required field",
+			contentType: "application/merge-patch+json",
+			body:        `{"customer_msisdn":null}`,
+			want:        http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "read-only field",
+			contentType: "application/merge-patch+json",
+			body:        `{"next_billing_date":"2030-01-01T00:00:00Z"}`,
+			want:        http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "unknown field",
+			contentType: "application/merge-patch+json",
+			body:        `{"colour":"blue"}`,
+			want:        http.StatusBadRequest,
+		},
+		{
+			name:        "status moves through its lifecycle instead",
+			contentType: "application/merge-patch+json",
+			body:        `{"status":"cancelled"}`,
+			want:        http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "not a JSON object",
+			contentType: "application/merge-patch+json",
+			body:        `[`,
+			want:        http.StatusBadRequest,
+		},
+		{
+			name:        "other media type",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"replace","path":"/auto_renew","value":true}]`,
+			want:        http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t)
+			path := fmt.Sprintf("/subscriptions/%d", api.createSubscription(t, api.partnerA))
+
+			rec := api.do(t, "PATCH", path, api.partnerA, tt.body, "Content-Type", tt.contentType)
+			api.expect(t, rec, tt.want)
+			if tt.check == nil {
+				return
+			}
+			var sub map[string]any
+			api.decode(t, api.do(t, "GET", path, api.partnerA, ""), &sub)
+			tt.check(t, sub)
+		})
+	}
+}
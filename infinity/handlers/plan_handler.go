@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"infinity/models"
+	"infinity/store"
+	"net/http"
+)
+
+// loadPlan finds the plan named by the {planID} path parameter in the catalog of
+// the {id} partner. A plan of another partner, or of a partner the caller may not
+// see, is reported as missing
+func loadPlan(r *http.Request, plans store.PlanStore) (models.Plan, error) {
+	partnerID, err := pathID(r, "id")
+	if err != nil {
+		return models.Plan{}, err
+	}
+	planID, err := pathID(r, "planID")
+	if err != nil {
+		return models.Plan{}, err
+	}
+	if !canAccessPartner(r, partnerID) {
+		return models.Plan{}, store.ErrNotFound
+	}
+	plan, err := plans.Get(r.Context(), planID)
+	if err == nil && plan.PartnerID != partnerID {
+		return models.Plan{}, store.ErrNotFound
+	}
+	return plan, err
+}
+
+// list a partner's plans
+func getPlans(stores store.Stores) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
+
+		// Get the partner ID from the request URL
+		partnerID, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+		if !canAccessPartner(r, partnerID) {
+			notFound(w, r, "partner")
+			return
+		}
+
+		// An empty catalog and a missing partner look different to the caller
+		if _, err := stores.Partners.Get(r.Context(), partnerID); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				notFound(w, r, "partner")
+				return
+			}
+			writeError(w, r, err)
+			return
+		}
+
+		plans, err := stores.Plans.ListByPartner(r.Context(), partnerID)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if plans == nil {
+			plans = []models.Plan{}
+		}
+
+		// Encode the Plan objects in JSON format and write them to the response
+		if err := json.NewEncoder(w).Encode(listResponse[models.Plan]{Data: plans}); err != nil {
+			writeError(w, r, err)
+		}
+	}
+}
+
+// find a plan
+func getPlan(plans store.PlanStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
+
+		plan, err := loadPlan(r, plans)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "plan")
+			return
+		}
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		if notModified(w, r, plan.Version) {
+			return
+		}
+		setETag(w, plan.Version)
+
+		// Encode the Plan object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(plan); err != nil {
+			writeError(w, r, err)
+		}
+	}
+}
+
+// create a plan in a partner's catalog
+func createPlan(plans store.PlanStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the partner ID from the request URL
+		partnerID, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+		if !canAccessPartner(r, partnerID) {
+			notFound(w, r, "partner")
+			return
+		}
+
+		// Parse the request body into a Plan struct
+		var plan models.Plan
+		if err := decodeBody(r, &plan); err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		// Validate the plan data
+		if err := models.Validate(&plan); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Insert the new plan; the partner comes from the URL
+		plan.PartnerID = partnerID
+		err = plans.Create(r.Context(), &plan)
+		if errors.Is(err, store.ErrInvalidReference) {
+			notFound(w, r, "partner")
+			return
+		}
+		if errors.Is(err, store.ErrConflict) {
+			writeProblem(w, r, http.StatusConflict, CodeConflict, "the partner already has a plan with this name")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Set the response status code to 201 Created and include the new plan's ID in the response body
+		w.Header().Set("Content-Type", "application/json")
+		setETag(w, plan.Version)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int{"id": plan.ID})
+	}
+}
+
+// update a plan; subscriptions already sold under it keep the price they were sold at
+func updatePlan(plans store.PlanStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
+
+		current, err := loadPlan(r, plans)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "plan")
+			return
+		}
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		// Parse the request body into a Plan object
+		var plan models.Plan
+		if err := decodeBody(r, &plan); err != nil {
+			badRequest(w, r, err)
+			return
+		}
+		defer r.Body.Close()
+
+		version, err := matchVersion(r, current.Version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Validate the new plan data
+		if err := models.Validate(&plan); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Update the plan; the store refreshes it with the stored row
+		plan.ID, plan.PartnerID, plan.Version = current.ID, current.PartnerID, version
+		err = plans.Update(r.Context(), &plan)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "plan")
+			return
+		}
+		if errors.Is(err, store.ErrConflict) {
+			writeProblem(w, r, http.StatusConflict, CodeConflict, "the partner already has a plan with this name")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		setETag(w, plan.Version)
+		// Encode the Plan object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(plan); err != nil {
+			writeError(w, r, err)
+		}
+	}
+}
+
+// patch a plan
+func patchPlan(plans store.PlanStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
+
+		current, err := loadPlan(r, plans)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "plan")
+			return
+		}
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		version, err := matchVersion(r, current.Version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Merge the patch into the stored plan and validate the result
+		plan, columns, err := decodePatch(r, current)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		// A plan stays in the catalog it was created in
+		if plan.PartnerID != current.PartnerID {
+			writeError(w, r, &models.ValidationError{Fields: []models.FieldError{{Field: "partner_id", Rule: "readonly", Message: "cannot be changed"}}})
+			return
+		}
+		columns = withAmount(withoutColumn(columns, "partner_id"), "price")
+		plan.Version = version
+		if err := models.Validate(&plan); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Write only the patched columns; the store refreshes the plan with the stored row
+		err = plans.Patch(r.Context(), &plan, columns)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "plan")
+			return
+		}
+		if errors.Is(err, store.ErrConflict) {
+			writeProblem(w, r, http.StatusConflict, CodeConflict, "the partner already has a plan with this name")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		setETag(w, plan.Version)
+		// Encode the Plan object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(plan); err != nil {
+			writeError(w, r, err)
+		}
+	}
+}
+
+// delete a plan; subscriptions sold under it carry on unchanged
+func deletePlan(plans store.PlanStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		current, err := loadPlan(r, plans)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "plan")
+			return
+		}
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		version, err := matchVersion(r, current.Version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Soft-delete the plan
+		err = plans.Delete(r.Context(), current.ID, version)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "plan")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// There is nothing left to show
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testPlan is a plan as the API shows it
+type testPlan struct {
+	ID           int    `json:"id"`
+	PartnerID    int    `json:"partner_id"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Price        string `json:"price"`
+	Currency     string `json:"currency"`
+	BillingCycle string `json:"billing_cycle"`
+	TrialDays    int    `json:"trial_days"`
+}
+
+// createPlan adds a plan to the partner's catalog with the token and returns its ID
+func (api *testAPI) createPlan(t *testing.T, token string, partnerID int, body string) int {
+	t.Helper()
+	rec := api.do(t, "POST", fmt.Sprintf("/partners/%d/plans", partnerID), token, body)
+	api.expect(t, rec, http.StatusCreated)
+	var created struct {
+		ID int `json:"id"`
+	}
+	api.decode(t, rec, &created)
+	return created.ID
+}
+
+func (api *testAPI) getPlan(t *testing.T, token, path string) testPlan {
+	t.Helper()
+	rec := api.do(t, "GET", path, token, "")
+	api.expect(t, rec, http.StatusOK)
+	var plan testPlan
+	api.decode(t, rec, &plan)
+	return plan
+}
+
+const goldPlan = `{"name":"gold","price":"15.00","currency":"KES","billing_cycle":"monthly","trial_days":7}`
+
+func TestPlanCRUD(t *testing.T) {
+	api := newTestAPI(t)
+	id := api.createPlan(t, api.partnerA, 1, goldPlan)
+	path := fmt.Sprintf("/partners/1/plans/%d", id)
+
+	got := api.getPlan(t, api.partnerA, path)
+	want := testPlan{ID: id, PartnerID: 1, Name: "gold", Price: "15.00", Currency: "KES", BillingCycle: "monthly", TrialDays: 7}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// names are unique within a catalog, and plans are validated
+	api.expect(t, api.do(t, "POST", "/partners/1/plans", api.partnerA, goldPlan), http.StatusConflict)
+	api.expect(t, api.do(t, "POST", "/partners/1/plans", api.partnerA, `{"name":"silver","price":"-1.00","currency":"KES","billing_cycle":"monthly"}`), http.StatusUnprocessableEntity)
+	api.expect(t, api.do(t, "POST", "/partners/1/plans", api.partnerA, `{"name":"silver","price":"1.00","currency":"KES","billing_cycle":"fortnightly"}`), http.StatusUnprocessableEntity)
+	api.createPlan(t, api.partnerA, 1, `{"name":"silver","price":"5.00","currency":"KES","billing_cycle":"weekly"}`)
+
+	rec := api.do(t, "PUT", path, api.partnerA, `{"name":"gold","price":"18.00","currency":"KES","billing_cycle":"monthly"}`)
+	api.expect(t, rec, http.StatusOK)
+	if got := api.getPlan(t, api.partnerA, path); got.Price != "18.00" || got.TrialDays != 0 {
+		t.Errorf("got %+v after PUT, want price 18.00 and no trial", got)
+	}
+	etag := rec.Header().Get("ETag")
+
+	rec = api.do(t, "PATCH", path, api.partnerA, `{"description":"the good one"}`, "Content-Type", "application/merge-patch+json", "If-Match", etag)
+	api.expect(t, rec, http.StatusOK)
+	if got := api.getPlan(t, api.partnerA, path); got.Description != "the good one" || got.Price != "18.00" {
+		t.Errorf("got %+v after PATCH, want only the description changed", got)
+	}
+	api.expect(t, api.do(t, "PATCH", path, api.partnerA, `{"name":"silver"}`, "Content-Type", "application/merge-patch+json"), http.StatusConflict)
+	api.expect(t, api.do(t, "PATCH", path, api.partnerA, `{"partner_id":2}`, "Content-Type", "application/merge-patch+json"), http.StatusUnprocessableEntity)
+
+	// the ETag from before the PATCH is stale
+	api.expect(t, api.do(t, "DELETE", path, api.partnerA, "", "If-Match", etag), http.StatusPreconditionFailed)
+	api.expect(t, api.do(t, "DELETE", path, api.partnerA, ""), http.StatusNoContent)
+	api.expect(t, api.do(t, "GET", path, api.partnerA, ""), http.StatusNotFound)
+	api.expect(t, api.do(t, "DELETE", path, api.partnerA, ""), http.StatusNotFound)
+
+	rec = api.do(t, "GET", "/partners/1/plans", api.partnerA, "")
+	api.expect(t, rec, http.StatusOK)
+	var list struct {
+		Data []testPlan `json:"data"`
+	}
+	api.decode(t, rec, &list)
+	if len(list.Data) != 1 || list.Data[0].Name != "silver" {
+		t.Errorf("listed %+v, want only silver left", list.Data)
+	}
+	// a deleted plan's name is free again
+	api.createPlan(t, api.partnerA, 1, goldPlan)
+}
+
+func TestPlanTenantScope(t *testing.T) {
+	api := newTestAPI(t)
+	own := api.createPlan(t, api.partnerA, 1, goldPlan)
+	other := api.createPlan(t, api.partnerB, 2, goldPlan)
+	update := `{"name":"gold","price":"1.00","currency":"KES","billing_cycle":"monthly"}`
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"own catalog", "GET", "/partners/1/plans", "", http.StatusOK},
+		{"own plan", "GET", fmt.Sprintf("/partners/1/plans/%d", own), "", http.StatusOK},
+		{"other's catalog", "GET", "/partners/2/plans", "", http.StatusNotFound},
+		{"other's plan", "GET", fmt.Sprintf("/partners/2/plans/%d", other), "", http.StatusNotFound},
+		{"other's plan through own catalog", "GET", fmt.Sprintf("/partners/1/plans/%d", other), "", http.StatusNotFound},
+		{"add to other's catalog", "POST", "/partners/2/plans", `{"name":"x","price":"1.00","currency":"KES","billing_cycle":"monthly"}`, http.StatusNotFound},
+		{"update other's plan", "PUT", fmt.Sprintf("/partners/2/plans/%d", other), update, http.StatusNotFound},
+		{"update other's plan through own catalog", "PUT", fmt.Sprintf("/partners/1/plans/%d", other), update, http.StatusNotFound},
+		{"patch other's plan", "PATCH", fmt.Sprintf("/partners/2/plans/%d", other), `{"price":"1.00"}`, http.StatusNotFound},
+		{"delete other's plan", "DELETE", fmt.Sprintf("/partners/2/plans/%d", other), "", http.StatusNotFound},
+		{"delete other's plan through own catalog", "DELETE", fmt.Sprintf("/partners/1/plans/%d", other), "", http.StatusNotFound},
+		{"subscribe under other's plan", "POST", "/subscriptions", fmt.Sprintf(`{"plan_id":%d,"customer_msisdn":"+254700000001",
+			"subscription_date":"2026-01-01T00:00:00Z","status":"active","start_date":"2026-01-01T00:00:00Z","end_date":"2027-01-01T00:00:00Z"}`, other),
+			http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := api.do(t, tt.method, tt.path, api.partnerA, tt.body, "Content-Type", "application/merge-patch+json")
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+
+	// partner b's plan came through untouched, and an admin sees every catalog
+	if got := api.getPlan(t, api.admin, fmt.Sprintf("/partners/2/plans/%d", other)); got.Price != "15.00" {
+		t.Errorf("got %+v, want partner b's plan unchanged", got)
+	}
+	api.expect(t, api.do(t, "GET", "/partners/1000/plans", api.admin, ""), http.StatusNotFound)
+}
+
+func TestSignupCopiesThePlan(t *testing.T) {
+	api := newTestAPI(t)
+	plan := api.createPlan(t, api.partnerA, 1, goldPlan)
+	signup := func(extra string) string {
+		return fmt.Sprintf(`{"plan_id":%d,"customer_msisdn":"+254700000001","subscription_date":"2026-01-01T00:00:00Z",
+			"status":"active","start_date":"2026-01-01T00:00:00Z","end_date":"2027-01-01T00:00:00Z"%s}`, plan, extra)
+	}
+
+	// what the request sends has to agree with the plan
+	api.expect(t, api.do(t, "POST", "/subscriptions", api.partnerA, signup(`,"billing_amount":"10.00","currency":"KES"`)), http.StatusUnprocessableEntity)
+	api.expect(t, api.do(t, "POST", "/subscriptions", api.partnerA, signup(`,"billing_cycle":"weekly"`)), http.StatusUnprocessableEntity)
+
+	// an amount is read in the currency the body names, so one repeating the plan's price names both
+	rec := api.do(t, "POST", "/subscriptions", api.partnerA, signup(`,"billing_amount":"15.00","currency":"KES"`))
+	api.expect(t, rec, http.StatusCreated)
+	var created struct {
+		ID int `json:"id"`
+	}
+	api.decode(t, rec, &created)
+	path := fmt.Sprintf("/subscriptions/%d", created.ID)
+
+	type subscription struct {
+		PlanID        *int       `json:"plan_id"`
+		BillingAmount string     `json:"billing_amount"`
+		Currency      string     `json:"currency"`
+		BillingCycle  string     `json:"billing_cycle"`
+		TrialEndsAt   *time.Time `json:"trial_ends_at"`
+	}
+	check := func(when string) {
+		t.Helper()
+		rec := api.do(t, "GET", path, api.partnerA, "")
+		api.expect(t, rec, http.StatusOK)
+		var sub subscription
+		api.decode(t, rec, &sub)
+		trialEnd := time.Date(2026, 1, 8, 0, 0, 0, 0, time.UTC)
+		if sub.PlanID == nil || *sub.PlanID != plan || sub.BillingAmount != "15.00" || sub.Currency != "KES" ||
+			sub.BillingCycle != "monthly" || sub.TrialEndsAt == nil || !sub.TrialEndsAt.Equal(trialEnd) {
+			t.Errorf("%s: got %+v, want the plan as it was at signup", when, sub)
+		}
+	}
+	check("at signup")
+
+	planPath := fmt.Sprintf("/partners/1/plans/%d", plan)
+	api.expect(t, api.do(t, "PUT", planPath, api.partnerA, `{"name":"gold","price":"20.00","currency":"USD","billing_cycle":"weekly","trial_days":30}`), http.StatusOK)
+	check("after the plan changed")
+	api.expect(t, api.do(t, "DELETE", planPath, api.partnerA, ""), http.StatusNoContent)
+	check("after the plan was deleted")
+
+	// a deleted plan sells nothing more
+	api.expect(t, api.do(t, "POST", "/subscriptions", api.partnerA, signup("")), http.StatusUnprocessableEntity)
+}
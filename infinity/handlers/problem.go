@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"infinity/models"
+	"infinity/store"
+	"log"
+	"net/http"
+)
+
+// Machine-readable error codes carried in the "code" member of every problem.
+// Clients branch on these, so they never change once published
+const (
+	CodeBadRequest           = "bad_request"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeConflict             = "conflict"
+	CodeValidationFailed     = "validation_failed"
+	CodeInvalidReference     = "invalid_reference"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeIdempotencyKeyInUse  = "idempotency_key_in_use"
+	CodeInvalidTransition    = "invalid_transition"
+	CodeInternal             = "internal_error"
+)
+
+// problemContentType is the media type of RFC 7807 error bodies
+const problemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object. It doubles as an error so
+// helpers deep in a handler can return one and have it rendered unchanged
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Code     string `json:"code"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Errors lists the rejected fields of a validation_failed problem
+	Errors []models.FieldError `json:"errors,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	return p.Detail
+}
+
+// newProblem builds a problem; the title is the standard text of the status
+func newProblem(status int, code, detail string) *Problem {
+	return &Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Code: code, Detail: detail}
+}
+
+// writeProblem renders a problem for the request
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	renderProblem(w, r, newProblem(status, code, detail))
+}
+
+func renderProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Del("Content-Length")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// writeError renders err as a problem. Store errors map onto their status codes;
+// anything unrecognised is logged and reported as a bare internal error so
+// driver messages never reach the client
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var p *Problem
+	var invalid *models.ValidationError
+	var transition *models.TransitionError
+	switch {
+	case errors.As(err, &p):
+		renderProblem(w, r, p)
+	case errors.As(err, &transition):
+		writeProblem(w, r, http.StatusConflict, CodeInvalidTransition, transition.Message)
+	case errors.As(err, &invalid):
+		p := newProblem(http.StatusUnprocessableEntity, CodeValidationFailed, "the request body failed validation")
+		p.Errors = invalid.Fields
+		renderProblem(w, r, p)
+	case errors.Is(err, store.ErrNotFound), errors.Is(err, sql.ErrNoRows):
+		writeProblem(w, r, http.StatusNotFound, CodeNotFound, "resource not found")
+	case errors.Is(err, store.ErrConflict):
+		writeProblem(w, r, http.StatusConflict, CodeConflict, "the request conflicts with an existing resource")
+	case errors.Is(err, store.ErrVersionMismatch):
+		writeProblem(w, r, http.StatusPreconditionFailed, CodePreconditionFailed, "the resource has changed since the version named in If-Match")
+	case errors.Is(err, store.ErrInvalidReference):
+		writeProblem(w, r, http.StatusUnprocessableEntity, CodeInvalidReference, "a referenced resource does not exist")
+	default:
+		log.Printf("%s %s: %v", r.Method, r.URL.Path, err)
+		writeProblem(w, r, http.StatusInternalServerError, CodeInternal, "an internal error occurred")
+	}
+}
+
+// badRequest reports an unreadable parameter or body; a parser that already
+// knows better returns a *Problem or *models.ValidationError, which writeError renders
+func badRequest(w http.ResponseWriter, r *http.Request, err error) {
+	var p *Problem
+	var invalid *models.ValidationError
+	if errors.As(err, &p) || errors.As(err, &invalid) {
+		writeError(w, r, err)
+		return
+	}
+	writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, err.Error())
+}
+
+// notFound reports a missing resource, or one the caller may not see
+func notFound(w http.ResponseWriter, r *http.Request, what string) {
+	writeProblem(w, r, http.StatusNotFound, CodeNotFound, what+" not found")
+}
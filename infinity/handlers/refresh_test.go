@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"infinity/store"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// eachRevocationList runs fn with the in-memory revocation list active and, when
+// TEST_REDIS_URL is set, with the Redis one
+func eachRevocationList(t *testing.T, fn func(t *testing.T)) {
+	use := func(t *testing.T, l store.RevocationList) {
+		previous := revocations
+		SetRevocationList(l)
+		t.Cleanup(func() { SetRevocationList(previous) })
+		fn(t)
+	}
+	t.Run("memory", func(t *testing.T) {
+		use(t, store.NewMemoryRevocationList())
+	})
+	t.Run("redis", func(t *testing.T) {
+		url := os.Getenv("TEST_REDIS_URL")
+		if url == "" {
+			t.Skip("TEST_REDIS_URL is not set")
+		}
+		l, err := store.NewRedisRevocationList(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		use(t, l)
+	})
+}
+
+// login exchanges the admin API key and returns the access and refresh tokens
+func (api *testAPI) login(t *testing.T) (string, string) {
+	t.Helper()
+	rec := api.do(t, "GET", "/jwt", "", "", "Access", "test-admin-key")
+	api.expect(t, rec, http.StatusOK)
+	return rec.Body.String(), rec.Header().Get("Refresh-Token")
+}
+
+// refresh rotates the refresh token, failing the test unless the response has the status
+func (api *testAPI) refresh(t *testing.T, refresh string, status int) (string, string) {
+	t.Helper()
+	rec := api.do(t, "GET", "/jwt", "", "", "Refresh", refresh)
+	api.expect(t, rec, status)
+	return rec.Body.String(), rec.Header().Get("Refresh-Token")
+}
+
+func TestRefreshRotation(t *testing.T) {
+	eachRevocationList(t, func(t *testing.T) {
+		api := newTestAPI(t)
+		access, first := api.login(t)
+		if first == "" {
+			t.Fatal("login issued no refresh token")
+		}
+
+		// the refresh token only gets new tokens, never an API call
+		api.expect(t, api.do(t, "GET", "/api", first, ""), http.StatusUnauthorized)
+		api.expect(t, api.do(t, "GET", "/api", access, ""), http.StatusOK)
+
+		rotatedAccess, second := api.refresh(t, first, http.StatusOK)
+		if second == "" || second == first || rotatedAccess == access {
+			t.Fatal("the refresh didn't issue a new pair")
+		}
+		api.expect(t, api.do(t, "GET", "/api", rotatedAccess, ""), http.StatusOK)
+
+		// the rotated token keeps the family and the login time, so the chain can't outlive the login
+		before, err := parseToken(first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, err := parseToken(second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after["family"] != before["family"] || after["auth_time"] != before["auth_time"] || after["jti"] == before["jti"] {
+			t.Errorf("got claims %v after rotating %v", after, before)
+		}
+		if after["scope"] != before["scope"] || after["sub"] != "admin" {
+			t.Errorf("got scope %v for %v, want the login's %v", after["scope"], after["sub"], before["scope"])
+		}
+
+		// a second login is a family of its own
+		_, other := api.login(t)
+		if claims, _ := parseToken(other); claims["family"] == before["family"] {
+			t.Error("two logins share a family")
+		}
+	})
+}
+
+func TestRefreshReuseRevokesTheFamily(t *testing.T) {
+	eachRevocationList(t, func(t *testing.T) {
+		api := newTestAPI(t)
+		_, first := api.login(t)
+		_, second := api.refresh(t, first, http.StatusOK)
+		_, unrelated := api.login(t)
+
+		// presenting the spent token means it leaked, so the token rotated from it dies too
+		api.refresh(t, first, http.StatusUnauthorized)
+		api.refresh(t, second, http.StatusUnauthorized)
+
+		// other logins are untouched
+		api.refresh(t, unrelated, http.StatusOK)
+	})
+}
+
+func TestRefreshRefusesInvalidTokens(t *testing.T) {
+	eachRevocationList(t, func(t *testing.T) {
+		api := newTestAPI(t)
+		access, _ := api.login(t)
+		for name, token := range map[string]string{
+			"access token": access,
+			"garbage":      "not-a-token",
+		} {
+			rec := api.do(t, "GET", "/jwt", "", "", "Refresh", token)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("%s: got status %d, want 401", name, rec.Code)
+			}
+		}
+	})
+}
+
+func TestLogout(t *testing.T) {
+	eachRevocationList(t, func(t *testing.T) {
+		api := newTestAPI(t)
+
+		t.Run("access token only", func(t *testing.T) {
+			access, refresh := api.login(t)
+			api.expect(t, api.do(t, "POST", "/logout", access, ""), http.StatusOK)
+			api.expect(t, api.do(t, "GET", "/api", access, ""), http.StatusUnauthorized)
+			// without its refresh token the login can still be rotated
+			api.refresh(t, refresh, http.StatusOK)
+		})
+
+		t.Run("with the refresh token", func(t *testing.T) {
+			access, first := api.login(t)
+			rotatedAccess, second := api.refresh(t, first, http.StatusOK)
+			api.expect(t, api.do(t, "POST", "/logout", rotatedAccess, "", "Refresh", second), http.StatusOK)
+			api.expect(t, api.do(t, "GET", "/api", rotatedAccess, ""), http.StatusUnauthorized)
+			api.refresh(t, second, http.StatusUnauthorized)
+			// access tokens from earlier in the chain run out on their own
+			api.expect(t, api.do(t, "GET", "/api", access, ""), http.StatusOK)
+		})
+
+		t.Run("someone else's refresh token", func(t *testing.T) {
+			_, refresh := api.login(t)
+			api.expect(t, api.do(t, "POST", "/logout", api.partnerA, "", "Refresh", refresh), http.StatusBadRequest)
+			api.refresh(t, refresh, http.StatusOK)
+		})
+
+		t.Run("twice", func(t *testing.T) {
+			access, _ := api.login(t)
+			api.expect(t, api.do(t, "POST", "/logout", access, ""), http.StatusOK)
+			api.expect(t, api.do(t, "POST", "/logout", access, ""), http.StatusUnauthorized)
+		})
+	})
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import "strings"
+
+// Scopes a token can carry; each route declares the ones it needs
+const (
+	ScopePartnersRead       = "partners:read"
+	ScopePartnersWrite      = "partners:write"
+	ScopePlansRead          = "plans:read"
+	ScopePlansWrite         = "plans:write"
+	ScopeSubscriptionsRead  = "subscriptions:read"
+	ScopeSubscriptionsWrite = "subscriptions:write"
+	ScopeTransactionsRead   = "transactions:read"
+	ScopeTransactionsWrite  = "transactions:write"
+	ScopeAdmin              = "admin"
+)
+
+// PartnerScopes are granted to tokens issued for a partner's API key
+var PartnerScopes = []string{
+	ScopePartnersRead,
+	ScopePlansRead,
+	ScopePlansWrite,
+	ScopeSubscriptionsRead,
+	ScopeSubscriptionsWrite,
+	ScopeTransactionsRead,
+	ScopeTransactionsWrite,
+}
+
+// AdminScopes are granted to tokens issued for the ADMIN_API_KEY
+var AdminScopes = []string{
+	ScopeAdmin,
+	ScopePartnersRead,
+	ScopePartnersWrite,
+	ScopePlansRead,
+	ScopePlansWrite,
+	ScopeSubscriptionsRead,
+	ScopeSubscriptionsWrite,
+	ScopeTransactionsRead,
+	ScopeTransactionsWrite,
+}
+
+// tokenScopes reads the "scope" claim, which is a space separated string
+// as in OAuth 2.0, though a JSON array of strings is accepted as well
+func tokenScopes(claim interface{}) map[string]bool {
+	scopes := make(map[string]bool)
+	switch v := claim.(type) {
+	case string:
+		for _, s := range strings.Fields(v) {
+			scopes[s] = true
+		}
+	case []interface{}:
+		for _, s := range v {
+			if str, ok := s.(string); ok {
+				scopes[str] = true
+			}
+		}
+	}
+	return scopes
+}
+
+// missingScope returns the first required scope the token lacks, or ""
+func missingScope(claim interface{}, required []string) string {
+	granted := tokenScopes(claim)
+	for _, s := range required {
+		if !granted[s] {
+			return s
+		}
+	}
+	return ""
+}
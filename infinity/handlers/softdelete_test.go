@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPartnerSoftDelete(t *testing.T) {
+	api := newTestAPI(t)
+	sub := api.createSubscription(t, api.partnerA)
+	earlier := api.createSubscription(t, api.partnerA)
+	api.expect(t, api.do(t, "DELETE", fmt.Sprintf("/subscriptions/%d", earlier), api.partnerA, ""), http.StatusNoContent)
+	partner := "/partners/1"
+	subscription := fmt.Sprintf("/subscriptions/%d", sub)
+
+	// a running subscription holds the partner back unless the delete cascades
+	rec := api.do(t, "DELETE", partner, api.admin, "")
+	api.expect(t, rec, http.StatusConflict)
+	if !strings.Contains(rec.Body.String(), `"code":"`+CodeConflict+`"`) {
+		t.Errorf("got %s, want a %s problem", rec.Body.String(), CodeConflict)
+	}
+	api.expect(t, api.do(t, "GET", partner, api.admin, ""), http.StatusOK)
+	api.expect(t, api.do(t, "DELETE", partner+"?cascade=maybe", api.admin, ""), http.StatusBadRequest)
+
+	api.expect(t, api.do(t, "DELETE", partner+"?cascade=true", api.admin, ""), http.StatusNoContent)
+	api.expect(t, api.do(t, "GET", partner, api.admin, ""), http.StatusNotFound)
+	api.expect(t, api.do(t, "GET", subscription, api.admin, ""), http.StatusNotFound)
+	api.expect(t, api.do(t, "DELETE", partner+"?cascade=true", api.admin, ""), http.StatusNotFound)
+
+	// a subscription comes back only with its partner
+	rec = api.do(t, "POST", subscription+"/restore", api.admin, "")
+	api.expect(t, rec, http.StatusConflict)
+
+	// only an admin restores
+	api.expect(t, api.do(t, "POST", partner+"/restore", api.partnerB, ""), http.StatusForbidden)
+	rec = api.do(t, "POST", partner+"/restore", api.admin, "")
+	api.expect(t, rec, http.StatusOK)
+	if strings.Contains(rec.Body.String(), "deleted_at") || rec.Header().Get("ETag") == "" {
+		t.Errorf("got %s with ETag %q, want the live partner", rec.Body.String(), rec.Header().Get("ETag"))
+	}
+	api.expect(t, api.do(t, "GET", partner, api.admin, ""), http.StatusOK)
+	// restoring undoes the cascade, not the earlier delete
+	api.expect(t, api.do(t, "GET", subscription, api.admin, ""), http.StatusOK)
+	api.expect(t, api.do(t, "GET", fmt.Sprintf("/subscriptions/%d", earlier), api.admin, ""), http.StatusNotFound)
+	api.expect(t, api.do(t, "POST", "/partners/1000/restore", api.admin, ""), http.StatusNotFound)
+}
+
+func TestSubscriptionSoftDelete(t *testing.T) {
+	api := newTestAPI(t)
+	sub := fmt.Sprintf("/subscriptions/%d", api.createSubscription(t, api.partnerA))
+
+	api.expect(t, api.do(t, "DELETE", sub, api.partnerA, ""), http.StatusNoContent)
+	api.expect(t, api.do(t, "GET", sub, api.partnerA, ""), http.StatusNotFound)
+	api.expect(t, api.do(t, "PATCH", sub, api.partnerA, `{"auto_renew":true}`, "Content-Type", "application/merge-patch+json"), http.StatusNotFound)
+	api.expect(t, api.do(t, "DELETE", sub, api.partnerA, ""), http.StatusNotFound)
+	if ids := api.listIDs(t, "/subscriptions?sort=id", "10", api.partnerA); len(ids) != 0 {
+		t.Errorf("listed %v, want the deleted subscription left out", ids)
+	}
+
+	api.expect(t, api.do(t, "POST", sub+"/restore", api.partnerA, ""), http.StatusForbidden)
+	api.expect(t, api.do(t, "POST", sub+"/restore", api.admin, ""), http.StatusOK)
+	api.expect(t, api.do(t, "GET", sub, api.partnerA, ""), http.StatusOK)
+}
@@ -1,11 +1,10 @@
 package handlers
 
 import (
-	"context"
 	"encoding/json"
-	"fmt"
-	"infinity/database"
+	"errors"
 	"infinity/models"
+	"infinity/store"
 	"net/http"
 	"time"
 
@@ -13,230 +12,584 @@ import (
 )
 
 // view all subscriptions
-func getAllSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
-	// Set response header
-	w.Header().Set("Content-Type", "application/json")
+func getAllSubscriptionsHandler(subscriptions store.SubscriptionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
 
-	// Create a new database connection
-	db, err := database.Connectdb()
+		filter, err := parseSubscriptionFilter(r)
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		listSubscriptions(w, r, subscriptions, filter)
+	}
+}
+
+// view a partner's subscriptions
+func getPartnerSubscriptions(stores store.Stores) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
+
+		// Parse partner ID from request URL
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		// Other partners are reported as missing to a partner token
+		if !canAccessPartner(r, id) {
+			notFound(w, r, "partner")
+			return
+		}
+
+		filter, err := parseSubscriptionFilter(r)
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		// A deleted partner's subscriptions are only listed along with deleted rows
+		getPartner := stores.Partners.Get
+		if filter.IncludeDeleted {
+			getPartner = stores.Partners.GetWithDeleted
+		}
+		if _, err := getPartner(r.Context(), id); errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "partner")
+			return
+		} else if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if filter.PartnerID != 0 && filter.PartnerID != id {
+			writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "partner_id does not match the path")
+			return
+		}
+		filter.PartnerID = id
+
+		listSubscriptions(w, r, stores.Subscriptions, filter)
+	}
+}
+
+// listSubscriptions writes one page of the subscriptions matching filter that the caller may see
+func listSubscriptions(w http.ResponseWriter, r *http.Request, subscriptions store.SubscriptionStore, filter store.SubscriptionFilter) {
+	page, err := parsePage(r, store.SubscriptionSortFields...)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error connecting to database: %v", err), http.StatusInternalServerError)
+		badRequest(w, r, err)
 		return
 	}
-	defer db.Close()
 
-	// Query the subscriptions table
-	rows, err := db.Query("SELECT id, customer_msisdn, subscription_date, status, billing_amount, billing_cycle, start_date, end_date, created_at, updated_at FROM subscriptions")
+	// A partner token only sees its own subscriptions
+	if own, confined := callerPartner(r); confined {
+		if filter.PartnerID != 0 && filter.PartnerID != own {
+			writeProblem(w, r, http.StatusForbidden, CodeForbidden, "partner_id does not match the caller")
+			return
+		}
+		filter.PartnerID = own
+	}
+	list, next, err := subscriptions.List(r.Context(), filter, page)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error querying database: %v", err), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	defer rows.Close()
 
-	// Build an array of Subscription objects
-	var subscriptions []models.Subscriptions
-	for rows.Next() {
-		var subscription models.Subscriptions
-		err := rows.Scan(&subscription.ID, &subscription.CustomerMSISDN, &subscription.SubscriptionDate, &subscription.Status, &subscription.BillingAmount, &subscription.BillingCycle, &subscription.StartDate, &subscription.EndDate, &subscription.CreatedAt, &subscription.UpdatedAt)
+	// Encode the page of Subscription objects in JSON format and write it to the response
+	writePage(w, r, list, page, next)
+}
+
+// view a subscription
+func getSubscription(subscriptions store.SubscriptionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
+
+		// Parse subscription ID from request URL
+		id, err := pathID(r, "id")
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error scanning row: %v", err), http.StatusInternalServerError)
+			badRequest(w, r, err)
 			return
 		}
-		subscriptions = append(subscriptions, subscription)
-	} 
 
-	// Encode the array of Partner objects in JSON format and write it to the response
-	if err := json.NewEncoder(w).Encode(subscriptions); err != nil {
-		http.Error(w, fmt.Sprintf("Error encoding JSON: %v", err), http.StatusInternalServerError)
-		return
+		// Soft-deleted subscriptions are missing unless an admin asks for them
+		include, err := includeDeleted(r)
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+		get := subscriptions.Get
+		if include {
+			get = subscriptions.GetWithDeleted
+		}
+
+		subscription, err := loadSubscription(r, get, id)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "subscription")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		if notModified(w, r, subscription.Version) {
+			return
+		}
+		setETag(w, subscription.Version)
+
+		// Encode the Subscription object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(subscription); err != nil {
+			writeError(w, r, err)
+		}
 	}
 }
 
-// view a subscription 
+// create a subscription
+func createSubscription(stores store.Stores) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the request body into a Subscriptions struct
+		var subscription models.Subscriptions
+		if err := decodeBody(r, &subscription); err != nil {
+			badRequest(w, r, err)
+			return
+		}
 
-func getSubscription(w http.ResponseWriter, r *http.Request) {
-	 // Set response header
-	w.Header().Set("Content-Type", "application/json")
+		// A partner token creates subscriptions for itself and nobody else
+		if own, confined := callerPartner(r); confined {
+			if subscription.PartnerID == 0 {
+				subscription.PartnerID = own
+			}
+			if subscription.PartnerID != own {
+				writeProblem(w, r, http.StatusForbidden, CodeForbidden, "partner_id does not match the caller")
+				return
+			}
+		}
 
-	// Parse partner ID from request URL
-	vars := mux.Vars(r)
-	id := vars["id"]
+		// The billing schedule, trial and dunning state are the server's to set; only a plan grants a trial
+		subscription.TrialEndsAt, subscription.NextBillingDate = nil, time.Time{}
+		subscription.DunningState, subscription.FailedAttempts, subscription.NextAttemptAt, subscription.DunningPeriod = "", 0, nil, nil
+
+		// A subscription sold under a plan of its partner takes a snapshot of the plan's price
+		if subscription.PlanID != nil {
+			plan, err := stores.Plans.Get(r.Context(), *subscription.PlanID)
+			if errors.Is(err, store.ErrNotFound) || (err == nil && plan.PartnerID != subscription.PartnerID) {
+				writeError(w, r, store.ErrInvalidReference)
+				return
+			}
+			if err != nil {
+				writeError(w, r, err)
+				return
+			}
+			if err := models.ApplyPlan(&subscription, plan); err != nil {
+				writeError(w, r, err)
+				return
+			}
+		}
 
-	// Get database connection from the pool
-	db, err := database.Connectdb()
+		// New subscriptions start out pending unless the caller activates them right away
+		if subscription.Status == "" {
+			subscription.Status = models.SubscriptionPending
+		}
 
-	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+		// Validate the subscription data
+		if err := models.Validate(&subscription); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !statusIn(subscription.Status, models.InitialSubscriptionStatuses) {
+			writeError(w, r, statusError("must be pending or active on a new subscription"))
+			return
+		}
 
-	// Prepare SQL statement
-	stmt, err := db.PrepareContext(ctx, "SELECT id, customer_msisdn, subscription_date, status, billing_amount, billing_cycle, start_date, end_date, created_at, updated_at FROM subscriptions WHERE id=$1")
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error preparing statement: %v", err), http.StatusInternalServerError)
-		return
+		// Insert the new subscription
+		if err := stores.Subscriptions.Create(r.Context(), &subscription); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Set the response status code to 201 Created and include the new subscription's ID in the response body
+		w.Header().Set("Content-Type", "application/json")
+		setETag(w, subscription.Version)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int{"id": subscription.ID})
 	}
-	defer stmt.Close()
+}
 
-	// Execute SQL query with the prepared statement
-	row := stmt.QueryRowContext(ctx, id)
+// update subscription
+func updateSubscription(subscriptions store.SubscriptionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
 
-	// Scan the row into a Subscription object
-	var subscription models.Subscriptions
-	err = row.Scan(&subscription.ID, &subscription.CustomerMSISDN, &subscription.SubscriptionDate, &subscription.Status, &subscription.BillingAmount, &subscription.BillingCycle, &subscription.StartDate, &subscription.EndDate, &subscription.CreatedAt, &subscription.UpdatedAt)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error scanning row: %v", err), http.StatusInternalServerError)
-		return
-	}
+		// Get the subscription ID from the request URL
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		// Parse the request body into a Subscriptions object
+		var subscription models.Subscriptions
+		if err := decodeBody(r, &subscription); err != nil {
+			badRequest(w, r, err)
+			return
+		}
+		defer r.Body.Close()
+
+		// The subscription must belong to the caller, and stay with it
+		current, err := loadSubscription(r, subscriptions.Get, id)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "subscription")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !canAccessPartner(r, subscription.PartnerID) {
+			writeProblem(w, r, http.StatusForbidden, CodeForbidden, "partner_id does not match the caller")
+			return
+		}
+
+		version, err := matchVersion(r, current.Version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Validate the new subscription data; the status only moves through the lifecycle actions
+		if err := models.Validate(&subscription); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if subscription.Status != current.Status {
+			writeError(w, r, statusError(statusChangeMessage))
+			return
+		}
+
+		// Update the subscription; the store refreshes it with the stored row
+		subscription.ID, subscription.Version = id, version
+		err = subscriptions.Update(r.Context(), &subscription)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "subscription")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	// Encode the Partner object in JSON format and write it to the response
-	if err := json.NewEncoder(w).Encode(subscription); err != nil {
-		http.Error(w, fmt.Sprintf("Error encoding JSON: %v", err), http.StatusInternalServerError)
+		setETag(w, subscription.Version)
+		// Encode the Subscription object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(subscription); err != nil {
+			writeError(w, r, err)
+		}
 	}
 }
 
-//create a subscription
-func createSubscription(w http.ResponseWriter, r *http.Request) {
-	// Parse the request body into a Partner struct
-	var subscription models.Subscriptions
-	err := json.NewDecoder(r.Body).Decode(&subscription)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+// patch subscription
+func patchSubscription(subscriptions store.SubscriptionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
+
+		// Get the subscription ID from the request URL
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		current, err := loadSubscription(r, subscriptions.Get, id)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "subscription")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		version, err := matchVersion(r, current.Version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Merge the patch into the stored subscription and validate the result
+		subscription, columns, err := decodePatch(r, current)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		columns = withAmount(columns, "billing_amount")
+		subscription.Version = version
+		if err := models.Validate(&subscription); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if subscription.Status != current.Status {
+			writeError(w, r, statusError(statusChangeMessage))
+			return
+		}
+		columns = withoutColumn(columns, "status")
+		if !canAccessPartner(r, subscription.PartnerID) {
+			writeProblem(w, r, http.StatusForbidden, CodeForbidden, "partner_id does not match the caller")
+			return
+		}
+
+		// Write only the patched columns; the store refreshes the subscription with the stored row
+		err = subscriptions.Patch(r.Context(), &subscription, columns)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "subscription")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		setETag(w, subscription.Version)
+		// Encode the Subscription object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(subscription); err != nil {
+			writeError(w, r, err)
+		}
 	}
+}
 
-	 // Validate the subscription data
-	 if subscription.PartnerID == 0 || subscription.CustomerMSISDN == "" || subscription.SubscriptionDate.IsZero() || subscription.StartDate.IsZero() || subscription.EndDate.IsZero() {
-        http.Error(w, "PartnerID, CustomerMSISDN, SubscriptionDate, StartDate, and EndDate are required fields", http.StatusBadRequest)
-        return
-    }
+// delete subscription
+func deleteSubscription(subscriptions store.SubscriptionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the ID of the subscription to delete from the URL parameters
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
 
-	// Create a new database connection
-	db, err := database.Connectdb()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		current, err := loadSubscription(r, subscriptions.Get, id)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "subscription")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		version, err := matchVersion(r, current.Version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Soft-delete the subscription
+		err = subscriptions.Delete(r.Context(), id, version)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "subscription")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// There is nothing left to show
+		w.WriteHeader(http.StatusNoContent)
 	}
-	defer db.Close()
-
-	// Insert the new subscription into the subscriptions table
-	sqlStatement := `INSERT INTO subscriptions (partner_id, customer_msisdn, subscription_date, status, billing_amount, billing_cycle, start_date, end_date, created_at, updated_at)
-					VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-					RETURNING id`
-	var id int
-	err = db.QueryRow(sqlStatement, subscription.PartnerID, subscription.CustomerMSISDN, subscription.SubscriptionDate, subscription.Status, subscription.BillingAmount, subscription.BillingCycle, subscription.StartDate, subscription.EndDate, time.Now(), time.Now()).Scan(&id)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+}
+
+// restore a soft-deleted subscription
+func restoreSubscription(subscriptions store.SubscriptionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
+
+		// Get the subscription ID from the request URL
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		// Restore the subscription; its partner has to be restored first
+		subscription, err := subscriptions.Restore(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "subscription")
+			return
+		}
+		if errors.Is(err, store.ErrInvalidReference) {
+			writeProblem(w, r, http.StatusConflict, CodeConflict, "the subscription's partner is deleted; restore the partner first")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		setETag(w, subscription.Version)
+		// Encode the Subscription object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(subscription); err != nil {
+			writeError(w, r, err)
+		}
 	}
+}
 
-	// Set the response status code to 201 Created and include the new partner's ID in the response body
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]int{"id": id})
+// statusChangeMessage rejects a PUT or PATCH that moves the status
+const statusChangeMessage = "can't be changed directly; use the activate, suspend, resume or cancel action"
+
+// statusError reports a status a write may not set
+func statusError(message string) error {
+	return &models.ValidationError{Fields: []models.FieldError{{Field: "status", Rule: "lifecycle", Message: message}}}
 }
 
-// update subscription
-func updateSubscription(w http.ResponseWriter, r *http.Request) {
-	// Set response header
-	w.Header().Set("Content-Type", "application/json")
-
-	// Get the partner ID from the request URL
-	id, ok := mux.Vars(r)["id"]
-	if !ok {
-		http.Error(w, "missing subscription ID", http.StatusBadRequest)
-		return
+// statusIn reports whether status is one of statuses
+func statusIn(status string, statuses []string) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
 	}
+	return false
+}
 
-	// Parse the request body into a Partner object
-	var subscription models.Subscriptions
-	if err := json.NewDecoder(r.Body).Decode(&subscription); err != nil {
-		http.Error(w, fmt.Sprintf("error decoding request body: %v", err), http.StatusBadRequest)
-		return
-	}
-	defer r.Body.Close()
+// statusChangeRequest is the body of a lifecycle action
+type statusChangeRequest struct {
+	Reason string `json:"reason" validate:"required"`
+}
 
-	db, err := database.Connectdb()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
-	
-	// Update the subscription in the database
-	res, err := db.ExecContext(r.Context(), `
-		UPDATE subscriptions 
-		SET partner_id=$1, customer_msisdn=$2, subscription_date=$3, status=$4, billing_amount=$5, billing_cycle=$6, start_date=$7, end_date=$8, updated_at=$9 
-		WHERE id=$10`,
-		subscription.PartnerID, subscription.CustomerMSISDN, subscription.SubscriptionDate, subscription.Status, subscription.BillingAmount, subscription.BillingCycle, subscription.StartDate, subscription.EndDate, time.Now(), id)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("error updating subscription: %v", err), http.StatusInternalServerError)
-		return
-	}
+// move a subscription through its lifecycle: action is activate, suspend, resume or cancel
+func transitionSubscription(subscriptions store.SubscriptionStore, action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
 
-	rowsAffected, err := res.RowsAffected()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("error getting rows affected: %v", err), http.StatusInternalServerError)
-		return
-	}
-	if rowsAffected == 0 {
-		http.Error(w, "partner not found", http.StatusNotFound)
-		return
-	}
+		// Get the subscription ID from the request URL
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
 
-	// Fetch the updated partner from the database
-	err = db.QueryRowContext(r.Context(), `
-		SELECT id, customer_msisdn, subscription_date, status, billing_amount, billing_cycle, start_date, end_date, created_at, updated_at
-		FROM subscriptions 
-		WHERE id=$1`, id).Scan(&subscription.ID, &subscription.CustomerMSISDN, &subscription.SubscriptionDate, &subscription.Status, &subscription.BillingAmount, &subscription.BillingCycle, &subscription.StartDate, &subscription.EndDate, &subscription.CreatedAt, &subscription.UpdatedAt)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("error fetching updated subscription: %v", err), http.StatusInternalServerError)
-		return
-	}
+		// Every transition records why it was made
+		var body statusChangeRequest
+		if err := decodeBody(r, &body); err != nil {
+			badRequest(w, r, err)
+			return
+		}
+		if err := models.Validate(&body); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		current, err := loadSubscription(r, subscriptions.Get, id)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "subscription")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		version, err := matchVersion(r, current.Version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Work out where the action leads from the current status
+		to, err := models.SubscriptionTransition(action, current.Status)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Move the subscription, provided nobody changed its status meanwhile
+		subscription, err := subscriptions.Transition(r.Context(), id, version, &models.SubscriptionStatusChange{
+			FromStatus: current.Status,
+			ToStatus:   to,
+			Reason:     body.Reason,
+			Actor:      callerSubject(r),
+		})
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "subscription")
+			return
+		}
+		if errors.Is(err, store.ErrConflict) {
+			writeProblem(w, r, http.StatusConflict, CodeInvalidTransition, "the subscription's status changed meanwhile; reload it and retry")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	// Encode the Partner object in JSON format and write it to the response
-	if err := json.NewEncoder(w).Encode(subscription); err != nil {
-		http.Error(w, fmt.Sprintf("error encoding response: %v", err), http.StatusInternalServerError)
+		setETag(w, subscription.Version)
+		// Encode the Subscription object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(subscription); err != nil {
+			writeError(w, r, err)
+		}
 	}
 }
 
-//delete subscription
-func deleteSubscription(w http.ResponseWriter, r *http.Request) {
-	// Set response header
-	w.Header().Set("Content-Type", "application/json")
+// view a subscription's status history
+func getSubscriptionHistory(subscriptions store.SubscriptionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
 
-	// Get the ID of the subscription to delete from the URL parameters
-	params := mux.Vars(r)
-	id := params["id"]
+		// Get the subscription ID from the request URL
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
 
-	// Create a new database connection
-	db, err := database.Connectdb()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error connecting to database: %v", err), http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
-	
-	// Delete the subscription from the database
-	_, err = db.Exec("DELETE FROM subscriptions WHERE id=$1", id)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting subscription: %v", err), http.StatusInternalServerError)
-		return
-	}
+		if _, err := loadSubscription(r, subscriptions.Get, id); errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "subscription")
+			return
+		} else if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	// Write a success message to the response
-	fmt.Fprintf(w, "Subscription %s deleted successfully", id)
+		history, err := subscriptions.History(r.Context(), id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// The history is short, so it comes in a single page, oldest change first
+		if err := json.NewEncoder(w).Encode(listResponse[models.SubscriptionStatusChange]{Data: history}); err != nil {
+			writeError(w, r, err)
+		}
+	}
 }
 
+func SubscriptionsRouter(stores store.Stores) *mux.Router {
+	router := mux.NewRouter()
+
+	// endpoints for subscriptions, each guarded by a token carrying the listed scope
+	router.Handle("/subscriptions", ValidateJWT(getAllSubscriptionsHandler(stores.Subscriptions), ScopeSubscriptionsRead)).Methods("GET")
+	router.Handle("/subscriptions/{id}", ValidateJWT(getSubscription(stores.Subscriptions), ScopeSubscriptionsRead)).Methods("GET")
+	router.Handle("/subscriptions", ValidateJWT(idempotent(stores.IdempotencyKeys, createSubscription(stores)), ScopeSubscriptionsWrite)).Methods("POST")
+	router.Handle("/subscriptions/{id}", ValidateJWT(updateSubscription(stores.Subscriptions), ScopeSubscriptionsWrite)).Methods("PUT")
+	router.Handle("/subscriptions/{id}", ValidateJWT(patchSubscription(stores.Subscriptions), ScopeSubscriptionsWrite)).Methods("PATCH")
+	router.Handle("/subscriptions/{id}", ValidateJWT(deleteSubscription(stores.Subscriptions), ScopeSubscriptionsWrite)).Methods("DELETE")
+	router.Handle("/subscriptions/{id}/restore", ValidateJWT(restoreSubscription(stores.Subscriptions), ScopeAdmin)).Methods("POST")
+
+	// lifecycle actions and the status history they leave behind
+	for _, action := range []string{"activate", "suspend", "resume", "cancel"} {
+		router.Handle("/subscriptions/{id}/"+action, ValidateJWT(transitionSubscription(stores.Subscriptions, action), ScopeSubscriptionsWrite)).Methods("POST")
+	}
+	router.Handle("/subscriptions/{id}/history", ValidateJWT(getSubscriptionHistory(stores.Subscriptions), ScopeSubscriptionsRead)).Methods("GET")
 
-func SubscriptionsRouter() * mux.Router {
-	router  := mux.NewRouter()
+	// transactions nested under their subscription
+	router.Handle("/subscriptions/{id}/transactions", ValidateJWT(getSubscriptionTransactions(stores), ScopeTransactionsRead)).Methods("GET")
+	router.Handle("/subscriptions/{id}/transactions", ValidateJWT(idempotent(stores.IdempotencyKeys, createTransaction(stores)), ScopeTransactionsWrite)).Methods("POST")
 
-	// endpoints for subscriptions
-	router.HandleFunc("/subscriptions", getAllSubscriptionsHandler).Methods("GET")
-	router.HandleFunc("/subscriptions/{id}", getSubscription).Methods("GET")
-	router.HandleFunc("/subscriptions", createSubscription).Methods("POST")
-	router.HandleFunc("/subscriptions/{id}", updateSubscription).Methods("PUT")
-	router.HandleFunc("/subscriptions/{id}", deleteSubscription).Methods("DELETE")
-	
 	return router
 }
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"infinity/models"
+	"infinity/store"
+	"net/http"
+)
+
+// callerPartner returns the partner the caller's token is confined to.
+// Admin tokens keep cross-partner access and get confined == false; a
+// non-admin token without a partner_id is confined to no partner at all
+func callerPartner(r *http.Request) (partnerID int, confined bool) {
+	claims := claimsFromRequest(r)
+	if tokenScopes(claims["scope"])[ScopeAdmin] {
+		return 0, false
+	}
+	// JSON numbers decode as float64
+	id, _ := claims["partner_id"].(float64)
+	return int(id), true
+}
+
+// callerSubject names the caller in audit records: "admin" or "partner:<id>"
+func callerSubject(r *http.Request) string {
+	sub, _ := claimsFromRequest(r)["sub"].(string)
+	return sub
+}
+
+// canAccessPartner reports whether the caller may see rows owned by partnerID
+func canAccessPartner(r *http.Request, partnerID int) bool {
+	own, confined := callerPartner(r)
+	return !confined || own == partnerID
+}
+
+// canAccessSubscription reports whether the caller may see the subscription
+// and its transactions; an unknown subscription is simply not accessible
+func canAccessSubscription(r *http.Request, subscriptions store.SubscriptionStore, subscriptionID int) (bool, error) {
+	if _, confined := callerPartner(r); !confined {
+		return true, nil
+	}
+	sub, err := subscriptions.Get(r.Context(), subscriptionID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return canAccessPartner(r, sub.PartnerID), nil
+}
+
+// loadSubscription finds subscription id through get. Other partners'
+// subscriptions are reported as missing to a partner token
+func loadSubscription(r *http.Request, get func(context.Context, int) (models.Subscriptions, error), id int) (models.Subscriptions, error) {
+	subscription, err := get(r.Context(), id)
+	if err == nil && !canAccessPartner(r, subscription.PartnerID) {
+		return models.Subscriptions{}, store.ErrNotFound
+	}
+	return subscription, err
+}
+
+// loadTransaction finds transaction id. Transactions of other partners'
+// subscriptions are reported as missing to a partner token
+func loadTransaction(r *http.Request, stores store.Stores, id int) (models.Transactions, error) {
+	transaction, err := stores.Transactions.Get(r.Context(), id)
+	if err != nil {
+		return models.Transactions{}, err
+	}
+	ok, err := canAccessSubscription(r, stores.Subscriptions, transaction.SubscriptionID)
+	if err != nil {
+		return models.Transactions{}, err
+	}
+	if !ok {
+		return models.Transactions{}, store.ErrNotFound
+	}
+	return transaction, nil
+}
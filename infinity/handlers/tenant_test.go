@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	api := newTestAPI(t)
+	own := api.createSubscription(t, api.partnerA)
+	other := api.createSubscription(t, api.partnerB)
+	rec := api.do(t, "POST", fmt.Sprintf("/subscriptions/%d/transactions", other), api.partnerB, `{"amount":"10.00","currency":"KES","status":"pending"}`)
+	api.expect(t, rec, http.StatusCreated)
+	var transaction struct {
+		ID int `json:"id"`
+	}
+	api.decode(t, rec, &transaction)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"own subscription", "GET", fmt.Sprintf("/subscriptions/%d", own), "", http.StatusOK},
+		{"other's subscription", "GET", fmt.Sprintf("/subscriptions/%d", other), "", http.StatusNotFound},
+		{"patch other's subscription", "PATCH", fmt.Sprintf("/subscriptions/%d", other), `{"auto_renew":true}`, http.StatusNotFound},
+		{"delete other's subscription", "DELETE", fmt.Sprintf("/subscriptions/%d", other), "", http.StatusNotFound},
+		{"other's transaction", "GET", fmt.Sprintf("/transactions/%d", transaction.ID), "", http.StatusNotFound},
+		{"charge other's subscription", "POST", fmt.Sprintf("/subscriptions/%d/transactions", other), `{"amount":"10.00","currency":"KES","status":"pending"}`, http.StatusNotFound},
+		{"other partner", "GET", "/partners/2", "", http.StatusNotFound},
+		{"other partner's subscriptions", "GET", "/partners/2/subscriptions", "", http.StatusNotFound},
+		{"subscribe for other partner", "POST", "/subscriptions", `{"partner_id":2}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := api.do(t, tt.method, tt.path, api.partnerA, tt.body, "Content-Type", "application/merge-patch+json")
+			if rec.Code != tt.want {
+				t.Errorf("got status %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestTenantListsOnlyOwnRows(t *testing.T) {
+	api := newTestAPI(t)
+	own := api.createSubscription(t, api.partnerA)
+	api.createSubscription(t, api.partnerB)
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"partner", api.partnerA, 1},
+		{"admin", api.admin, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := api.do(t, "GET", "/subscriptions", tt.token, "")
+			api.expect(t, rec, http.StatusOK)
+			var list struct {
+				Data []struct {
+					ID        int `json:"id"`
+					PartnerID int `json:"partner_id"`
+				} `json:"data"`
+			}
+			api.decode(t, rec, &list)
+			if len(list.Data) != tt.want {
+				t.Fatalf("got %d subscriptions, want %d", len(list.Data), tt.want)
+			}
+			if tt.token == api.partnerA && list.Data[0].ID != own {
+				t.Errorf("got subscription %d, want %d", list.Data[0].ID, own)
+			}
+		})
+	}
+}
@@ -2,239 +2,416 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
-	"infinity/database"
+	"errors"
 	"infinity/models"
+	"infinity/store"
 	"net/http"
-	"time"
-	"context"
+
 	"github.com/gorilla/mux"
 )
 
 // view all transactions
-func getAllTransactionsHandler(w http.ResponseWriter, r *http.Request) {
-	// Set response header
-	w.Header().Set("Content-Type", "application/json")
+func getAllTransactionsHandler(transactions store.TransactionStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
 
-	// Create a new database connection
-	db, err := database.Connectdb()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error connecting to database: %v", err), http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
+		filter, err := parseTransactionFilter(r)
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
 
-	// Query the transactions table
-	rows, err := db.Query("SELECT id, subscription_id, transaction_date, amount, status, created_at, updated_at FROM transactions")
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error querying database: %v", err), http.StatusInternalServerError)
-		return
+		listTransactions(w, r, transactions, filter)
 	}
-	defer rows.Close()
+}
 
-	// Build an array of Transactions objects
-	var transactions []models.Transactions
-	for rows.Next() {
-		var transaction models.Transactions
-		err := rows.Scan(&transaction.ID, &transaction.SubscriptionID, &transaction.TransactionDate, &transaction.Amount, &transaction.Status, &transaction.CreatedAt, &transaction.UpdatedAt)
+// view a subscription's transactions
+func getSubscriptionTransactions(stores store.Stores) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
+
+		// Parse subscription ID from request URL
+		id, err := pathID(r, "id")
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error scanning row: %v", err), http.StatusInternalServerError)
+			badRequest(w, r, err)
 			return
 		}
-		transactions = append(transactions, transaction)
-	}
 
-	// Encode the array of Partner objects in JSON format and write it to the response
-	if err := json.NewEncoder(w).Encode(transactions); err != nil {
-		http.Error(w, fmt.Sprintf("Error encoding JSON: %v", err), http.StatusInternalServerError)
-		return
+		// Other partners' subscriptions are reported as missing to a partner token
+		if ok, err := canAccessSubscription(r, stores.Subscriptions, id); err != nil {
+			writeError(w, r, err)
+			return
+		} else if !ok {
+			notFound(w, r, "subscription")
+			return
+		}
+
+		filter, err := parseTransactionFilter(r)
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+		if filter.SubscriptionID != 0 && filter.SubscriptionID != id {
+			writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "subscription_id does not match the path")
+			return
+		}
+		filter.SubscriptionID = id
+
+		listTransactions(w, r, stores.Transactions, filter)
 	}
 }
 
-func createTransaction(w http.ResponseWriter, r *http.Request) {
-	// Parse the request body into a Transactions struct
-	var transaction models.Transactions
-	err := json.NewDecoder(r.Body).Decode(&transaction)
+// listTransactions writes one page of the transactions matching filter that the caller may see
+func listTransactions(w http.ResponseWriter, r *http.Request, transactions store.TransactionStore, filter store.TransactionFilter) {
+	page, err := parsePage(r, store.TransactionSortFields...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	// Validate the transaction data
-	if transaction.SubscriptionID == 0 || transaction.Amount == 0 {
-		http.Error(w, "SubscriptionID and Amount are required fields", http.StatusBadRequest)
+		badRequest(w, r, err)
 		return
 	}
 
-	// Create a new database connection
-	db, err := database.Connectdb()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	// A partner token only sees transactions on its own subscriptions
+	if own, confined := callerPartner(r); confined {
+		filter.PartnerID = own
 	}
-	defer db.Close()
-
-	// Insert the new transaction into the transactions table
-	sqlStatement := `INSERT INTO transactions (subscription_id, transaction_date, amount, status, created_at, updated_at)
-					 VALUES ($1, $2, $3, $4, $5, $6)
-					 RETURNING id`
-	var id int
-	err = db.QueryRow(sqlStatement, transaction.SubscriptionID, transaction.TransactionDate, transaction.Amount, transaction.Status, time.Now(), time.Now()).Scan(&id)
+	list, next, err := transactions.List(r.Context(), filter, page)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	// Set the response status code to 201 Created and include the new transaction's ID in the response body
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]int{"id": id})
+	// Encode the page of Transactions objects in JSON format and write it to the response
+	writePage(w, r, list, page, next)
 }
 
+// create a transaction
+func createTransaction(stores store.Stores) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Parse the request body into a Transactions struct
+		var transaction models.Transactions
+		if err := decodeBody(r, &transaction); err != nil {
+			badRequest(w, r, err)
+			return
+		}
 
-//find a partner
-// Get a single transaction by ID
-func getTransaction(w http.ResponseWriter, r *http.Request) {
-	// Set response header
-	w.Header().Set("Content-Type", "application/json")
+		// On /subscriptions/{id}/transactions the subscription comes from the path
+		if _, nested := mux.Vars(r)["id"]; nested {
+			id, err := pathID(r, "id")
+			if err != nil {
+				badRequest(w, r, err)
+				return
+			}
+			if transaction.SubscriptionID != 0 && transaction.SubscriptionID != id {
+				writeProblem(w, r, http.StatusBadRequest, CodeBadRequest, "subscription_id does not match the path")
+				return
+			}
+			transaction.SubscriptionID = id
+		}
 
-	// Parse transaction ID from request URL
-	vars := mux.Vars(r)
-	id := vars["id"]
+		// New transactions start out pending unless the caller records a settled charge
+		if transaction.Status == "" {
+			transaction.Status = models.TransactionPending
+		}
 
-	// Get database connection from the pool
-	db, err := database.Connectdb()
+		// Validate the transaction data
+		if err := models.Validate(&transaction); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if !statusIn(transaction.Status, models.InitialTransactionStatuses) {
+			writeError(w, r, statusError("must be pending, succeeded or failed on a new transaction"))
+			return
+		}
 
-	// Create a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+		// A partner token may only charge its own subscriptions
+		if ok, err := canAccessSubscription(r, stores.Subscriptions, transaction.SubscriptionID); err != nil {
+			writeError(w, r, err)
+			return
+		} else if !ok {
+			notFound(w, r, "subscription")
+			return
+		}
 
-	// Prepare SQL statement
-	stmt, err := db.PrepareContext(ctx, "SELECT id, subscription_id, transaction_date, amount, status, created_at, updated_at FROM transactions WHERE id=$1")
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error preparing statement: %v", err), http.StatusInternalServerError)
-		return
+		// Insert the new transaction
+		if err := stores.Transactions.Create(r.Context(), &transaction); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Set the response status code to 201 Created and include the new transaction's ID in the response body
+		w.Header().Set("Content-Type", "application/json")
+		setETag(w, transaction.Version)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]int{"id": transaction.ID})
 	}
-	defer stmt.Close()
+}
 
-	// Execute SQL query with the prepared statement
-	row := stmt.QueryRowContext(ctx, id)
+// Get a single transaction by ID
+func getTransaction(stores store.Stores) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
 
-	// Scan the row into a Transactions object
-	var transaction models.Transactions
-	err = row.Scan(&transaction.ID, &transaction.SubscriptionID, &transaction.TransactionDate, &transaction.Amount, &transaction.Status, &transaction.CreatedAt, &transaction.UpdatedAt)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error scanning row: %v", err), http.StatusInternalServerError)
-		return
-	}
-	// Encode the Transactions object in JSON format and write it to the response
-	if err := json.NewEncoder(w).Encode(transaction); err != nil {
-		http.Error(w, fmt.Sprintf("Error encoding JSON: %v", err), http.StatusInternalServerError)
+		// Parse transaction ID from request URL
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		transaction, err := loadTransaction(r, stores, id)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "transaction")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		if notModified(w, r, transaction.Version) {
+			return
+		}
+		setETag(w, transaction.Version)
+
+		// Encode the Transactions object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(transaction); err != nil {
+			writeError(w, r, err)
+		}
 	}
 }
 
 // update transaction
-func updateTransaction(w http.ResponseWriter, r *http.Request) {
-	// Set response header
-	w.Header().Set("Content-Type", "application/json")
-
-	// Get the transaction ID from the request URL
-	id, ok := mux.Vars(r)["id"]
-	if !ok {
-		http.Error(w, "missing transaction ID", http.StatusBadRequest)
-		return
-	}
+func updateTransaction(stores store.Stores) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
 
-	// Parse the request body into a Transactions object
-	var transaction models.Transactions
-	if err := json.NewDecoder(r.Body).Decode(&transaction); err != nil {
-		http.Error(w, fmt.Sprintf("error decoding request body: %v", err), http.StatusBadRequest)
-		return
-	}
-	defer r.Body.Close()
+		// Get the transaction ID from the request URL
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
 
-	// Get a database connection from the pool
-	db, err := database.Connectdb()
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
-
-	// Update the transaction in the database
-	res, err := db.ExecContext(r.Context(), `
-		UPDATE transactions 
-		SET subscription_id=$1, transaction_date=$2, amount=$3, status=$4, updated_at=$5 
-		WHERE id=$6`,
-		transaction.SubscriptionID, transaction.TransactionDate, transaction.Amount, transaction.Status, time.Now(), id)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("error updating transaction: %v", err), http.StatusInternalServerError)
-		return
-	}
+		// Parse the request body into a Transactions object
+		var transaction models.Transactions
+		if err := decodeBody(r, &transaction); err != nil {
+			badRequest(w, r, err)
+			return
+		}
+		defer r.Body.Close()
 
-	rowsAffected, err := res.RowsAffected()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("error getting rows affected: %v", err), http.StatusInternalServerError)
-		return
-	}
-	if rowsAffected == 0 {
-		http.Error(w, "transaction not found", http.StatusNotFound)
-		return
-	}
+		// Both the stored transaction and its new subscription must belong to the caller
+		current, err := loadTransaction(r, stores, id)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "transaction")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if ok, err := canAccessSubscription(r, stores.Subscriptions, transaction.SubscriptionID); err != nil {
+			writeError(w, r, err)
+			return
+		} else if !ok {
+			notFound(w, r, "subscription")
+			return
+		}
 
-	// Fetch the updated transaction from the database
-	err = db.QueryRowContext(r.Context(), `
-		SELECT id, subscription_id, transaction_date, amount, status, created_at, updated_at 
-		FROM transactions 
-		WHERE id=$1`, id).Scan(&transaction.ID, &transaction.SubscriptionID, &transaction.TransactionDate, &transaction.Amount, &transaction.Status, &transaction.CreatedAt, &transaction.UpdatedAt)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("error fetching updated transaction: %v", err), http.StatusInternalServerError)
-		return
+		version, err := matchVersion(r, current.Version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Validate the new transaction data
+		if err := models.Validate(&transaction); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// Update the transaction; the store refreshes it with the stored row
+		transaction.ID, transaction.Version = id, version
+		err = stores.Transactions.Update(r.Context(), &transaction)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "transaction")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		setETag(w, transaction.Version)
+		// Encode the Transactions object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(transaction); err != nil {
+			writeError(w, r, err)
+		}
 	}
+}
+
+// patch transaction
+func patchTransaction(stores store.Stores) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
+
+		// Get the transaction ID from the request URL
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		current, err := loadTransaction(r, stores, id)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "transaction")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		version, err := matchVersion(r, current.Version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	// Encode the Transactions object in JSON format and write it to the response
-	if err := json.NewEncoder(w).Encode(transaction); err != nil {
-		http.Error(w, fmt.Sprintf("error encoding response: %v", err), http.StatusInternalServerError)
+		// Merge the patch into the stored transaction and validate the result
+		transaction, columns, err := decodePatch(r, current)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		columns = withAmount(columns, "amount")
+		transaction.Version = version
+		if err := models.Validate(&transaction); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if ok, err := canAccessSubscription(r, stores.Subscriptions, transaction.SubscriptionID); err != nil {
+			writeError(w, r, err)
+			return
+		} else if !ok {
+			notFound(w, r, "subscription")
+			return
+		}
+
+		// Write only the patched columns; the store refreshes the transaction with the stored row
+		err = stores.Transactions.Patch(r.Context(), &transaction, columns)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "transaction")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		setETag(w, transaction.Version)
+		// Encode the Transactions object in JSON format and write it to the response
+		if err := json.NewEncoder(w).Encode(transaction); err != nil {
+			writeError(w, r, err)
+		}
 	}
 }
 
-//delete subscription
 // Delete a transaction
-func deleteTransaction(w http.ResponseWriter, r *http.Request) {
-	// Set response header
-	w.Header().Set("Content-Type", "application/json")
+func deleteTransaction(stores store.Stores) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Get the ID of the transaction to delete from the URL parameters
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
 
-	// Get the ID of the transaction to delete from the URL parameters
-	params := mux.Vars(r)
-	id := params["id"]
+		current, err := loadTransaction(r, stores, id)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "transaction")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	// Create a new database connection
-	db, err := database.Connectdb()
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error connecting to database: %v", err), http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
+		version, err := matchVersion(r, current.Version)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	// Delete the transaction from the database
-	_, err = db.Exec("DELETE FROM transactions WHERE id=$1", id)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Error deleting transaction: %v", err), http.StatusInternalServerError)
-		return
+		// Delete the transaction; settled ones and billing charges stay on record
+		err = stores.Transactions.Delete(r.Context(), id, version)
+		if errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "transaction")
+			return
+		}
+		if errors.Is(err, store.ErrConflict) {
+			writeProblem(w, r, http.StatusConflict, CodeConflict, "settled transactions and billing charges can't be deleted; settle, refund or reverse them instead")
+			return
+		}
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// There is nothing left to show
+		w.WriteHeader(http.StatusNoContent)
 	}
+}
+
+// view a transaction's status timeline
+func getTransactionEvents(stores store.Stores) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Set response header
+		w.Header().Set("Content-Type", "application/json")
+
+		// Parse transaction ID from request URL
+		id, err := pathID(r, "id")
+		if err != nil {
+			badRequest(w, r, err)
+			return
+		}
+
+		if _, err := loadTransaction(r, stores, id); errors.Is(err, store.ErrNotFound) {
+			notFound(w, r, "transaction")
+			return
+		} else if err != nil {
+			writeError(w, r, err)
+			return
+		}
 
-	// Write a success message to the response
-	fmt.Fprintf(w, "Transaction %s deleted successfully", id)
+		events, err := stores.Transactions.Events(r.Context(), id)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		// The timeline is short, so it comes in a single page, oldest event first
+		if err := json.NewEncoder(w).Encode(listResponse[models.TransactionEvent]{Data: events}); err != nil {
+			writeError(w, r, err)
+		}
+	}
 }
 
-func TransactionsRouter() * mux.Router {
-	router  := mux.NewRouter()
-	
-	router.HandleFunc("/transactions", getAllTransactionsHandler).Methods("GET")
-	router.HandleFunc("/transactions", createTransaction).Methods("POST")
-	router.HandleFunc("/transactions/{id}", getTransaction).Methods("GET")
-	router.HandleFunc("/transactions/{id}", updateTransaction).Methods("PUT")
-	router.HandleFunc("/transactions/{id}", deleteTransaction).Methods("DELETE")
+func TransactionsRouter(stores store.Stores) *mux.Router {
+	router := mux.NewRouter()
+
+	// endpoints for transactions, each guarded by a token carrying the listed scope
+	router.Handle("/transactions", ValidateJWT(getAllTransactionsHandler(stores.Transactions), ScopeTransactionsRead)).Methods("GET")
+	router.Handle("/transactions", ValidateJWT(idempotent(stores.IdempotencyKeys, createTransaction(stores)), ScopeTransactionsWrite)).Methods("POST")
+	router.Handle("/transactions/{id}", ValidateJWT(getTransaction(stores), ScopeTransactionsRead)).Methods("GET")
+	router.Handle("/transactions/{id}", ValidateJWT(updateTransaction(stores), ScopeTransactionsWrite)).Methods("PUT")
+	router.Handle("/transactions/{id}", ValidateJWT(patchTransaction(stores), ScopeTransactionsWrite)).Methods("PATCH")
+	router.Handle("/transactions/{id}", ValidateJWT(deleteTransaction(stores), ScopeTransactionsWrite)).Methods("DELETE")
+	router.Handle("/transactions/{id}/events", ValidateJWT(getTransactionEvents(stores), ScopeTransactionsRead)).Methods("GET")
 
 	return router
 }
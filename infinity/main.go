@@ -1,36 +1,110 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"infinity/billing"
+	"infinity/database"
+	"infinity/handlers"
+	"infinity/store"
 	"log"
 	"net/http"
-	"infinity/handlers"
+	"os"
+	"time"
+
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
-	
+
 	_ "github.com/lib/pq"
 )
 
- 
 func main() {
 
 	if err := godotenv.Load(); err != nil {
-        log.Fatal("Error loading .env file")
-    }
-	
+		log.Fatal("Error loading .env file")
+	}
+
+	// Create the shared connection pool once and hand it to every router
+	cfg, err := database.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	db, err := database.Open(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	// Subcommands such as `infinity migrate up` run and exit instead of serving
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			err = runMigrate(db, os.Args[2:])
+		case "bill":
+			err = runBill(db, os.Args[2:])
+		case "expire":
+			err = runExpire(db, os.Args[2:])
+		default:
+			err = fmt.Errorf("unknown command %q", os.Args[1])
+		}
+		if err != nil {
+			db.Close()
+			log.Fatal(err)
+		}
+		return
+	}
+
+	stores := store.NewPostgres(db)
+
+	// Load the asymmetric JWT keys; without them tokens are HMAC signed with SECRET_KEY
+	keySet, err := handlers.LoadKeySetFromEnv()
+	if err != nil {
+		log.Fatalf("failed to load JWT keys: %v", err)
+	}
+	handlers.SetKeySet(keySet)
+
+	// Share revoked tokens across processes through Redis when it is configured
+	if url := os.Getenv("REDIS_URL"); url != "" {
+		revocations, err := store.NewRedisRevocationList(url)
+		if err != nil {
+			log.Fatalf("failed to connect to redis: %v", err)
+		}
+		defer revocations.Close()
+		handlers.SetRevocationList(revocations)
+	} else {
+		log.Println("REDIS_URL is not set, keeping revoked tokens in memory")
+	}
+
+	// Drop expired Idempotency-Keys once an hour; expired keys are ignored meanwhile
+	go func() {
+		for range time.Tick(time.Hour) {
+			if _, err := stores.IdempotencyKeys.DeleteExpired(context.Background(), time.Now()); err != nil {
+				log.Printf("failed to delete expired idempotency keys: %v", err)
+			}
+		}
+	}()
+
+	// Expire ended subscriptions and bill due ones when BILLING_INTERVAL is set; runs
+	// are safe to overlap with `infinity bill` or other servers, as a period is only
+	// ever charged once and an end date only ever renewed once
+	if interval := billingInterval(); interval > 0 {
+		go runBillingLoop(billing.NewEngine(stores), interval)
+	}
+
 	router := mux.NewRouter()
 
-// Use http.NewServeMux() to create a new ServeMux and register handlers
+	// Use http.NewServeMux() to create a new ServeMux and register handlers
 	router.Handle("/api", handlers.ValidateJWT(http.HandlerFunc(handlers.Home)))
-	router.HandleFunc("/jwt", handlers.GetJWT)
-	router.PathPrefix("/partners").Handler(handlers.PartnersRouter())
-	router.PathPrefix("/subscriptions").Handler(handlers.SubscriptionsRouter())
-	router.PathPrefix("/transactions").Handler(handlers.TransactionsRouter())
+	router.HandleFunc("/jwt", handlers.GetJWT(stores.APIKeys))
+	router.HandleFunc("/.well-known/jwks.json", handlers.JWKS).Methods("GET")
+	router.Handle("/logout", handlers.ValidateJWT(http.HandlerFunc(handlers.Logout))).Methods("POST")
+	router.PathPrefix("/partners").Handler(handlers.PartnersRouter(stores))
+	router.PathPrefix("/subscriptions").Handler(handlers.SubscriptionsRouter(stores))
+	router.PathPrefix("/transactions").Handler(handlers.TransactionsRouter(stores))
+	router.PathPrefix("/billing").Handler(handlers.BillingRouter(stores))
 
-// Use http.ListenAndServe() to start the server on port 8080
+	// Use http.ListenAndServe() to start the server on port 8080
 	if err := http.ListenAndServe(":8080", router); err != nil {
 		fmt.Printf("Error starting server: %v\n", err)
 	}
 }
-
-
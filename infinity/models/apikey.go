@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+)
+
+// APIKey is a credential a partner exchanges for a JWT at /jwt.
+// Only a hash of the key is stored; the plain key is shown once at creation
+type APIKey struct {
+	ID         int        `json:"id"`
+	PartnerID  int        `json:"partner_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// BillingCycles are the cycles a subscription or plan can be billed on
+var BillingCycles = []string{"daily", "weekly", "monthly", "yearly"}
+
+// AdvanceBillingDate returns the billing date one cycle after from. Monthly and
+// yearly cycles stay on the day of the month the subscription started, anchorDay,
+// falling back to the last day of shorter months: a subscription started on
+// January 31 bills on February 28 and then on March 31
+func AdvanceBillingDate(cycle string, from time.Time, anchorDay int) time.Time {
+	switch cycle {
+	case "daily":
+		return from.AddDate(0, 0, 1)
+	case "weekly":
+		return from.AddDate(0, 0, 7)
+	case "monthly":
+		return onAnchorDay(from.Year(), from.Month()+1, anchorDay, from)
+	case "yearly":
+		return onAnchorDay(from.Year()+1, from.Month(), anchorDay, from)
+	}
+	panic(fmt.Sprintf("models: unknown billing cycle %q", cycle))
+}
+
+// FirstBillingDate is when s is billed first: the day its trial ends, or
+// without a trial the day it starts
+func (s Subscriptions) FirstBillingDate() time.Time {
+	if s.TrialEndsAt != nil {
+		return *s.TrialEndsAt
+	}
+	return s.StartDate
+}
+
+// BillingAnchorDay is the day of the month the billing dates of s keep to
+func (s Subscriptions) BillingAnchorDay() int {
+	return s.FirstBillingDate().Day()
+}
+
+// ScheduledBillingDate is when sub is billed next given lastPeriod, the latest
+// period charged to it: its first billing date until it has been charged, and one
+// billing cycle on from that period afterwards
+func ScheduledBillingDate(sub Subscriptions, lastPeriod *time.Time) time.Time {
+	if lastPeriod == nil {
+		return sub.FirstBillingDate()
+	}
+	return AdvanceBillingDate(sub.BillingCycle, *lastPeriod, sub.BillingAnchorDay())
+}
+
+// RenewedEndDate is the end date of sub once renewed for another billing cycle.
+// An end date on a billing date stays on the billing anchor day; any other keeps
+// to its own day of the month
+func RenewedEndDate(sub Subscriptions) time.Time {
+	anchor := sub.EndDate.Day()
+	if onAnchorDay(sub.EndDate.Year(), sub.EndDate.Month(), sub.BillingAnchorDay(), sub.EndDate).Equal(sub.EndDate) {
+		anchor = sub.BillingAnchorDay()
+	}
+	return AdvanceBillingDate(sub.BillingCycle, sub.EndDate, anchor)
+}
+
+// onAnchorDay builds the date in the given month at the time of day of clock,
+// on anchorDay or the month's last day, whichever comes first
+func onAnchorDay(year int, month time.Month, anchorDay int, clock time.Time) time.Time {
+	// day 0 of the following month is the last day of this one
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, clock.Location()).Day()
+	day := anchorDay
+	if day > last {
+		day = last
+	}
+	return time.Date(year, month, day, clock.Hour(), clock.Minute(), clock.Second(), clock.Nanosecond(), clock.Location())
+}
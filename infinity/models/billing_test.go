@@ -0,0 +1,127 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+}
+
+func TestAdvanceBillingDate(t *testing.T) {
+	tests := []struct {
+		name   string
+		cycle  string
+		from   time.Time
+		anchor int
+		want   time.Time
+	}{
+		{"daily", "daily", date(2026, 2, 28), 28, date(2026, 3, 1)},
+		{"weekly across a month", "weekly", date(2026, 1, 29), 29, date(2026, 2, 5)},
+		{"monthly", "monthly", date(2026, 1, 15), 15, date(2026, 2, 15)},
+		{"monthly Jan 31 to Feb 28", "monthly", date(2026, 1, 31), 31, date(2026, 2, 28)},
+		{"monthly Feb 28 back to Mar 31", "monthly", date(2026, 2, 28), 31, date(2026, 3, 31)},
+		{"monthly Mar 31 to Apr 30", "monthly", date(2026, 3, 31), 31, date(2026, 4, 30)},
+		{"monthly Jan 31 to Feb 29 in a leap year", "monthly", date(2028, 1, 31), 31, date(2028, 2, 29)},
+		{"monthly Jan 30 to Feb 28", "monthly", date(2026, 1, 30), 30, date(2026, 2, 28)},
+		{"monthly Dec to Jan", "monthly", date(2026, 12, 31), 31, date(2027, 1, 31)},
+		{"yearly", "yearly", date(2026, 6, 1), 1, date(2027, 6, 1)},
+		{"yearly Feb 29 to Feb 28", "yearly", date(2028, 2, 29), 29, date(2029, 2, 28)},
+		{"yearly Feb 28 back to Feb 29", "yearly", date(2031, 2, 28), 29, date(2032, 2, 29)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AdvanceBillingDate(tt.cycle, tt.from, tt.anchor); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdvanceBillingDateKeepsTheAnchor(t *testing.T) {
+	// a subscription started on January 31 comes back to the 31st whenever the month has one
+	want := []time.Time{
+		date(2026, 2, 28), date(2026, 3, 31), date(2026, 4, 30), date(2026, 5, 31),
+		date(2026, 6, 30), date(2026, 7, 31), date(2026, 8, 31), date(2026, 9, 30),
+	}
+	d := date(2026, 1, 31)
+	for _, w := range want {
+		d = AdvanceBillingDate("monthly", d, 31)
+		if !d.Equal(w) {
+			t.Fatalf("got %v, want %v", d, w)
+		}
+	}
+}
+
+func TestFirstBillingDate(t *testing.T) {
+	trialEnd := date(2026, 1, 15)
+	tests := []struct {
+		name       string
+		sub        Subscriptions
+		want       time.Time
+		wantAnchor int
+	}{
+		{"no trial", Subscriptions{StartDate: date(2026, 1, 1)}, date(2026, 1, 1), 1},
+		{"trial", Subscriptions{StartDate: date(2026, 1, 1), TrialEndsAt: &trialEnd}, trialEnd, 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sub.FirstBillingDate(); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if got := tt.sub.BillingAnchorDay(); got != tt.wantAnchor {
+				t.Errorf("got anchor day %d, want %d", got, tt.wantAnchor)
+			}
+		})
+	}
+}
+
+func TestScheduledBillingDate(t *testing.T) {
+	trialEnd := date(2026, 1, 31)
+	lastPeriod := date(2026, 2, 28)
+	tests := []struct {
+		name string
+		sub  Subscriptions
+		last *time.Time
+		want time.Time
+	}{
+		{"never charged", Subscriptions{BillingCycle: "monthly", StartDate: date(2026, 1, 10)}, nil, date(2026, 1, 10)},
+		{"never charged, in trial", Subscriptions{BillingCycle: "monthly", StartDate: date(2026, 1, 10), TrialEndsAt: &trialEnd}, nil, trialEnd},
+		{"charged", Subscriptions{BillingCycle: "monthly", StartDate: date(2026, 1, 31)}, &lastPeriod, date(2026, 3, 31)},
+		{"charged, cycle changed", Subscriptions{BillingCycle: "weekly", StartDate: date(2026, 1, 31)}, &lastPeriod, date(2026, 3, 7)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ScheduledBillingDate(tt.sub, tt.last); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenewedEndDate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cycle string
+		start time.Time
+		end   time.Time
+		want  time.Time
+	}{
+		{"on a billing date", "monthly", date(2026, 1, 10), date(2026, 4, 10), date(2026, 5, 10)},
+		{"back to the 31st", "monthly", date(2026, 1, 31), date(2026, 4, 30), date(2026, 5, 31)},
+		{"Jan 31 to Feb 28", "monthly", date(2026, 1, 31), date(2027, 1, 31), date(2027, 2, 28)},
+		{"off the billing dates keeps its own day", "monthly", date(2026, 1, 31), date(2026, 4, 15), date(2026, 5, 15)},
+		{"the 30th off the billing dates", "monthly", date(2026, 1, 10), date(2026, 4, 30), date(2026, 5, 30)},
+		{"weekly", "weekly", date(2026, 1, 1), date(2026, 3, 26), date(2026, 4, 2)},
+		{"yearly back to Feb 29", "yearly", date(2028, 2, 29), date(2031, 2, 28), date(2032, 2, 29)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := Subscriptions{BillingCycle: tt.cycle, StartDate: tt.start, EndDate: tt.end}
+			if got := RenewedEndDate(sub); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
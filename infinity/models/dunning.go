@@ -0,0 +1,137 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Dunning states of a subscription
+const (
+	// DunningCurrent has no failed charge outstanding
+	DunningCurrent = "current"
+	// DunningRetrying retries its failed charge at NextAttemptAt, or is waiting
+	// on the outcome of the retry when NextAttemptAt is empty
+	DunningRetrying = "retrying"
+	// DunningExhausted failed every retry of its partner's schedule and stays
+	// suspended until someone resumes it
+	DunningExhausted = "exhausted"
+)
+
+// DunningActor is the actor of the status changes dunning makes
+const DunningActor = "dunning"
+
+// DefaultRetrySchedule and DefaultSuspendAfter are the dunning policy of partners
+// that don't set their own
+var DefaultRetrySchedule = RetrySchedule{time.Hour, 6 * time.Hour, 24 * time.Hour}
+
+const DefaultSuspendAfter = 3
+
+// RetrySchedule is how long dunning waits after each failed charge before
+// retrying it, written in JSON as durations such as ["1h", "6h", "24h"]
+type RetrySchedule []time.Duration
+
+// ParseRetrySchedule reads a schedule from its durations
+func ParseRetrySchedule(durations []string) (RetrySchedule, error) {
+	s := make(RetrySchedule, 0, len(durations))
+	for _, raw := range durations {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%q is not a positive duration such as \"6h\"", raw)
+		}
+		s = append(s, d)
+	}
+	return s, nil
+}
+
+// Strings renders the schedule as durations such as "1h30m"
+func (s RetrySchedule) Strings() []string {
+	out := make([]string, len(s))
+	for i, d := range s {
+		// time.Duration writes 6h as "6h0m0s"
+		v := d.String()
+		if strings.HasSuffix(v, "m0s") {
+			v = strings.TrimSuffix(v, "0s")
+		}
+		if strings.HasSuffix(v, "h0m") {
+			v = strings.TrimSuffix(v, "0m")
+		}
+		out[i] = v
+	}
+	return out
+}
+
+func (s RetrySchedule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Strings())
+}
+
+func (s *RetrySchedule) UnmarshalJSON(data []byte) error {
+	var durations []string
+	if err := json.Unmarshal(data, &durations); err != nil {
+		return err
+	}
+	parsed, err := ParseRetrySchedule(durations)
+	if err != nil {
+		return &ValidationError{Fields: []FieldError{{Field: "retry_schedule", Rule: "duration", Message: err.Error()}}}
+	}
+	*s = parsed
+	return nil
+}
+
+// SetDunningDefaults fills in the dunning policy a request left out
+func (p *Partner) SetDunningDefaults() {
+	if p.RetrySchedule == nil {
+		p.RetrySchedule = append(RetrySchedule(nil), DefaultRetrySchedule...)
+	}
+	if p.SuspendAfter == 0 {
+		p.SuspendAfter = DefaultSuspendAfter
+	}
+}
+
+// ChargeSettled updates the dunning state of sub for billing charge t, which has
+// just succeeded or failed at at, under its partner's policy. A failure schedules
+// the next retry; the suspendAfter-th failure in a row, or one that leaves no retry,
+// suspends an active subscription. A success clears the failures and reactivates a
+// subscription that is suspended. It returns the status change to record, if any
+func ChargeSettled(sub *Subscriptions, t Transactions, schedule RetrySchedule, suspendAfter int, at time.Time) *SubscriptionStatusChange {
+	if t.Status == TransactionSucceeded {
+		failed := sub.FailedAttempts
+		sub.DunningState, sub.FailedAttempts, sub.NextAttemptAt, sub.DunningPeriod = DunningCurrent, 0, nil, nil
+		if failed == 0 || sub.Status != SubscriptionSuspended {
+			return nil
+		}
+		return setStatus(sub, "resume", "a charge succeeded after failing")
+	}
+
+	sub.FailedAttempts++
+	sub.DunningPeriod = t.BillingPeriod
+	if sub.FailedAttempts <= len(schedule) {
+		next := at.Add(schedule[sub.FailedAttempts-1])
+		sub.DunningState, sub.NextAttemptAt = DunningRetrying, &next
+	} else {
+		sub.DunningState, sub.NextAttemptAt = DunningExhausted, nil
+	}
+	if sub.Status != SubscriptionActive || (sub.FailedAttempts < suspendAfter && sub.DunningState != DunningExhausted) {
+		return nil
+	}
+	return setStatus(sub, "suspend", fmt.Sprintf("%d charges in a row failed", sub.FailedAttempts))
+}
+
+// ClearsDunning reports whether a move to status ends the subscription's dunning.
+// A resumed subscription starts over with no failures behind it, and a cancelled
+// or expired one is never retried
+func ClearsDunning(status string) bool {
+	return status == SubscriptionActive || status == SubscriptionCancelled || status == SubscriptionExpired
+}
+
+// setStatus moves sub through a lifecycle action on behalf of dunning
+func setStatus(sub *Subscriptions, action, reason string) *SubscriptionStatusChange {
+	to, err := SubscriptionTransition(action, sub.Status)
+	if err != nil {
+		return nil
+	}
+	change := &SubscriptionStatusChange{SubscriptionID: sub.ID, FromStatus: sub.Status, ToStatus: to, Reason: reason, Actor: DunningActor}
+	sub.Status = to
+	return change
+}
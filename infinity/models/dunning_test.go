@@ -0,0 +1,92 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChargeSettled(t *testing.T) {
+	at := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	period := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	schedule := RetrySchedule{time.Hour, 6 * time.Hour}
+	later := func(d time.Duration) *time.Time {
+		t := at.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name         string
+		status       string
+		failed       int
+		suspendAfter int
+		outcome      string
+		wantState    string
+		wantFailed   int
+		wantNext     *time.Time
+		wantStatus   string
+		wantChange   bool
+	}{
+		{"first failure schedules a retry", SubscriptionActive, 0, 3, TransactionFailed, DunningRetrying, 1, later(time.Hour), SubscriptionActive, false},
+		{"second failure waits longer", SubscriptionActive, 1, 3, TransactionFailed, DunningRetrying, 2, later(6 * time.Hour), SubscriptionActive, false},
+		{"failure past the schedule exhausts and suspends", SubscriptionActive, 2, 5, TransactionFailed, DunningExhausted, 3, nil, SubscriptionSuspended, true},
+		{"suspend_after-th failure suspends", SubscriptionActive, 0, 1, TransactionFailed, DunningRetrying, 1, later(time.Hour), SubscriptionSuspended, true},
+		{"failure of a suspended subscription", SubscriptionSuspended, 1, 1, TransactionFailed, DunningRetrying, 2, later(6 * time.Hour), SubscriptionSuspended, false},
+		{"success clears failures", SubscriptionActive, 1, 3, TransactionSucceeded, DunningCurrent, 0, nil, SubscriptionActive, false},
+		{"success reactivates", SubscriptionSuspended, 2, 2, TransactionSucceeded, DunningCurrent, 0, nil, SubscriptionActive, true},
+		{"success leaves a manual suspension alone", SubscriptionSuspended, 0, 3, TransactionSucceeded, DunningCurrent, 0, nil, SubscriptionSuspended, false},
+		{"failure of a cancelled subscription", SubscriptionCancelled, 2, 1, TransactionFailed, DunningExhausted, 3, nil, SubscriptionCancelled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := Subscriptions{ID: 7, Status: tt.status, FailedAttempts: tt.failed}
+			change := ChargeSettled(&sub, Transactions{Status: tt.outcome, BillingPeriod: &period}, schedule, tt.suspendAfter, at)
+
+			if sub.DunningState != tt.wantState || sub.FailedAttempts != tt.wantFailed || sub.Status != tt.wantStatus {
+				t.Errorf("got %s with %d failures and status %s, want %s with %d and %s",
+					sub.DunningState, sub.FailedAttempts, sub.Status, tt.wantState, tt.wantFailed, tt.wantStatus)
+			}
+			if !reflect.DeepEqual(sub.NextAttemptAt, tt.wantNext) {
+				t.Errorf("got next attempt %v, want %v", sub.NextAttemptAt, tt.wantNext)
+			}
+			if tt.outcome == TransactionFailed && (sub.DunningPeriod == nil || !sub.DunningPeriod.Equal(period)) {
+				t.Errorf("got dunning period %v, want %v", sub.DunningPeriod, period)
+			}
+			if (change != nil) != tt.wantChange {
+				t.Fatalf("got status change %+v, want one: %v", change, tt.wantChange)
+			}
+			if change != nil && (change.SubscriptionID != 7 || change.FromStatus != tt.status || change.ToStatus != tt.wantStatus || change.Actor != DunningActor) {
+				t.Errorf("got status change %+v", change)
+			}
+		})
+	}
+}
+
+func TestRetrySchedule(t *testing.T) {
+	tests := []struct {
+		in      []string
+		want    RetrySchedule
+		wantErr bool
+	}{
+		{[]string{"1h", "6h", "24h"}, RetrySchedule{time.Hour, 6 * time.Hour, 24 * time.Hour}, false},
+		{[]string{"90m"}, RetrySchedule{90 * time.Minute}, false},
+		{[]string{}, RetrySchedule{}, false},
+		{[]string{"0s"}, nil, true},
+		{[]string{"-1h"}, nil, true},
+		{[]string{"tomorrow"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRetrySchedule(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: got error %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	if got := (RetrySchedule{time.Hour, 90 * time.Minute, 30 * time.Second}).Strings(); !reflect.DeepEqual(got, []string{"1h", "1h30m", "30s"}) {
+		t.Errorf("got %v", got)
+	}
+}
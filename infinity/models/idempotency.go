@@ -0,0 +1,22 @@
+package models
+
+import (
+	"net/http"
+	"time"
+)
+
+// IdempotencyKey is an Idempotency-Key a client sent with a create request,
+// kept with a hash of that request and the response it got so a retry can be
+// answered with the same response instead of running again
+type IdempotencyKey struct {
+	// Scope is the caller the key belongs to; keys of different callers never collide
+	Scope       string
+	Key         string
+	RequestHash string
+	// ResponseStatus is zero while the first request is still being processed
+	ResponseStatus int
+	ResponseHeader http.Header
+	ResponseBody   []byte
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+}
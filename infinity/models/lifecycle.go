@@ -0,0 +1,123 @@
+package models
+
+import "fmt"
+
+// Subscription statuses
+const (
+	SubscriptionPending   = "pending"
+	SubscriptionActive    = "active"
+	SubscriptionSuspended = "suspended"
+	SubscriptionCancelled = "cancelled"
+	SubscriptionExpired   = "expired"
+)
+
+// SubscriptionStatuses are every status a subscription can have
+var SubscriptionStatuses = []string{SubscriptionPending, SubscriptionActive, SubscriptionSuspended, SubscriptionCancelled, SubscriptionExpired}
+
+// transition is one lifecycle action: the statuses it may start from and the one it leads to
+type transition struct {
+	from []string
+	to   string
+}
+
+// subscriptionActions is the subscription lifecycle. Cancelled and expired are final
+var subscriptionActions = map[string]transition{
+	"activate": {[]string{SubscriptionPending}, SubscriptionActive},
+	"suspend":  {[]string{SubscriptionActive}, SubscriptionSuspended},
+	"resume":   {[]string{SubscriptionSuspended}, SubscriptionActive},
+	"cancel":   {[]string{SubscriptionPending, SubscriptionActive, SubscriptionSuspended}, SubscriptionCancelled},
+	"expire":   {[]string{SubscriptionPending, SubscriptionActive, SubscriptionSuspended}, SubscriptionExpired},
+}
+
+// InitialSubscriptionStatuses are the statuses a subscription may be created with
+var InitialSubscriptionStatuses = []string{SubscriptionPending, SubscriptionActive}
+
+// Transaction statuses
+const (
+	TransactionPending           = "pending"
+	TransactionSucceeded         = "succeeded"
+	TransactionFailed            = "failed"
+	TransactionRefunded          = "refunded"
+	TransactionPartiallyRefunded = "partially_refunded"
+	TransactionReversed          = "reversed"
+)
+
+// TransactionStatuses are every status a transaction can have
+var TransactionStatuses = []string{TransactionPending, TransactionSucceeded, TransactionFailed, TransactionRefunded, TransactionPartiallyRefunded, TransactionReversed}
+
+// transactionTransitions lists the statuses each transaction status may move to.
+// Failed, refunded and reversed are final
+var transactionTransitions = map[string][]string{
+	TransactionPending:           {TransactionSucceeded, TransactionFailed},
+	TransactionSucceeded:         {TransactionRefunded, TransactionPartiallyRefunded, TransactionReversed},
+	TransactionPartiallyRefunded: {TransactionRefunded, TransactionReversed},
+}
+
+// InitialTransactionStatuses are the statuses a transaction may be created with;
+// a charge that was settled elsewhere may be recorded with its outcome
+var InitialTransactionStatuses = []string{TransactionPending, TransactionSucceeded, TransactionFailed}
+
+// TransactionSettled reports whether a transaction in status has left pending.
+// A settled transaction's financial fields never change again
+func TransactionSettled(status string) bool {
+	return status != TransactionPending
+}
+
+// TransitionError reports a status change the lifecycle doesn't allow
+type TransitionError struct {
+	Message string
+}
+
+func (e *TransitionError) Error() string {
+	return e.Message
+}
+
+// SubscriptionTransition returns the status a lifecycle action such as "suspend"
+// leads to from status from, or a *TransitionError when the action isn't allowed there
+func SubscriptionTransition(action, from string) (string, error) {
+	t, ok := subscriptionActions[action]
+	if !ok {
+		panic(fmt.Sprintf("models: unknown subscription action %q", action))
+	}
+	for _, s := range t.from {
+		if s == from {
+			return t.to, nil
+		}
+	}
+	return "", &TransitionError{Message: fmt.Sprintf("cannot %s a subscription that is %s", action, from)}
+}
+
+// CheckTransactionChange checks that a write may turn the stored transaction from
+// into to: the status follows the lifecycle, and once from is settled its financial
+// fields stay as they are. It returns a *TransitionError or a *ValidationError
+func CheckTransactionChange(from, to Transactions) error {
+	if from.Status != to.Status && !contains(transactionTransitions[from.Status], to.Status) {
+		return &TransitionError{Message: fmt.Sprintf("cannot move a transaction from %s to %s", from.Status, to.Status)}
+	}
+	if !TransactionSettled(from.Status) {
+		return nil
+	}
+	var errs []FieldError
+	immutable := func(field string, changed bool) {
+		if changed {
+			errs = append(errs, FieldError{Field: field, Rule: "immutable", Message: "can't change once the transaction is settled"})
+		}
+	}
+	immutable("subscription_id", to.SubscriptionID != from.SubscriptionID)
+	immutable("transaction_date", !to.TransactionDate.Equal(from.TransactionDate))
+	immutable("amount", to.Amount != from.Amount)
+	immutable("currency", to.Currency != from.Currency)
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionTransition(t *testing.T) {
+	// every action from every status; a missing entry is a forbidden transition
+	allowed := map[string]map[string]string{
+		"activate": {SubscriptionPending: SubscriptionActive},
+		"suspend":  {SubscriptionActive: SubscriptionSuspended},
+		"resume":   {SubscriptionSuspended: SubscriptionActive},
+		"cancel": {
+			SubscriptionPending:   SubscriptionCancelled,
+			SubscriptionActive:    SubscriptionCancelled,
+			SubscriptionSuspended: SubscriptionCancelled,
+		},
+		"expire": {
+			SubscriptionPending:   SubscriptionExpired,
+			SubscriptionActive:    SubscriptionExpired,
+			SubscriptionSuspended: SubscriptionExpired,
+		},
+	}
+	for action, to := range allowed {
+		for _, from := range SubscriptionStatuses {
+			t.Run(action+" from "+from, func(t *testing.T) {
+				got, err := SubscriptionTransition(action, from)
+				want, ok := to[from]
+				if !ok {
+					var transition *TransitionError
+					if !errors.As(err, &transition) {
+						t.Fatalf("got %q, %v; want a *TransitionError", got, err)
+					}
+					return
+				}
+				if err != nil || got != want {
+					t.Errorf("got %q, %v; want %q", got, err, want)
+				}
+			})
+		}
+	}
+}
+
+func TestSubscriptionFinalStatuses(t *testing.T) {
+	for _, from := range []string{SubscriptionCancelled, SubscriptionExpired} {
+		for action := range subscriptionActions {
+			if to, err := SubscriptionTransition(action, from); err == nil {
+				t.Errorf("%s moved a %s subscription to %s", action, from, to)
+			}
+		}
+	}
+}
+
+func TestSubscriptionTransitionUnknownAction(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("an unknown action did not panic")
+		}
+	}()
+	SubscriptionTransition("pause", SubscriptionActive)
+}
+
+func TestCheckTransactionChangeStatus(t *testing.T) {
+	// every move between statuses; a missing entry is a forbidden transition
+	allowed := map[string][]string{
+		TransactionPending:           {TransactionSucceeded, TransactionFailed},
+		TransactionSucceeded:         {TransactionRefunded, TransactionPartiallyRefunded, TransactionReversed},
+		TransactionPartiallyRefunded: {TransactionRefunded, TransactionReversed},
+	}
+	for _, from := range TransactionStatuses {
+		for _, to := range TransactionStatuses {
+			t.Run(from+" to "+to, func(t *testing.T) {
+				err := CheckTransactionChange(Transactions{Status: from}, Transactions{Status: to})
+				if from == to || contains(allowed[from], to) {
+					if err != nil {
+						t.Errorf("got %v, want the change allowed", err)
+					}
+					return
+				}
+				var transition *TransitionError
+				if !errors.As(err, &transition) {
+					t.Errorf("got %v, want a *TransitionError", err)
+				}
+			})
+		}
+	}
+}
+
+func TestCheckTransactionChangeSettledFields(t *testing.T) {
+	stored := Transactions{SubscriptionID: 1, Amount: 1050, Currency: "KES", TransactionDate: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		name   string
+		status string
+		change func(*Transactions)
+		field  string
+	}{
+		{"pending amount", TransactionPending, func(t *Transactions) { t.Amount = 2000 }, ""},
+		{"pending currency", TransactionPending, func(t *Transactions) { t.Currency = "USD" }, ""},
+		{"settled amount", TransactionSucceeded, func(t *Transactions) { t.Amount = 2000 }, "amount"},
+		{"settled currency", TransactionFailed, func(t *Transactions) { t.Currency = "USD" }, "currency"},
+		{"settled subscription", TransactionSucceeded, func(t *Transactions) { t.SubscriptionID = 2 }, "subscription_id"},
+		{"settled date", TransactionRefunded, func(t *Transactions) { t.TransactionDate = t.TransactionDate.Add(time.Hour) }, "transaction_date"},
+		{"settled date in another zone", TransactionSucceeded, func(t *Transactions) { t.TransactionDate = t.TransactionDate.In(time.FixedZone("EAT", 3*3600)) }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from := stored
+			from.Status = tt.status
+			to := from
+			tt.change(&to)
+
+			err := CheckTransactionChange(from, to)
+			if tt.field == "" {
+				if err != nil {
+					t.Errorf("got %v, want the change allowed", err)
+				}
+				return
+			}
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) || len(invalid.Fields) != 1 || invalid.Fields[0].Field != tt.field {
+				t.Errorf("got %v, want %s refused", err, tt.field)
+			}
+		})
+	}
+}
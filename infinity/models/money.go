@@ -0,0 +1,275 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Money is an exact amount in the minor unit of its currency, e.g. cents.
+// It is stored as an integer and sent over the wire as a decimal string such
+// as "10.50"; the currency lives next to it on the model
+type Money int64
+
+// Currency describes how amounts in one ISO 4217 currency are written and rounded
+type Currency struct {
+	Code string
+	// Exponent is the number of decimal places of the minor unit: 2 for KES, 0 for UGX
+	Exponent int
+}
+
+// currencies lists the ISO 4217 codes the API accepts
+var currencies = map[string]Currency{
+	"KES": {"KES", 2},
+	"UGX": {"UGX", 0},
+	"TZS": {"TZS", 2},
+	"RWF": {"RWF", 0},
+	"NGN": {"NGN", 2},
+	"GHS": {"GHS", 2},
+	"ZAR": {"ZAR", 2},
+	"USD": {"USD", 2},
+	"EUR": {"EUR", 2},
+	"GBP": {"GBP", 2},
+	"JPY": {"JPY", 0},
+	"KWD": {"KWD", 3},
+}
+
+// DefaultCurrency is assumed for requests that name no currency and for rows
+// written before amounts carried one
+const DefaultCurrency = "KES"
+
+// LookupCurrency returns the rules of an ISO 4217 code the API accepts
+func LookupCurrency(code string) (Currency, bool) {
+	c, ok := currencies[code]
+	return c, ok
+}
+
+// Parse reads an exact decimal string such as "10.50". Digits past the minor
+// unit are rejected unless they are zeros; nothing is rounded
+func (c Currency) Parse(s string) (Money, error) {
+	neg, whole, frac, err := splitDecimal(s)
+	if err != nil {
+		return 0, err
+	}
+	if len(frac) > c.Exponent {
+		if strings.Trim(frac[c.Exponent:], "0") != "" {
+			return 0, fmt.Errorf("%q has more than %d decimal places, the most %s allows", s, c.Exponent, c.Code)
+		}
+		frac = frac[:c.Exponent]
+	}
+	return c.minor(neg, whole, frac, false)
+}
+
+// Round reads a decimal string and rounds it to the minor unit, halves away from zero
+func (c Currency) Round(s string) (Money, error) {
+	neg, whole, frac, err := splitDecimal(s)
+	if err != nil {
+		return 0, err
+	}
+	roundUp := false
+	if len(frac) > c.Exponent {
+		roundUp = frac[c.Exponent] >= '5'
+		frac = frac[:c.Exponent]
+	}
+	return c.minor(neg, whole, frac, roundUp)
+}
+
+// FromFloat converts an amount sent in the deprecated float format. The float is
+// read back as the shortest decimal that produces it, so 1.005 rounds to 1.01
+// and not to the 1.00 its binary approximation would give
+func (c Currency) FromFloat(f float64) (Money, error) {
+	return c.Round(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// Format renders m as a decimal string with exactly the currency's decimal places
+func (c Currency) Format(m Money) string {
+	s := strconv.FormatInt(int64(m), 10)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	if c.Exponent > 0 {
+		if len(s) <= c.Exponent {
+			s = strings.Repeat("0", c.Exponent-len(s)+1) + s
+		}
+		s = s[:len(s)-c.Exponent] + "." + s[len(s)-c.Exponent:]
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// FormatMoney renders m in the currency with the ISO 4217 code, e.g. "10.50" for 1050 KES
+func FormatMoney(m Money, code string) string {
+	c, _ := LookupCurrency(code)
+	return c.Format(m)
+}
+
+// minor assembles the digits of an amount into minor units
+func (c Currency) minor(neg bool, whole, frac string, roundUp bool) (Money, error) {
+	digits := whole + frac + strings.Repeat("0", c.Exponent-len(frac))
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("amount is out of range")
+	}
+	if roundUp {
+		if n == math.MaxInt64 {
+			return 0, fmt.Errorf("amount is out of range")
+		}
+		n++
+	}
+	if neg {
+		n = -n
+	}
+	return Money(n), nil
+}
+
+// splitDecimal breaks a plain decimal such as "-12.345" into its sign and digits
+func splitDecimal(s string) (neg bool, whole, frac string, err error) {
+	body := s
+	if strings.HasPrefix(body, "-") {
+		neg, body = true, body[1:]
+	}
+	whole, frac, _ = strings.Cut(body, ".")
+	if whole == "" || !allDigits(whole) || !allDigits(frac) {
+		return false, "", "", fmt.Errorf("%q is not a decimal amount such as \"10.50\"", s)
+	}
+	return neg, whole, frac, nil
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeAmount reads an amount field of a request body in currency: a decimal string,
+// or a JSON number in the deprecated float format, which is rounded to the minor unit.
+// An absent field decodes to zero so the required rule can report it
+func decodeAmount(raw json.RawMessage, field, currency string) (Money, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return 0, nil
+	}
+	c, ok := LookupCurrency(currency)
+	if !ok {
+		return 0, &ValidationError{Fields: []FieldError{{Field: "currency", Rule: "currency", Message: currencyMessage}}}
+	}
+
+	var m Money
+	var err error
+	if raw[0] == '"' {
+		var s string
+		if err = json.Unmarshal(raw, &s); err == nil {
+			m, err = c.Parse(s)
+		}
+	} else {
+		var f float64
+		if err = json.Unmarshal(raw, &f); err == nil {
+			m, err = c.FromFloat(f)
+		}
+	}
+	if err != nil {
+		return 0, &ValidationError{Fields: []FieldError{{Field: field, Rule: "amount", Message: err.Error()}}}
+	}
+	return m, nil
+}
+
+// decodeStrict decodes a request body into v, rejecting fields v doesn't have
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// MarshalJSON sends the billing amount as a decimal string in the subscription's currency
+func (s Subscriptions) MarshalJSON() ([]byte, error) {
+	type plain Subscriptions
+	c, _ := LookupCurrency(s.Currency)
+	return json.Marshal(struct {
+		plain
+		BillingAmount string `json:"billing_amount"`
+	}{plain(s), c.Format(s.BillingAmount)})
+}
+
+// UnmarshalJSON reads the billing amount in the subscription's currency,
+// DefaultCurrency when the body names none. A subscription to a plan takes the
+// plan's currency instead, so one naming a plan gets no default
+func (s *Subscriptions) UnmarshalJSON(data []byte) error {
+	type plain Subscriptions
+	body := struct {
+		*plain
+		BillingAmount json.RawMessage `json:"billing_amount"`
+	}{plain: (*plain)(s)}
+	if err := decodeStrict(data, &body); err != nil {
+		return err
+	}
+	if s.Currency == "" && s.PlanID == nil {
+		s.Currency = DefaultCurrency
+	}
+	var err error
+	s.BillingAmount, err = decodeAmount(body.BillingAmount, "billing_amount", s.Currency)
+	return err
+}
+
+// MarshalJSON sends the amount as a decimal string in the transaction's currency
+func (t Transactions) MarshalJSON() ([]byte, error) {
+	type plain Transactions
+	c, _ := LookupCurrency(t.Currency)
+	return json.Marshal(struct {
+		plain
+		Amount string `json:"amount"`
+	}{plain(t), c.Format(t.Amount)})
+}
+
+// UnmarshalJSON reads the amount in the transaction's currency,
+// DefaultCurrency when the body names none
+func (t *Transactions) UnmarshalJSON(data []byte) error {
+	type plain Transactions
+	body := struct {
+		*plain
+		Amount json.RawMessage `json:"amount"`
+	}{plain: (*plain)(t)}
+	if err := decodeStrict(data, &body); err != nil {
+		return err
+	}
+	if t.Currency == "" {
+		t.Currency = DefaultCurrency
+	}
+	var err error
+	t.Amount, err = decodeAmount(body.Amount, "amount", t.Currency)
+	return err
+}
+
+// MarshalJSON sends the price as a decimal string in the plan's currency
+func (p Plan) MarshalJSON() ([]byte, error) {
+	type plain Plan
+	c, _ := LookupCurrency(p.Currency)
+	return json.Marshal(struct {
+		plain
+		Price string `json:"price"`
+	}{plain(p), c.Format(p.Price)})
+}
+
+// UnmarshalJSON reads the price in the plan's currency, DefaultCurrency when the body names none
+func (p *Plan) UnmarshalJSON(data []byte) error {
+	type plain Plan
+	body := struct {
+		*plain
+		Price json.RawMessage `json:"price"`
+	}{plain: (*plain)(p)}
+	if err := decodeStrict(data, &body); err != nil {
+		return err
+	}
+	if p.Currency == "" {
+		p.Currency = DefaultCurrency
+	}
+	var err error
+	p.Price, err = decodeAmount(body.Price, "price", p.Currency)
+	return err
+}
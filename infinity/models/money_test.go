@@ -0,0 +1,216 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestCurrencyParse(t *testing.T) {
+	tests := []struct {
+		currency string
+		in       string
+		want     Money
+		wantErr  bool
+	}{
+		{"KES", "10.50", 1050, false},
+		{"KES", "10.5", 1050, false},
+		{"KES", "10", 1000, false},
+		{"KES", "0.01", 1, false},
+		{"KES", "10.500", 1050, false},
+		{"KES", "-10.50", -1050, false},
+		{"KES", "-0.01", -1, false},
+		{"KES", "10.505", 0, true},
+		{"KES", "0.001", 0, true},
+		{"JPY", "1050", 1050, false},
+		{"JPY", "1050.00", 1050, false},
+		{"JPY", "1050.5", 0, true},
+		{"JPY", "-7", -7, false},
+		{"KWD", "1.234", 1234, false},
+		{"KWD", "1.2345", 0, true},
+		{"UGX", "3700", 3700, false},
+		{"KES", "92233720368547758.07", math.MaxInt64, false},
+		{"KES", "92233720368547758.08", 0, true},
+		{"JPY", "9223372036854775807", math.MaxInt64, false},
+		{"JPY", "9223372036854775808", 0, true},
+		{"KES", "", 0, true},
+		{"KES", "-", 0, true},
+		{"KES", ".50", 0, true},
+		{"KES", "+1.00", 0, true},
+		{"KES", "1,000.00", 0, true},
+		{"KES", "1e3", 0, true},
+		{"KES", " 1.00", 0, true},
+		{"KES", "1.0.0", 0, true},
+		{"KES", "--1", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.currency+" "+tt.in, func(t *testing.T) {
+			c, _ := LookupCurrency(tt.currency)
+			got, err := c.Parse(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCurrencyRound(t *testing.T) {
+	tests := []struct {
+		currency string
+		in       string
+		want     Money
+		wantErr  bool
+	}{
+		{"KES", "10.504", 1050, false},
+		{"KES", "10.505", 1051, false},
+		{"KES", "10.5049999", 1050, false},
+		{"KES", "1.995", 200, false},
+		{"KES", "-10.505", -1051, false},
+		{"KES", "-10.504", -1050, false},
+		{"JPY", "0.5", 1, false},
+		{"JPY", "-0.5", -1, false},
+		{"JPY", "1049.49", 1049, false},
+		{"KWD", "0.0005", 1, false},
+		{"KES", "92233720368547758.07", math.MaxInt64, false},
+		{"KES", "92233720368547758.074", math.MaxInt64, false},
+		{"KES", "92233720368547758.075", 0, true},
+		{"JPY", "9223372036854775807.5", 0, true},
+		{"KES", "abc", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.currency+" "+tt.in, func(t *testing.T) {
+			c, _ := LookupCurrency(tt.currency)
+			got, err := c.Round(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCurrencyFromFloat(t *testing.T) {
+	tests := []struct {
+		currency string
+		in       float64
+		want     Money
+	}{
+		{"KES", 10.5, 1050},
+		{"KES", 1.005, 101},
+		{"KES", 0.1 + 0.2, 30},
+		{"KES", -2.675, -268},
+		{"JPY", 1049.5, 1050},
+		{"KWD", 1.0005, 1001},
+	}
+	for _, tt := range tests {
+		t.Run(tt.currency, func(t *testing.T) {
+			c, _ := LookupCurrency(tt.currency)
+			got, err := c.FromFloat(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("FromFloat(%v) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCurrencyFormat(t *testing.T) {
+	tests := []struct {
+		currency string
+		in       Money
+		want     string
+	}{
+		{"KES", 1050, "10.50"},
+		{"KES", 5, "0.05"},
+		{"KES", 0, "0.00"},
+		{"KES", -5, "-0.05"},
+		{"KES", -1050, "-10.50"},
+		{"KES", math.MaxInt64, "92233720368547758.07"},
+		{"KES", math.MinInt64, "-92233720368547758.08"},
+		{"JPY", 1050, "1050"},
+		{"JPY", -7, "-7"},
+		{"JPY", 0, "0"},
+		{"KWD", 1, "0.001"},
+		{"KWD", 1234, "1.234"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.currency+" "+tt.want, func(t *testing.T) {
+			c, _ := LookupCurrency(tt.currency)
+			if got := c.Format(tt.in); got != tt.want {
+				t.Errorf("Format(%d) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoneyRoundTrip(t *testing.T) {
+	amounts := []Money{0, 1, -1, 9, 10, 99, 100, 1050, -1050, 123456789, math.MaxInt64, math.MinInt64 + 1}
+	for _, code := range []string{"KES", "JPY", "KWD"} {
+		c, _ := LookupCurrency(code)
+		for _, m := range amounts {
+			s := c.Format(m)
+			got, err := c.Parse(s)
+			if err != nil || got != m {
+				t.Errorf("%s: Parse(Format(%d)) = %d, %v via %q", code, m, got, err, s)
+			}
+		}
+	}
+}
+
+func TestTransactionAmountJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    Money
+		wantOut string
+		wantErr bool
+	}{
+		{"string in KES", `{"amount":"10.50","currency":"KES"}`, 1050, "10.50", false},
+		{"default currency", `{"amount":"10.50"}`, 1050, "10.50", false},
+		{"string in JPY", `{"amount":"1050","currency":"JPY"}`, 1050, "1050", false},
+		{"float rounds", `{"amount":10.505,"currency":"KES"}`, 1051, "10.51", false},
+		{"float in JPY", `{"amount":1050.4,"currency":"JPY"}`, 1050, "1050", false},
+		{"too many decimals", `{"amount":"10.505","currency":"KES"}`, 0, "", true},
+		{"decimals in JPY", `{"amount":"10.5","currency":"JPY"}`, 0, "", true},
+		{"unknown currency", `{"amount":"10.50","currency":"XXX"}`, 0, "", true},
+		{"absent", `{"currency":"KES"}`, 0, "0.00", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tr Transactions
+			err := json.Unmarshal([]byte(tt.body), &tr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if _, ok := err.(*ValidationError); !ok {
+					t.Errorf("got %T, want a *ValidationError", err)
+				}
+				return
+			}
+			if tr.Amount != tt.want {
+				t.Errorf("got %d, want %d", tr.Amount, tt.want)
+			}
+			out, err := json.Marshal(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var back struct {
+				Amount string `json:"amount"`
+			}
+			if err := json.Unmarshal(out, &back); err != nil {
+				t.Fatal(err)
+			}
+			if back.Amount != tt.wantOut {
+				t.Errorf("marshalled amount %q, want %q", back.Amount, tt.wantOut)
+			}
+		})
+	}
+}
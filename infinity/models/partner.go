@@ -5,11 +5,15 @@ import(
 )
 
 type Partner struct {
-	ID             int       `json:"id"`
-	Name           string    `json:"name"`
-	Email          string    `json:"email"`
-	PhoneNumber    string    `json:"phone_number"`
-	BillingAddress string    `json:"billing_address"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
+	ID             int           `json:"id"`
+	Name           string        `json:"name" validate:"required"`
+	Email          string        `json:"email" validate:"required,email"`
+	PhoneNumber    string        `json:"phone_number"`
+	BillingAddress string        `json:"billing_address"`
+	RetrySchedule  RetrySchedule `json:"retry_schedule"`
+	SuspendAfter   int           `json:"suspend_after" validate:"positive"`
+	Version        int           `json:"-"`
+	DeletedAt      *time.Time    `json:"deleted_at,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
 }
\ No newline at end of file
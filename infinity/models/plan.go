@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+)
+
+// Plan is a product in a partner's catalog. A subscription sold under a plan
+// copies its price, currency and cycle when it is created, so changing the plan
+// later never reprices the subscriptions already sold
+type Plan struct {
+	ID           int        `json:"id"`
+	PartnerID    int        `json:"partner_id"`
+	Name         string     `json:"name" validate:"required"`
+	Description  string     `json:"description"`
+	Price        Money      `json:"price" validate:"required,positive"`
+	Currency     string     `json:"currency" validate:"required,currency"`
+	BillingCycle string     `json:"billing_cycle" validate:"required,oneof=daily weekly monthly yearly"`
+	TrialDays    int        `json:"trial_days" validate:"positive"`
+	Version      int        `json:"-"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// ApplyPlan snapshots plan onto a new subscription: its price, currency and cycle,
+// and the end of its trial, counted in days from the start date. Values the
+// request sent itself must agree with the plan
+func ApplyPlan(sub *Subscriptions, plan Plan) error {
+	var errs []FieldError
+	mismatch := func(field string) {
+		errs = append(errs, FieldError{Field: field, Rule: "plan", Message: "must match the plan's " + field + " or be left out"})
+	}
+	if sub.BillingAmount != 0 && sub.BillingAmount != plan.Price {
+		mismatch("billing_amount")
+	}
+	if sub.Currency != "" && sub.Currency != plan.Currency {
+		mismatch("currency")
+	}
+	if sub.BillingCycle != "" && sub.BillingCycle != plan.BillingCycle {
+		mismatch("billing_cycle")
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+
+	sub.PlanID = &plan.ID
+	sub.BillingAmount, sub.Currency, sub.BillingCycle = plan.Price, plan.Currency, plan.BillingCycle
+	sub.TrialEndsAt = nil
+	if plan.TrialDays > 0 {
+		end := sub.StartDate.AddDate(0, 0, plan.TrialDays)
+		sub.TrialEndsAt = &end
+	}
+	return nil
+}
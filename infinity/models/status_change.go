@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// SubscriptionStatusChange is one entry of a subscription's status history.
+// Actor is the subject of the token that made the change, such as "partner:7"
+type SubscriptionStatusChange struct {
+	ID             int       `json:"id"`
+	SubscriptionID int       `json:"subscription_id"`
+	FromStatus     string    `json:"from_status"`
+	ToStatus       string    `json:"to_status"`
+	Reason         string    `json:"reason"`
+	Actor          string    `json:"actor"`
+	CreatedAt      time.Time `json:"created_at"`
+}
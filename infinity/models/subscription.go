@@ -5,15 +5,26 @@ import(
 )
 
 type Subscriptions struct {
-	ID               int       `json:"id"`
-	PartnerID        int       `json:"partner_id"`
-	CustomerMSISDN   string    `json:"customer_msisdn"`
-	SubscriptionDate time.Time `json:"subscription_date"`
-	Status           string    `json:"status"`
-	BillingAmount    float64   `json:"billing_amount"`
-	BillingCycle     string    `json:"billing_cycle"`
-	StartDate        time.Time `json:"start_date"`
-	EndDate          time.Time `json:"end_date"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	ID               int        `json:"id"`
+	PartnerID        int        `json:"partner_id" validate:"required"`
+	PlanID           *int       `json:"plan_id"`
+	CustomerMSISDN   string     `json:"customer_msisdn" validate:"required,e164"`
+	SubscriptionDate time.Time  `json:"subscription_date" validate:"required"`
+	Status           string     `json:"status" validate:"required,oneof=pending active suspended cancelled expired"`
+	BillingAmount    Money      `json:"billing_amount" validate:"required,positive"`
+	Currency         string     `json:"currency" validate:"required,currency"`
+	BillingCycle     string     `json:"billing_cycle" validate:"required,oneof=daily weekly monthly yearly"`
+	StartDate        time.Time  `json:"start_date" validate:"required"`
+	EndDate          time.Time  `json:"end_date" validate:"required,after=StartDate"`
+	AutoRenew        bool       `json:"auto_renew"`
+	TrialEndsAt      *time.Time `json:"trial_ends_at"`
+	NextBillingDate  time.Time  `json:"next_billing_date"`
+	DunningState     string     `json:"dunning_state"`
+	FailedAttempts   int        `json:"failed_attempts"`
+	NextAttemptAt    *time.Time `json:"next_attempt_at"`
+	DunningPeriod    *time.Time `json:"dunning_period"`
+	Version          int        `json:"-"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
 }
\ No newline at end of file
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// TransactionEvent is one step of a transaction's status timeline. The first
+// event of every transaction has an empty FromStatus and records its creation
+type TransactionEvent struct {
+	ID            int       `json:"id"`
+	TransactionID int       `json:"transaction_id"`
+	FromStatus    string    `json:"from_status"`
+	ToStatus      string    `json:"to_status"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -6,12 +6,16 @@ import(
 
 
 type Transactions struct {
-	ID              int       `json:"id"`
-	SubscriptionID  int       `json:"subscription_id"`
-	TransactionDate time.Time `json:"transaction_date"`
-	Amount          float64   `json:"amount"`
-	Status          string    `json:"status"`
-	CreatedAt       time.Time `json:"created_at"`
-	UpdatedAt       time.Time `json:"updated_at"`
+	ID              int        `json:"id"`
+	SubscriptionID  int        `json:"subscription_id" validate:"required"`
+	TransactionDate time.Time  `json:"transaction_date"`
+	Amount          Money      `json:"amount" validate:"required,positive"`
+	Currency        string     `json:"currency" validate:"required,currency"`
+	Status          string     `json:"status" validate:"required,oneof=pending succeeded failed refunded partially_refunded reversed"`
+	BillingPeriod   *time.Time `json:"billing_period,omitempty"`
+	Attempt         int        `json:"attempt,omitempty"`
+	Version         int        `json:"-"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
 }
  
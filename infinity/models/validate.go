@@ -0,0 +1,148 @@
+package models
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Models declare their rules in a `validate` struct tag, a comma separated list of:
+//
+//	required     the field must not be the zero value
+//	email        a bare RFC 5322 address such as billing@example.com
+//	e164         an E.164 phone number such as +254712345678
+//	oneof=a b c  one of the space separated values
+//	positive     a number greater than zero
+//	currency     an ISO 4217 code the API accepts, such as KES
+//	after=Field  a time later than the named field of the same struct
+//
+// Every rule but required is skipped for a zero value, so optional fields
+// are only checked when they are set
+
+// FieldError describes why one field of a payload was rejected.
+// Field is the JSON name of the field and Rule the rule it broke
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invalid field of a payload
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "invalid " + strings.Join(msgs, "; ")
+}
+
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+const currencyMessage = "must be a supported ISO 4217 currency code such as KES"
+
+// Validate checks a model, or a pointer to one, against its validate tags.
+// It returns a *ValidationError listing every failing field, or nil
+func Validate(v any) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	rt := rv.Type()
+
+	var errs []FieldError
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		tag := sf.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		field := rv.Field(i)
+		name := jsonName(sf)
+
+		for _, rule := range strings.Split(tag, ",") {
+			rule, arg, _ := strings.Cut(rule, "=")
+			if rule != "required" && field.IsZero() {
+				continue
+			}
+			if msg := check(rule, arg, field, rv); msg != "" {
+				errs = append(errs, FieldError{Field: name, Rule: rule, Message: msg})
+				// report the first broken rule per field
+				break
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+// check applies one rule and returns a message when the value breaks it
+func check(rule, arg string, field, parent reflect.Value) string {
+	switch rule {
+	case "required":
+		if field.IsZero() {
+			return "is required"
+		}
+	case "email":
+		s := field.String()
+		if addr, err := mail.ParseAddress(s); err != nil || addr.Address != s {
+			return "must be a valid email address"
+		}
+	case "e164":
+		if !e164Pattern.MatchString(field.String()) {
+			return "must be an E.164 phone number such as +254712345678"
+		}
+	case "oneof":
+		for _, allowed := range strings.Fields(arg) {
+			if field.String() == allowed {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(strings.Fields(arg), ", ")
+	case "positive":
+		if !positive(field) {
+			return "must be greater than zero"
+		}
+	case "currency":
+		if _, ok := LookupCurrency(field.String()); !ok {
+			return currencyMessage
+		}
+	case "after":
+		sf, ok := parent.Type().FieldByName(arg)
+		if !ok {
+			panic(fmt.Sprintf("models: after=%s names no field of %s", arg, parent.Type()))
+		}
+		t, _ := field.Interface().(time.Time)
+		if o, _ := parent.FieldByIndex(sf.Index).Interface().(time.Time); !o.IsZero() && !t.After(o) {
+			return "must be after " + jsonName(sf)
+		}
+	default:
+		panic(fmt.Sprintf("models: unknown validation rule %q", rule))
+	}
+	return ""
+}
+
+func positive(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() > 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() > 0
+	}
+	return false
+}
+
+// jsonName is the name a field has in request bodies
+func jsonName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" {
+		return sf.Name
+	}
+	return name
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// ruled has one field per rule, so each case breaks at most one of them
+type ruled struct {
+	Required string    `json:"required" validate:"required"`
+	Email    string    `json:"email" validate:"email"`
+	Phone    string    `json:"phone" validate:"e164"`
+	Cycle    string    `json:"cycle" validate:"oneof=daily monthly"`
+	Count    int       `json:"count" validate:"positive"`
+	Amount   Money     `json:"amount" validate:"positive"`
+	Rate     float64   `json:"rate" validate:"positive"`
+	Currency string    `json:"currency" validate:"currency"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end" validate:"after=Start"`
+}
+
+func TestValidateRules(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		set   func(*ruled)
+		field string // the field reported, empty when the value passes
+		rule  string
+	}{
+		{"required set", func(r *ruled) { r.Required = "x" }, "", ""},
+		{"required missing", func(r *ruled) { r.Required = "" }, "required", "required"},
+
+		{"email", func(r *ruled) { r.Email = "billing@example.com" }, "", ""},
+		{"email without a domain", func(r *ruled) { r.Email = "billing" }, "email", "email"},
+		{"email with a display name", func(r *ruled) { r.Email = "Billing <billing@example.com>" }, "email", "email"},
+		{"email with spaces", func(r *ruled) { r.Email = " billing@example.com" }, "email", "email"},
+
+		{"e164", func(r *ruled) { r.Phone = "+254712345678" }, "", ""},
+		{"e164 shortest", func(r *ruled) { r.Phone = "+1234567" }, "", ""},
+		{"e164 without a plus", func(r *ruled) { r.Phone = "254712345678" }, "phone", "e164"},
+		{"e164 local", func(r *ruled) { r.Phone = "0712345678" }, "phone", "e164"},
+		{"e164 with a leading zero", func(r *ruled) { r.Phone = "+0712345678" }, "phone", "e164"},
+		{"e164 too long", func(r *ruled) { r.Phone = "+1234567890123456" }, "phone", "e164"},
+		{"e164 with spaces", func(r *ruled) { r.Phone = "+254 712 345678" }, "phone", "e164"},
+
+		{"oneof", func(r *ruled) { r.Cycle = "monthly" }, "", ""},
+		{"oneof other value", func(r *ruled) { r.Cycle = "weekly" }, "cycle", "oneof"},
+		{"oneof wrong case", func(r *ruled) { r.Cycle = "Monthly" }, "cycle", "oneof"},
+
+		{"positive int", func(r *ruled) { r.Count = 1 }, "", ""},
+		{"positive int negative", func(r *ruled) { r.Count = -1 }, "count", "positive"},
+		{"positive money", func(r *ruled) { r.Amount = 1 }, "", ""},
+		{"positive money negative", func(r *ruled) { r.Amount = -1050 }, "amount", "positive"},
+		{"positive float", func(r *ruled) { r.Rate = 0.5 }, "", ""},
+		{"positive float negative", func(r *ruled) { r.Rate = -0.5 }, "rate", "positive"},
+
+		{"currency", func(r *ruled) { r.Currency = "KES" }, "", ""},
+		{"currency unsupported", func(r *ruled) { r.Currency = "XYZ" }, "currency", "currency"},
+		{"currency lower case", func(r *ruled) { r.Currency = "kes" }, "currency", "currency"},
+
+		{"after", func(r *ruled) { r.Start, r.End = start, start.Add(time.Second) }, "", ""},
+		{"after with no start", func(r *ruled) { r.End = start }, "", ""},
+		{"after equal", func(r *ruled) { r.Start, r.End = start, start }, "end", "after"},
+		{"after before", func(r *ruled) { r.Start, r.End = start, start.AddDate(0, 0, -1) }, "end", "after"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ruled{Required: "x"}
+			tt.set(&r)
+			err := Validate(&r)
+			if tt.field == "" {
+				if err != nil {
+					t.Errorf("got %v, want it valid", err)
+				}
+				return
+			}
+			var ve *ValidationError
+			if !errors.As(err, &ve) {
+				t.Fatalf("got %v, want a *ValidationError", err)
+			}
+			if len(ve.Fields) != 1 || ve.Fields[0].Field != tt.field || ve.Fields[0].Rule != tt.rule || ve.Fields[0].Message == "" {
+				t.Errorf("got %+v, want %s to break %s", ve.Fields, tt.field, tt.rule)
+			}
+		})
+	}
+}
+
+func TestValidateSkipsZeroValues(t *testing.T) {
+	// only required looks at an unset field
+	if err := Validate(ruled{Required: "x"}); err != nil {
+		t.Errorf("got %v for a payload with only the required field set", err)
+	}
+}
+
+func TestValidateListsEveryField(t *testing.T) {
+	err := Validate(&Subscriptions{
+		CustomerMSISDN: "0712345678",
+		Status:         "paused",
+		BillingAmount:  -1,
+		Currency:       "KES",
+		BillingCycle:   "monthly",
+		StartDate:      time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:        time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("got %v, want a *ValidationError", err)
+	}
+	want := []FieldError{
+		{Field: "partner_id", Rule: "required"},
+		{Field: "customer_msisdn", Rule: "e164"},
+		{Field: "subscription_date", Rule: "required"},
+		{Field: "status", Rule: "oneof"},
+		{Field: "billing_amount", Rule: "positive"},
+		{Field: "end_date", Rule: "after"},
+	}
+	if len(ve.Fields) != len(want) {
+		t.Fatalf("got %+v, want %+v", ve.Fields, want)
+	}
+	for i, w := range want {
+		if ve.Fields[i].Field != w.Field || ve.Fields[i].Rule != w.Rule {
+			t.Errorf("field %d: got %s breaking %s, want %s breaking %s", i, ve.Fields[i].Field, ve.Fields[i].Rule, w.Field, w.Rule)
+		}
+	}
+}
+
+func TestValidateUnknownRulePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("an unknown rule didn't panic")
+		}
+	}()
+	Validate(struct {
+		Name string `validate:"shiny"`
+	}{"x"})
+}
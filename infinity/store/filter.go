@@ -0,0 +1,125 @@
+package store
+
+import (
+	"infinity/models"
+	"time"
+)
+
+// where renders the filter as SQL conditions on the partners table
+func (f PartnerFilter) where() *where {
+	w := &where{}
+	if !f.IncludeDeleted {
+		w.addRaw("deleted_at IS NULL")
+	}
+	return w
+}
+
+// matches applies the filter to one row the way where does in SQL
+func (f PartnerFilter) matches(p models.Partner) bool {
+	return f.IncludeDeleted || p.DeletedAt == nil
+}
+
+// where renders the filter as SQL conditions on the subscriptions table
+func (f SubscriptionFilter) where() *where {
+	w := &where{}
+	if !f.IncludeDeleted {
+		w.addRaw("deleted_at IS NULL")
+	}
+	if f.PartnerID != 0 {
+		w.add("partner_id = ?", f.PartnerID)
+	}
+	if f.Status != "" {
+		w.add("status = ?", f.Status)
+	}
+	if f.BillingCycle != "" {
+		w.add("billing_cycle = ?", f.BillingCycle)
+	}
+	if f.CustomerMSISDN != "" {
+		w.add("customer_msisdn = ?", f.CustomerMSISDN)
+	}
+	addRange(w, "start_date", f.StartFrom, f.StartTo)
+	addRange(w, "end_date", f.EndFrom, f.EndTo)
+	if !f.DueBy.IsZero() {
+		// each side of the OR is served by its own partial index
+		w.add("((status = ? AND next_billing_date <= ?) OR (status IN (?, ?) AND dunning_state = ? AND next_attempt_at <= ? AND dunning_period IS NOT NULL))",
+			models.SubscriptionActive, f.DueBy, models.SubscriptionActive, models.SubscriptionSuspended, models.DunningRetrying, f.DueBy)
+	}
+	if !f.EndedBy.IsZero() {
+		// cancelled and expired subscriptions have nothing left to end
+		w.add("end_date <= ?", f.EndedBy)
+		w.add("status NOT IN (?, ?)", models.SubscriptionCancelled, models.SubscriptionExpired)
+	}
+	return w
+}
+
+// matches applies the filter to one row the way where does in SQL
+func (f SubscriptionFilter) matches(s models.Subscriptions) bool {
+	return (f.IncludeDeleted || s.DeletedAt == nil) &&
+		(f.PartnerID == 0 || s.PartnerID == f.PartnerID) &&
+		(f.Status == "" || s.Status == f.Status) &&
+		(f.BillingCycle == "" || s.BillingCycle == f.BillingCycle) &&
+		(f.CustomerMSISDN == "" || s.CustomerMSISDN == f.CustomerMSISDN) &&
+		inRange(s.StartDate, f.StartFrom, f.StartTo) &&
+		inRange(s.EndDate, f.EndFrom, f.EndTo) &&
+		(f.DueBy.IsZero() || dueBy(s, f.DueBy)) &&
+		(f.EndedBy.IsZero() || (!s.EndDate.After(f.EndedBy) && s.Status != models.SubscriptionCancelled && s.Status != models.SubscriptionExpired))
+}
+
+// dueBy reports whether a billing run at at has work for s, the way the DueBy condition does in SQL
+func dueBy(s models.Subscriptions, at time.Time) bool {
+	if s.Status == models.SubscriptionActive && !s.NextBillingDate.After(at) {
+		return true
+	}
+	return (s.Status == models.SubscriptionActive || s.Status == models.SubscriptionSuspended) &&
+		s.DunningState == models.DunningRetrying && s.NextAttemptAt != nil && !s.NextAttemptAt.After(at) && s.DunningPeriod != nil
+}
+
+// where renders the filter as SQL conditions on the transactions table
+func (f TransactionFilter) where() *where {
+	w := &where{}
+	if f.PartnerID != 0 {
+		w.add("subscription_id IN (SELECT id FROM subscriptions WHERE partner_id = ?)", f.PartnerID)
+	}
+	if f.SubscriptionID != 0 {
+		w.add("subscription_id = ?", f.SubscriptionID)
+	}
+	if f.Status != "" {
+		w.add("status = ?", f.Status)
+	}
+	if f.Currency != "" {
+		w.add("currency = ?", f.Currency)
+	}
+	if f.AmountMin != nil {
+		w.add("amount >= ?", *f.AmountMin)
+	}
+	if f.AmountMax != nil {
+		w.add("amount <= ?", *f.AmountMax)
+	}
+	addRange(w, "transaction_date", f.DateFrom, f.DateTo)
+	return w
+}
+
+// matches applies the filter to one row; partnerOf resolves a subscription's partner
+func (f TransactionFilter) matches(t models.Transactions, partnerOf func(subscriptionID int) int) bool {
+	return (f.PartnerID == 0 || partnerOf(t.SubscriptionID) == f.PartnerID) &&
+		(f.SubscriptionID == 0 || t.SubscriptionID == f.SubscriptionID) &&
+		(f.Status == "" || t.Status == f.Status) &&
+		(f.Currency == "" || t.Currency == f.Currency) &&
+		(f.AmountMin == nil || t.Amount >= *f.AmountMin) &&
+		(f.AmountMax == nil || t.Amount <= *f.AmountMax) &&
+		inRange(t.TransactionDate, f.DateFrom, f.DateTo)
+}
+
+// addRange adds a half-open [from, to) condition on column; zero bounds are left open
+func addRange(w *where, column string, from, to time.Time) {
+	if !from.IsZero() {
+		w.add(column+" >= ?", from)
+	}
+	if !to.IsZero() {
+		w.add(column+" < ?", to)
+	}
+}
+
+func inRange(t, from, to time.Time) bool {
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || t.Before(to))
+}
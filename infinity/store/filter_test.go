@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"infinity/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPartnerFilter(t *testing.T) {
+	eachStore(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		a, b, gone := createPartner(t, stores, "a"), createPartner(t, stores, "b"), createPartner(t, stores, "c")
+		if err := stores.Partners.Delete(ctx, gone.ID, 0, false); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name   string
+			filter PartnerFilter
+			want   []int
+		}{
+			{"live", PartnerFilter{}, []int{a.ID, b.ID}},
+			{"including deleted", PartnerFilter{IncludeDeleted: true}, []int{a.ID, b.ID, gone.ID}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				partners, _, err := stores.Partners.List(ctx, tt.filter, Page{Limit: 100})
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []int
+				for _, p := range partners {
+					got = append(got, p.ID)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			})
+		}
+	})
+}
+
+func TestSubscriptionFilter(t *testing.T) {
+	eachStore(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		a, b := createPartner(t, stores, "a"), createPartner(t, stores, "b")
+		s1 := createSubscription(t, stores, a.ID)
+		s2 := createSubscription(t, stores, a.ID, func(s *models.Subscriptions) {
+			s.Status, s.BillingCycle, s.CustomerMSISDN = models.SubscriptionPending, "weekly", "+254700000002"
+			s.StartDate, s.EndDate = day(2026, 2, 1), day(2026, 6, 1)
+		})
+		s3 := createSubscription(t, stores, b.ID, func(s *models.Subscriptions) {
+			s.BillingCycle, s.StartDate, s.EndDate = "yearly", day(2026, 3, 1), day(2026, 4, 1)
+		})
+		change := &models.SubscriptionStatusChange{FromStatus: models.SubscriptionActive, ToStatus: models.SubscriptionCancelled, Actor: "test"}
+		if _, err := stores.Subscriptions.Transition(ctx, s3.ID, 0, change); err != nil {
+			t.Fatal(err)
+		}
+		s4 := createSubscription(t, stores, b.ID)
+		if err := stores.Subscriptions.Delete(ctx, s4.ID, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name   string
+			filter SubscriptionFilter
+			want   []int
+		}{
+			{"live", SubscriptionFilter{}, []int{s1.ID, s2.ID, s3.ID}},
+			{"including deleted", SubscriptionFilter{IncludeDeleted: true}, []int{s1.ID, s2.ID, s3.ID, s4.ID}},
+			{"partner", SubscriptionFilter{PartnerID: a.ID}, []int{s1.ID, s2.ID}},
+			{"partner including deleted", SubscriptionFilter{PartnerID: b.ID, IncludeDeleted: true}, []int{s3.ID, s4.ID}},
+			{"unknown partner", SubscriptionFilter{PartnerID: 1000}, nil},
+			{"pending", SubscriptionFilter{Status: models.SubscriptionPending}, []int{s2.ID}},
+			{"cancelled", SubscriptionFilter{Status: models.SubscriptionCancelled}, []int{s3.ID}},
+			{"billing cycle", SubscriptionFilter{BillingCycle: "weekly"}, []int{s2.ID}},
+			{"customer", SubscriptionFilter{CustomerMSISDN: "+254700000001"}, []int{s1.ID, s3.ID}},
+			{"started from", SubscriptionFilter{StartFrom: day(2026, 2, 1)}, []int{s2.ID, s3.ID}},
+			{"started before", SubscriptionFilter{StartTo: day(2026, 2, 1)}, []int{s1.ID}},
+			{"started within", SubscriptionFilter{StartFrom: day(2026, 2, 1), StartTo: day(2026, 3, 1)}, []int{s2.ID}},
+			{"ending from", SubscriptionFilter{EndFrom: day(2026, 6, 1)}, []int{s1.ID, s2.ID}},
+			{"ending before", SubscriptionFilter{EndTo: day(2026, 6, 1)}, []int{s3.ID}},
+			{"ending within", SubscriptionFilter{EndFrom: day(2026, 4, 1), EndTo: day(2026, 6, 2)}, []int{s2.ID, s3.ID}},
+			{"due", SubscriptionFilter{DueBy: day(2026, 3, 1)}, []int{s1.ID}},
+			{"due before anything starts", SubscriptionFilter{DueBy: day(2025, 12, 31)}, nil},
+			{"ended", SubscriptionFilter{EndedBy: day(2026, 6, 1)}, []int{s2.ID}},
+			{"ended before any end date", SubscriptionFilter{EndedBy: day(2026, 5, 31)}, nil},
+			{"combined", SubscriptionFilter{PartnerID: a.ID, Status: models.SubscriptionActive, CustomerMSISDN: "+254700000001"}, []int{s1.ID}},
+			{"combined without a match", SubscriptionFilter{PartnerID: b.ID, BillingCycle: "weekly"}, nil},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				subs, _, err := stores.Subscriptions.List(ctx, tt.filter, Page{Limit: 100})
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []int
+				for _, s := range subs {
+					got = append(got, s.ID)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			})
+		}
+	})
+}
+
+func TestTransactionFilter(t *testing.T) {
+	eachStore(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		a, b := createPartner(t, stores, "a"), createPartner(t, stores, "b")
+		// a transaction is in its subscription's currency
+		usd := func(s *models.Subscriptions) { s.Currency = "USD" }
+		s1, s2 := createSubscription(t, stores, a.ID), createSubscription(t, stores, a.ID, usd)
+		s3 := createSubscription(t, stores, b.ID, usd)
+		create := func(subscriptionID int, date time.Time, amount models.Money, currency, status string) int {
+			t.Helper()
+			tr := models.Transactions{SubscriptionID: subscriptionID, TransactionDate: date, Amount: amount, Currency: currency, Status: status}
+			if err := stores.Transactions.Create(ctx, &tr); err != nil {
+				t.Fatal(err)
+			}
+			return tr.ID
+		}
+		t1 := create(s1.ID, day(2026, 1, 1), 1000, "KES", models.TransactionPending)
+		t2 := create(s1.ID, day(2026, 2, 1), 2500, "KES", models.TransactionSucceeded)
+		t3 := create(s3.ID, day(2026, 3, 1), 500, "USD", models.TransactionFailed)
+		t4 := create(s2.ID, day(2026, 3, 15), 1000, "USD", models.TransactionSucceeded)
+		money := func(m models.Money) *models.Money { return &m }
+
+		tests := []struct {
+			name   string
+			filter TransactionFilter
+			want   []int
+		}{
+			{"all", TransactionFilter{}, []int{t1, t2, t3, t4}},
+			{"partner", TransactionFilter{PartnerID: a.ID}, []int{t1, t2, t4}},
+			{"other partner", TransactionFilter{PartnerID: b.ID}, []int{t3}},
+			{"subscription", TransactionFilter{SubscriptionID: s1.ID}, []int{t1, t2}},
+			{"subscription of another partner", TransactionFilter{PartnerID: b.ID, SubscriptionID: s1.ID}, nil},
+			{"status", TransactionFilter{Status: models.TransactionSucceeded}, []int{t2, t4}},
+			{"currency", TransactionFilter{Currency: "USD"}, []int{t3, t4}},
+			{"amount from", TransactionFilter{AmountMin: money(1000)}, []int{t1, t2, t4}},
+			{"amount up to", TransactionFilter{AmountMax: money(1000)}, []int{t1, t3, t4}},
+			{"exact amount", TransactionFilter{AmountMin: money(1000), AmountMax: money(1000)}, []int{t1, t4}},
+			{"amount in a currency", TransactionFilter{Currency: "KES", AmountMin: money(2000)}, []int{t2}},
+			{"dated from", TransactionFilter{DateFrom: day(2026, 2, 1)}, []int{t2, t3, t4}},
+			{"dated before", TransactionFilter{DateTo: day(2026, 3, 1)}, []int{t1, t2}},
+			{"dated within", TransactionFilter{DateFrom: day(2026, 2, 1), DateTo: day(2026, 3, 15)}, []int{t2, t3}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				transactions, _, err := stores.Transactions.List(ctx, tt.filter, Page{Limit: 100})
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []int
+				for _, tr := range transactions {
+					got = append(got, tr.ID)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			})
+		}
+	})
+}
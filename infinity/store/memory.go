@@ -0,0 +1,1027 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"infinity/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryDB holds the tables shared by the in-memory stores.
+// A single lock guards every table so cross-table reads stay consistent
+type memoryDB struct {
+	mu              sync.RWMutex
+	partners        map[int]models.Partner
+	plans           map[int]models.Plan
+	subscriptions   map[int]models.Subscriptions
+	transactions    map[int]models.Transactions
+	apiKeys         map[int]models.APIKey
+	idempotencyKeys map[string]models.IdempotencyKey
+	statusHistory   []models.SubscriptionStatusChange
+	events          []models.TransactionEvent
+	lastID          map[string]int
+}
+
+func newMemoryDB() *memoryDB {
+	return &memoryDB{
+		partners:        make(map[int]models.Partner),
+		plans:           make(map[int]models.Plan),
+		subscriptions:   make(map[int]models.Subscriptions),
+		transactions:    make(map[int]models.Transactions),
+		apiKeys:         make(map[int]models.APIKey),
+		idempotencyKeys: make(map[string]models.IdempotencyKey),
+		lastID:          make(map[string]int),
+	}
+}
+
+// nextID hands out serial IDs per table; callers must hold the write lock
+func (m *memoryDB) nextID(table string) int {
+	m.lastID[table]++
+	return m.lastID[table]
+}
+
+// emailTaken reports whether a partner other than except uses email, mirroring the
+// UNIQUE constraint on partners.email; callers must hold the lock
+func (m *memoryDB) emailTaken(email string, except int) bool {
+	for _, p := range m.partners {
+		if p.ID != except && p.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// livePartner reports whether the partner exists and isn't soft-deleted; callers must hold the lock
+func (m *memoryDB) livePartner(id int) bool {
+	p, ok := m.partners[id]
+	return ok && p.DeletedAt == nil
+}
+
+// livePlan reports whether the plan exists and isn't soft-deleted; callers must hold the lock
+func (m *memoryDB) livePlan(id int) bool {
+	p, ok := m.plans[id]
+	return ok && p.DeletedAt == nil
+}
+
+// planNameTaken reports whether a live plan of the partner other than except is
+// called name, mirroring the partial unique index on plans; callers must hold the lock
+func (m *memoryDB) planNameTaken(partnerID int, name string, except int) bool {
+	for _, p := range m.plans {
+		if p.ID != except && p.PartnerID == partnerID && p.Name == name && p.DeletedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// liveSubscription reports whether the subscription exists and isn't soft-deleted;
+// callers must hold the lock
+func (m *memoryDB) liveSubscription(id int) bool {
+	sub, ok := m.subscriptions[id]
+	return ok && sub.DeletedAt == nil
+}
+
+// lastBilledPeriod is the latest billing period charged to the subscription, if any
+func (m *memoryDB) lastBilledPeriod(id int) *time.Time {
+	var last *time.Time
+	for _, t := range m.transactions {
+		if t.SubscriptionID == id && t.BillingPeriod != nil && (last == nil || t.BillingPeriod.After(*last)) {
+			last = t.BillingPeriod
+		}
+	}
+	return last
+}
+
+// recordStatusChange appends change to the status history; callers must hold the write lock
+func (m *memoryDB) recordStatusChange(change *models.SubscriptionStatusChange) {
+	change.ID = m.nextID("subscription_status_history")
+	m.statusHistory = append(m.statusHistory, *change)
+}
+
+// settleCharge applies the outcome of a billing charge that just left pending to
+// its subscription's dunning state; callers must hold the write lock
+func (m *memoryDB) settleCharge(from string, t models.Transactions) {
+	if t.BillingPeriod == nil || from != models.TransactionPending || t.Status == models.TransactionPending {
+		return
+	}
+	sub := m.subscriptions[t.SubscriptionID]
+	p := m.partners[sub.PartnerID]
+	change := models.ChargeSettled(&sub, t, p.RetrySchedule, p.SuspendAfter, t.UpdatedAt)
+	sub.Version++
+	sub.UpdatedAt = t.UpdatedAt
+	m.subscriptions[sub.ID] = sub
+	if change != nil {
+		change.CreatedAt = t.UpdatedAt
+		m.recordStatusChange(change)
+	}
+}
+
+// recordEvent logs the move of t from status from, if its status moved at all;
+// callers must hold the write lock
+func (m *memoryDB) recordEvent(from string, t models.Transactions) {
+	if from == t.Status {
+		return
+	}
+	m.events = append(m.events, models.TransactionEvent{
+		ID:            m.nextID("transaction_events"),
+		TransactionID: t.ID,
+		FromStatus:    from,
+		ToStatus:      t.Status,
+		CreatedAt:     t.UpdatedAt,
+	})
+}
+
+// checkVersion enforces a version pinned by a write; zero pins nothing
+func checkVersion(want, have int) error {
+	if want != 0 && want != have {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// sortedValues returns the rows of a table ordered by ID
+func sortedValues[T any](table map[int]T) []T {
+	ids := make([]int, 0, len(table))
+	for id := range table {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	values := make([]T, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, table[id])
+	}
+	return values
+}
+
+// MemoryPartnerStore is a PartnerStore kept in process memory
+type MemoryPartnerStore struct {
+	m *memoryDB
+}
+
+func (s *MemoryPartnerStore) List(ctx context.Context, f PartnerFilter, page Page) ([]models.Partner, *Cursor, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	var partners []models.Partner
+	for _, p := range s.m.partners {
+		if f.matches(p) {
+			partners = append(partners, p)
+		}
+	}
+	return memoryPage(partners, partnerSorts, page, func(p models.Partner) int { return p.ID })
+}
+
+func (s *MemoryPartnerStore) Get(ctx context.Context, id int) (models.Partner, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	p, ok := s.m.partners[id]
+	if !ok || p.DeletedAt != nil {
+		return models.Partner{}, ErrNotFound
+	}
+	return p, nil
+}
+
+func (s *MemoryPartnerStore) GetWithDeleted(ctx context.Context, id int) (models.Partner, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	p, ok := s.m.partners[id]
+	if !ok {
+		return models.Partner{}, ErrNotFound
+	}
+	return p, nil
+}
+
+func (s *MemoryPartnerStore) Create(ctx context.Context, p *models.Partner) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if s.m.emailTaken(p.Email, 0) {
+		return ErrConflict
+	}
+	now := time.Now()
+	p.ID = s.m.nextID("partners")
+	p.Version = 1
+	p.DeletedAt = nil
+	p.CreatedAt, p.UpdatedAt = now, now
+	s.m.partners[p.ID] = *p
+	return nil
+}
+
+func (s *MemoryPartnerStore) Update(ctx context.Context, p *models.Partner) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	existing, ok := s.m.partners[p.ID]
+	if !ok || existing.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if err := checkVersion(p.Version, existing.Version); err != nil {
+		return err
+	}
+	if s.m.emailTaken(p.Email, p.ID) {
+		return ErrConflict
+	}
+	p.Version = existing.Version + 1
+	p.DeletedAt = nil
+	p.CreatedAt = existing.CreatedAt
+	p.UpdatedAt = time.Now()
+	s.m.partners[p.ID] = *p
+	return nil
+}
+
+func (s *MemoryPartnerStore) Patch(ctx context.Context, p *models.Partner, columns []string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	existing, ok := s.m.partners[p.ID]
+	if !ok || existing.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if err := checkVersion(p.Version, existing.Version); err != nil {
+		return err
+	}
+	if err := applyPatch(partnerPatchColumns, &existing, *p, columns); err != nil {
+		return err
+	}
+	if s.m.emailTaken(existing.Email, existing.ID) {
+		return ErrConflict
+	}
+	existing.Version++
+	existing.UpdatedAt = time.Now()
+	s.m.partners[existing.ID] = existing
+	*p = existing
+	return nil
+}
+
+func (s *MemoryPartnerStore) Delete(ctx context.Context, id, version int, cascade bool) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	existing, ok := s.m.partners[id]
+	if !ok || existing.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if err := checkVersion(version, existing.Version); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, sub := range s.m.subscriptions {
+		if sub.PartnerID != id || sub.DeletedAt != nil {
+			continue
+		}
+		if !cascade {
+			if sub.Status != "cancelled" && sub.Status != "expired" {
+				return fmt.Errorf("%w: partner has active subscriptions", ErrConflict)
+			}
+			continue
+		}
+		sub.DeletedAt = &now
+		sub.Version++
+		sub.UpdatedAt = now
+		s.m.subscriptions[sub.ID] = sub
+	}
+
+	existing.DeletedAt = &now
+	existing.Version++
+	existing.UpdatedAt = now
+	s.m.partners[id] = existing
+	return nil
+}
+
+func (s *MemoryPartnerStore) Restore(ctx context.Context, id int) (models.Partner, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	p, ok := s.m.partners[id]
+	if !ok {
+		return models.Partner{}, ErrNotFound
+	}
+	if p.DeletedAt != nil {
+		now := time.Now()
+		// a cascade stamps the subscriptions with the partner's own deletion time
+		for _, sub := range s.m.subscriptions {
+			if sub.PartnerID == id && sub.DeletedAt != nil && sub.DeletedAt.Equal(*p.DeletedAt) {
+				sub.DeletedAt = nil
+				sub.Version++
+				sub.UpdatedAt = now
+				s.m.subscriptions[sub.ID] = sub
+			}
+		}
+		p.DeletedAt = nil
+		p.Version++
+		p.UpdatedAt = now
+		s.m.partners[id] = p
+	}
+	return p, nil
+}
+
+// MemoryPlanStore is a PlanStore kept in process memory
+type MemoryPlanStore struct {
+	m *memoryDB
+}
+
+func (s *MemoryPlanStore) ListByPartner(ctx context.Context, partnerID int) ([]models.Plan, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	var plans []models.Plan
+	for _, p := range sortedValues(s.m.plans) {
+		if p.PartnerID == partnerID && p.DeletedAt == nil {
+			plans = append(plans, p)
+		}
+	}
+	return plans, nil
+}
+
+func (s *MemoryPlanStore) Get(ctx context.Context, id int) (models.Plan, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	p, ok := s.m.plans[id]
+	if !ok || p.DeletedAt != nil {
+		return models.Plan{}, ErrNotFound
+	}
+	return p, nil
+}
+
+func (s *MemoryPlanStore) Create(ctx context.Context, p *models.Plan) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if !s.m.livePartner(p.PartnerID) {
+		return ErrInvalidReference
+	}
+	if s.m.planNameTaken(p.PartnerID, p.Name, 0) {
+		return ErrConflict
+	}
+	now := time.Now()
+	p.ID = s.m.nextID("plans")
+	p.Version = 1
+	p.DeletedAt = nil
+	p.CreatedAt, p.UpdatedAt = now, now
+	s.m.plans[p.ID] = *p
+	return nil
+}
+
+func (s *MemoryPlanStore) Update(ctx context.Context, p *models.Plan) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	existing, ok := s.m.plans[p.ID]
+	if !ok || existing.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if err := checkVersion(p.Version, existing.Version); err != nil {
+		return err
+	}
+	if s.m.planNameTaken(existing.PartnerID, p.Name, p.ID) {
+		return ErrConflict
+	}
+	p.PartnerID = existing.PartnerID
+	p.Version = existing.Version + 1
+	p.DeletedAt = nil
+	p.CreatedAt = existing.CreatedAt
+	p.UpdatedAt = time.Now()
+	s.m.plans[p.ID] = *p
+	return nil
+}
+
+func (s *MemoryPlanStore) Patch(ctx context.Context, p *models.Plan, columns []string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	existing, ok := s.m.plans[p.ID]
+	if !ok || existing.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if err := checkVersion(p.Version, existing.Version); err != nil {
+		return err
+	}
+	if err := applyPatch(planPatchColumns, &existing, *p, columns); err != nil {
+		return err
+	}
+	if s.m.planNameTaken(existing.PartnerID, existing.Name, existing.ID) {
+		return ErrConflict
+	}
+	existing.Version++
+	existing.UpdatedAt = time.Now()
+	s.m.plans[existing.ID] = existing
+	*p = existing
+	return nil
+}
+
+func (s *MemoryPlanStore) Delete(ctx context.Context, id, version int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	existing, ok := s.m.plans[id]
+	if !ok || existing.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if err := checkVersion(version, existing.Version); err != nil {
+		return err
+	}
+	now := time.Now()
+	existing.DeletedAt = &now
+	existing.Version++
+	existing.UpdatedAt = now
+	s.m.plans[id] = existing
+	return nil
+}
+
+// MemorySubscriptionStore is a SubscriptionStore kept in process memory
+type MemorySubscriptionStore struct {
+	m *memoryDB
+}
+
+func (s *MemorySubscriptionStore) List(ctx context.Context, f SubscriptionFilter, page Page) ([]models.Subscriptions, *Cursor, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	var subscriptions []models.Subscriptions
+	for _, sub := range s.m.subscriptions {
+		if f.matches(sub) {
+			subscriptions = append(subscriptions, sub)
+		}
+	}
+	return memoryPage(subscriptions, subscriptionSorts, page, func(s models.Subscriptions) int { return s.ID })
+}
+
+func (s *MemorySubscriptionStore) Get(ctx context.Context, id int) (models.Subscriptions, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	sub, ok := s.m.subscriptions[id]
+	if !ok || sub.DeletedAt != nil {
+		return models.Subscriptions{}, ErrNotFound
+	}
+	return sub, nil
+}
+
+func (s *MemorySubscriptionStore) GetWithDeleted(ctx context.Context, id int) (models.Subscriptions, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	sub, ok := s.m.subscriptions[id]
+	if !ok {
+		return models.Subscriptions{}, ErrNotFound
+	}
+	return sub, nil
+}
+
+func (s *MemorySubscriptionStore) Create(ctx context.Context, sub *models.Subscriptions) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if !s.m.livePartner(sub.PartnerID) || (sub.PlanID != nil && !s.m.livePlan(*sub.PlanID)) {
+		return ErrInvalidReference
+	}
+	now := time.Now()
+	sub.ID = s.m.nextID("subscriptions")
+	sub.NextBillingDate = sub.FirstBillingDate()
+	sub.DunningState, sub.FailedAttempts, sub.NextAttemptAt, sub.DunningPeriod = models.DunningCurrent, 0, nil, nil
+	sub.Version = 1
+	sub.DeletedAt = nil
+	sub.CreatedAt, sub.UpdatedAt = now, now
+	s.m.subscriptions[sub.ID] = *sub
+	return nil
+}
+
+func (s *MemorySubscriptionStore) Update(ctx context.Context, sub *models.Subscriptions) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	existing, ok := s.m.subscriptions[sub.ID]
+	if !ok || existing.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if err := checkVersion(sub.Version, existing.Version); err != nil {
+		return err
+	}
+	if !s.m.livePartner(sub.PartnerID) {
+		return ErrInvalidReference
+	}
+	sub.Status = existing.Status
+	sub.PlanID, sub.TrialEndsAt = existing.PlanID, existing.TrialEndsAt
+	sub.NextBillingDate = models.ScheduledBillingDate(*sub, s.m.lastBilledPeriod(sub.ID))
+	sub.DunningState, sub.FailedAttempts, sub.NextAttemptAt, sub.DunningPeriod = existing.DunningState, existing.FailedAttempts, existing.NextAttemptAt, existing.DunningPeriod
+	sub.Version = existing.Version + 1
+	sub.DeletedAt = nil
+	sub.CreatedAt = existing.CreatedAt
+	sub.UpdatedAt = time.Now()
+	s.m.subscriptions[sub.ID] = *sub
+	return nil
+}
+
+func (s *MemorySubscriptionStore) Patch(ctx context.Context, sub *models.Subscriptions, columns []string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	existing, ok := s.m.subscriptions[sub.ID]
+	if !ok || existing.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if err := checkVersion(sub.Version, existing.Version); err != nil {
+		return err
+	}
+	if err := applyPatch(subscriptionPatchColumns, &existing, *sub, columns); err != nil {
+		return err
+	}
+	if !s.m.livePartner(existing.PartnerID) {
+		return ErrInvalidReference
+	}
+	if reschedulesBilling(columns) {
+		existing.NextBillingDate = models.ScheduledBillingDate(existing, s.m.lastBilledPeriod(existing.ID))
+	}
+	existing.Version++
+	existing.UpdatedAt = time.Now()
+	s.m.subscriptions[existing.ID] = existing
+	*sub = existing
+	return nil
+}
+
+func (s *MemorySubscriptionStore) Delete(ctx context.Context, id, version int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	existing, ok := s.m.subscriptions[id]
+	if !ok || existing.DeletedAt != nil {
+		return ErrNotFound
+	}
+	if err := checkVersion(version, existing.Version); err != nil {
+		return err
+	}
+	now := time.Now()
+	existing.DeletedAt = &now
+	existing.Version++
+	existing.UpdatedAt = now
+	s.m.subscriptions[id] = existing
+	return nil
+}
+
+func (s *MemorySubscriptionStore) Restore(ctx context.Context, id int) (models.Subscriptions, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	sub, ok := s.m.subscriptions[id]
+	if !ok {
+		return models.Subscriptions{}, ErrNotFound
+	}
+	if sub.DeletedAt != nil {
+		if !s.m.livePartner(sub.PartnerID) {
+			return models.Subscriptions{}, ErrInvalidReference
+		}
+		sub.DeletedAt = nil
+		sub.Version++
+		sub.UpdatedAt = time.Now()
+		s.m.subscriptions[id] = sub
+	}
+	return sub, nil
+}
+
+func (s *MemorySubscriptionStore) Transition(ctx context.Context, id, version int, change *models.SubscriptionStatusChange) (models.Subscriptions, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	sub, ok := s.m.subscriptions[id]
+	if !ok || sub.DeletedAt != nil {
+		return models.Subscriptions{}, ErrNotFound
+	}
+	if err := checkVersion(version, sub.Version); err != nil {
+		return models.Subscriptions{}, err
+	}
+	if sub.Status != change.FromStatus {
+		return models.Subscriptions{}, ErrConflict
+	}
+	now := time.Now()
+	sub.Status = change.ToStatus
+	if models.ClearsDunning(change.ToStatus) {
+		sub.DunningState, sub.FailedAttempts, sub.NextAttemptAt, sub.DunningPeriod = models.DunningCurrent, 0, nil, nil
+	}
+	sub.Version++
+	sub.UpdatedAt = now
+	s.m.subscriptions[id] = sub
+
+	change.SubscriptionID, change.CreatedAt = id, now
+	s.m.recordStatusChange(change)
+	return sub, nil
+}
+
+func (s *MemorySubscriptionStore) History(ctx context.Context, id int) ([]models.SubscriptionStatusChange, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	history := []models.SubscriptionStatusChange{}
+	for _, c := range s.m.statusHistory {
+		if c.SubscriptionID == id {
+			history = append(history, c)
+		}
+	}
+	return history, nil
+}
+
+func (s *MemorySubscriptionStore) Bill(ctx context.Context, id int, period, next, at time.Time) (models.Transactions, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	sub, ok := s.m.subscriptions[id]
+	if !ok || sub.DeletedAt != nil || sub.Status != models.SubscriptionActive || sub.NextBillingDate.After(period) {
+		return models.Transactions{}, ErrConflict
+	}
+	now := time.Now()
+	sub.NextBillingDate = next
+	sub.Version++
+	sub.UpdatedAt = now
+	s.m.subscriptions[id] = sub
+
+	t := models.Transactions{
+		ID:              s.m.nextID("transactions"),
+		SubscriptionID:  id,
+		TransactionDate: at,
+		Amount:          sub.BillingAmount,
+		Currency:        sub.Currency,
+		Status:          models.TransactionPending,
+		BillingPeriod:   &period,
+		Attempt:         1,
+		Version:         1,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	s.m.transactions[t.ID] = t
+	s.m.recordEvent("", t)
+	return t, nil
+}
+
+// renewable reports whether sub, found when ok, is a live, active, auto-renewing
+// subscription whose end date is still endDate
+func renewable(sub models.Subscriptions, ok bool, endDate time.Time) bool {
+	return ok && sub.DeletedAt == nil && sub.AutoRenew && sub.Status == models.SubscriptionActive && sub.EndDate.Equal(endDate)
+}
+
+// charged reports whether the subscription has a charge for period, with status
+// when status isn't empty; callers must hold the lock
+func (m *memoryDB) charged(id int, period time.Time, status string) bool {
+	for _, t := range m.transactions {
+		if t.SubscriptionID == id && t.BillingPeriod != nil && t.BillingPeriod.Equal(period) && (status == "" || t.Status == status) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemorySubscriptionStore) BillRenewal(ctx context.Context, id int, endDate, next, at time.Time) (models.Transactions, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	sub, ok := s.m.subscriptions[id]
+	if !renewable(sub, ok, endDate) || s.m.charged(id, endDate, "") {
+		return models.Transactions{}, ErrConflict
+	}
+	now := time.Now()
+	sub.NextBillingDate = next
+	sub.Version++
+	sub.UpdatedAt = now
+	s.m.subscriptions[id] = sub
+
+	t := models.Transactions{
+		ID:              s.m.nextID("transactions"),
+		SubscriptionID:  id,
+		TransactionDate: at,
+		Amount:          sub.BillingAmount,
+		Currency:        sub.Currency,
+		Status:          models.TransactionPending,
+		BillingPeriod:   &endDate,
+		Attempt:         1,
+		Version:         1,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	s.m.transactions[t.ID] = t
+	s.m.recordEvent("", t)
+	return t, nil
+}
+
+func (s *MemorySubscriptionStore) Renew(ctx context.Context, id int, endDate, next time.Time) (models.Subscriptions, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	sub, ok := s.m.subscriptions[id]
+	if !renewable(sub, ok, endDate) || !s.m.charged(id, endDate, models.TransactionSucceeded) {
+		return models.Subscriptions{}, ErrConflict
+	}
+	sub.EndDate = next
+	sub.Version++
+	sub.UpdatedAt = time.Now()
+	s.m.subscriptions[id] = sub
+	return sub, nil
+}
+
+func (s *MemorySubscriptionStore) Retry(ctx context.Context, id int, at time.Time) (models.Transactions, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	sub, ok := s.m.subscriptions[id]
+	if !ok || sub.DeletedAt != nil || sub.DunningState != models.DunningRetrying || sub.NextAttemptAt == nil || sub.NextAttemptAt.After(at) ||
+		sub.DunningPeriod == nil || (sub.Status != models.SubscriptionActive && sub.Status != models.SubscriptionSuspended) {
+		return models.Transactions{}, ErrConflict
+	}
+	now := time.Now()
+	sub.NextAttemptAt = nil
+	sub.Version++
+	sub.UpdatedAt = now
+	s.m.subscriptions[id] = sub
+
+	attempt := 0
+	for _, t := range s.m.transactions {
+		if t.SubscriptionID == id && t.BillingPeriod != nil && t.BillingPeriod.Equal(*sub.DunningPeriod) && t.Attempt > attempt {
+			attempt = t.Attempt
+		}
+	}
+	t := models.Transactions{
+		ID:              s.m.nextID("transactions"),
+		SubscriptionID:  id,
+		TransactionDate: at,
+		Amount:          sub.BillingAmount,
+		Currency:        sub.Currency,
+		Status:          models.TransactionPending,
+		BillingPeriod:   sub.DunningPeriod,
+		Attempt:         attempt + 1,
+		Version:         1,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+	s.m.transactions[t.ID] = t
+	s.m.recordEvent("", t)
+	return t, nil
+}
+
+// MemoryTransactionStore is a TransactionStore kept in process memory
+type MemoryTransactionStore struct {
+	m *memoryDB
+}
+
+func (s *MemoryTransactionStore) List(ctx context.Context, f TransactionFilter, page Page) ([]models.Transactions, *Cursor, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	partnerOf := func(subscriptionID int) int { return s.m.subscriptions[subscriptionID].PartnerID }
+	var transactions []models.Transactions
+	for _, t := range s.m.transactions {
+		if f.matches(t, partnerOf) {
+			transactions = append(transactions, t)
+		}
+	}
+	return memoryPage(transactions, transactionSorts, page, func(t models.Transactions) int { return t.ID })
+}
+
+func (s *MemoryTransactionStore) Get(ctx context.Context, id int) (models.Transactions, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	t, ok := s.m.transactions[id]
+	if !ok {
+		return models.Transactions{}, ErrNotFound
+	}
+	return t, nil
+}
+
+func (s *MemoryTransactionStore) Create(ctx context.Context, t *models.Transactions) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if !s.m.liveSubscription(t.SubscriptionID) {
+		return ErrInvalidReference
+	}
+	if err := checkCurrency(t, s.m.subscriptions[t.SubscriptionID].Currency); err != nil {
+		return err
+	}
+	now := time.Now()
+	t.ID = s.m.nextID("transactions")
+	t.BillingPeriod, t.Attempt = nil, 0
+	t.Version = 1
+	t.CreatedAt, t.UpdatedAt = now, now
+	s.m.transactions[t.ID] = *t
+	s.m.recordEvent("", *t)
+	return nil
+}
+
+func (s *MemoryTransactionStore) Update(ctx context.Context, t *models.Transactions) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	existing, ok := s.m.transactions[t.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(t.Version, existing.Version); err != nil {
+		return err
+	}
+	if err := models.CheckTransactionChange(existing, *t); err != nil {
+		return err
+	}
+	if !s.m.liveSubscription(t.SubscriptionID) {
+		return ErrInvalidReference
+	}
+	if err := checkCurrency(t, s.m.subscriptions[t.SubscriptionID].Currency); err != nil {
+		return err
+	}
+	t.BillingPeriod, t.Attempt = existing.BillingPeriod, existing.Attempt
+	t.Version = existing.Version + 1
+	t.CreatedAt = existing.CreatedAt
+	t.UpdatedAt = time.Now()
+	s.m.transactions[t.ID] = *t
+	s.m.recordEvent(existing.Status, *t)
+	s.m.settleCharge(existing.Status, *t)
+	return nil
+}
+
+func (s *MemoryTransactionStore) Patch(ctx context.Context, t *models.Transactions, columns []string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	existing, ok := s.m.transactions[t.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(t.Version, existing.Version); err != nil {
+		return err
+	}
+	from := existing
+	if err := applyPatch(transactionPatchColumns, &existing, *t, columns); err != nil {
+		return err
+	}
+	if err := models.CheckTransactionChange(from, existing); err != nil {
+		return err
+	}
+	if !s.m.liveSubscription(existing.SubscriptionID) {
+		return ErrInvalidReference
+	}
+	if err := checkCurrency(&existing, s.m.subscriptions[existing.SubscriptionID].Currency); err != nil {
+		return err
+	}
+	existing.Version++
+	existing.UpdatedAt = time.Now()
+	s.m.transactions[existing.ID] = existing
+	s.m.recordEvent(from.Status, existing)
+	s.m.settleCharge(from.Status, existing)
+	*t = existing
+	return nil
+}
+
+func (s *MemoryTransactionStore) Delete(ctx context.Context, id, version int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	existing, ok := s.m.transactions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkVersion(version, existing.Version); err != nil {
+		return err
+	}
+	if models.TransactionSettled(existing.Status) {
+		return fmt.Errorf("%w: transaction is %s", ErrConflict, existing.Status)
+	}
+	if existing.BillingPeriod != nil {
+		return fmt.Errorf("%w: transaction is a billing charge", ErrConflict)
+	}
+	delete(s.m.transactions, id)
+	// the events go with it, as they do through ON DELETE CASCADE
+	kept := s.m.events[:0]
+	for _, e := range s.m.events {
+		if e.TransactionID != id {
+			kept = append(kept, e)
+		}
+	}
+	s.m.events = kept
+	return nil
+}
+
+func (s *MemoryTransactionStore) Events(ctx context.Context, id int) ([]models.TransactionEvent, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	events := []models.TransactionEvent{}
+	for _, e := range s.m.events {
+		if e.TransactionID == id {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// MemoryAPIKeyStore is an APIKeyStore kept in process memory
+type MemoryAPIKeyStore struct {
+	m *memoryDB
+}
+
+func (s *MemoryAPIKeyStore) Create(ctx context.Context, k *models.APIKey) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if !s.m.livePartner(k.PartnerID) {
+		return ErrInvalidReference
+	}
+	k.ID = s.m.nextID("api_keys")
+	k.CreatedAt = time.Now()
+	s.m.apiKeys[k.ID] = *k
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) ListByPartner(ctx context.Context, partnerID int) ([]models.APIKey, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	var keys []models.APIKey
+	for _, k := range sortedValues(s.m.apiKeys) {
+		if k.PartnerID == partnerID {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (s *MemoryAPIKeyStore) GetByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	for _, k := range s.m.apiKeys {
+		if k.KeyHash == hash && s.m.livePartner(k.PartnerID) {
+			return k, nil
+		}
+	}
+	return models.APIKey{}, ErrNotFound
+}
+
+func (s *MemoryAPIKeyStore) Get(ctx context.Context, id int) (models.APIKey, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	k, ok := s.m.apiKeys[id]
+	if !ok || !s.m.livePartner(k.PartnerID) {
+		return models.APIKey{}, ErrNotFound
+	}
+	return k, nil
+}
+
+func (s *MemoryAPIKeyStore) MarkUsed(ctx context.Context, id int, at time.Time) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	k, ok := s.m.apiKeys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	k.LastUsedAt = &at
+	s.m.apiKeys[id] = k
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) Revoke(ctx context.Context, partnerID, id int) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	k, ok := s.m.apiKeys[id]
+	if !ok || k.PartnerID != partnerID || k.RevokedAt != nil {
+		return ErrNotFound
+	}
+	now := time.Now()
+	k.RevokedAt = &now
+	s.m.apiKeys[id] = k
+	return nil
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore kept in process memory
+type MemoryIdempotencyStore struct {
+	m *memoryDB
+}
+
+// idempotencyID is the map key of an Idempotency-Key within its scope
+func idempotencyID(scope, key string) string {
+	return scope + "\x00" + key
+}
+
+func (s *MemoryIdempotencyStore) Reserve(ctx context.Context, k *models.IdempotencyKey) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	k.CreatedAt = time.Now()
+	id := idempotencyID(k.Scope, k.Key)
+	if existing, ok := s.m.idempotencyKeys[id]; ok && existing.ExpiresAt.After(k.CreatedAt) {
+		return ErrConflict
+	}
+	s.m.idempotencyKeys[id] = *k
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Get(ctx context.Context, scope, key string) (models.IdempotencyKey, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+	k, ok := s.m.idempotencyKeys[idempotencyID(scope, key)]
+	if !ok || !k.ExpiresAt.After(time.Now()) {
+		return models.IdempotencyKey{}, ErrNotFound
+	}
+	return k, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, k *models.IdempotencyKey) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	id := idempotencyID(k.Scope, k.Key)
+	existing, ok := s.m.idempotencyKeys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	existing.ResponseStatus = k.ResponseStatus
+	existing.ResponseHeader = k.ResponseHeader.Clone()
+	existing.ResponseBody = append([]byte(nil), k.ResponseBody...)
+	s.m.idempotencyKeys[id] = existing
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, scope, key string) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	id := idempotencyID(scope, key)
+	if k, ok := s.m.idempotencyKeys[id]; ok && k.ResponseStatus == 0 {
+		delete(s.m.idempotencyKeys, id)
+	}
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	n := 0
+	for id, k := range s.m.idempotencyKeys {
+		if !k.ExpiresAt.After(now) {
+			delete(s.m.idempotencyKeys, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"fmt"
+	"infinity/models"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// patchColumn is one column a Patch may write: value reads it from the model
+// for SQL, copy moves it between models for the in-memory stores
+type patchColumn[T any] struct {
+	value func(T) any
+	copy  func(dst *T, src T)
+}
+
+// Patchable columns per table, keyed by column name; IDs and timestamps are never
+// patched, nor is a subscription's status, which moves through its lifecycle
+var partnerPatchColumns = map[string]patchColumn[models.Partner]{
+	"name": {
+		value: func(p models.Partner) any { return p.Name },
+		copy:  func(d *models.Partner, s models.Partner) { d.Name = s.Name },
+	},
+	"email": {
+		value: func(p models.Partner) any { return p.Email },
+		copy:  func(d *models.Partner, s models.Partner) { d.Email = s.Email },
+	},
+	"phone_number": {
+		value: func(p models.Partner) any { return p.PhoneNumber },
+		copy:  func(d *models.Partner, s models.Partner) { d.PhoneNumber = s.PhoneNumber },
+	},
+	"billing_address": {
+		value: func(p models.Partner) any { return p.BillingAddress },
+		copy:  func(d *models.Partner, s models.Partner) { d.BillingAddress = s.BillingAddress },
+	},
+	"retry_schedule": {
+		value: func(p models.Partner) any { return pq.StringArray(p.RetrySchedule.Strings()) },
+		copy:  func(d *models.Partner, s models.Partner) { d.RetrySchedule = s.RetrySchedule },
+	},
+	"suspend_after": {
+		value: func(p models.Partner) any { return p.SuspendAfter },
+		copy:  func(d *models.Partner, s models.Partner) { d.SuspendAfter = s.SuspendAfter },
+	},
+}
+
+var planPatchColumns = map[string]patchColumn[models.Plan]{
+	"name": {
+		value: func(p models.Plan) any { return p.Name },
+		copy:  func(d *models.Plan, s models.Plan) { d.Name = s.Name },
+	},
+	"description": {
+		value: func(p models.Plan) any { return p.Description },
+		copy:  func(d *models.Plan, s models.Plan) { d.Description = s.Description },
+	},
+	"price": {
+		value: func(p models.Plan) any { return p.Price },
+		copy:  func(d *models.Plan, s models.Plan) { d.Price = s.Price },
+	},
+	"currency": {
+		value: func(p models.Plan) any { return p.Currency },
+		copy:  func(d *models.Plan, s models.Plan) { d.Currency = s.Currency },
+	},
+	"billing_cycle": {
+		value: func(p models.Plan) any { return p.BillingCycle },
+		copy:  func(d *models.Plan, s models.Plan) { d.BillingCycle = s.BillingCycle },
+	},
+	"trial_days": {
+		value: func(p models.Plan) any { return p.TrialDays },
+		copy:  func(d *models.Plan, s models.Plan) { d.TrialDays = s.TrialDays },
+	},
+}
+
+var subscriptionPatchColumns = map[string]patchColumn[models.Subscriptions]{
+	"partner_id": {
+		value: func(s models.Subscriptions) any { return s.PartnerID },
+		copy:  func(d *models.Subscriptions, s models.Subscriptions) { d.PartnerID = s.PartnerID },
+	},
+	"customer_msisdn": {
+		value: func(s models.Subscriptions) any { return s.CustomerMSISDN },
+		copy:  func(d *models.Subscriptions, s models.Subscriptions) { d.CustomerMSISDN = s.CustomerMSISDN },
+	},
+	"subscription_date": {
+		value: func(s models.Subscriptions) any { return s.SubscriptionDate },
+		copy:  func(d *models.Subscriptions, s models.Subscriptions) { d.SubscriptionDate = s.SubscriptionDate },
+	},
+	"billing_amount": {
+		value: func(s models.Subscriptions) any { return s.BillingAmount },
+		copy:  func(d *models.Subscriptions, s models.Subscriptions) { d.BillingAmount = s.BillingAmount },
+	},
+	"currency": {
+		value: func(s models.Subscriptions) any { return s.Currency },
+		copy:  func(d *models.Subscriptions, s models.Subscriptions) { d.Currency = s.Currency },
+	},
+	"billing_cycle": {
+		value: func(s models.Subscriptions) any { return s.BillingCycle },
+		copy:  func(d *models.Subscriptions, s models.Subscriptions) { d.BillingCycle = s.BillingCycle },
+	},
+	"start_date": {
+		value: func(s models.Subscriptions) any { return s.StartDate },
+		copy:  func(d *models.Subscriptions, s models.Subscriptions) { d.StartDate = s.StartDate },
+	},
+	"end_date": {
+		value: func(s models.Subscriptions) any { return s.EndDate },
+		copy:  func(d *models.Subscriptions, s models.Subscriptions) { d.EndDate = s.EndDate },
+	},
+	"auto_renew": {
+		value: func(s models.Subscriptions) any { return s.AutoRenew },
+		copy:  func(d *models.Subscriptions, s models.Subscriptions) { d.AutoRenew = s.AutoRenew },
+	},
+	// only ever written by the store, following a patched start date or billing cycle
+	"next_billing_date": {
+		value: func(s models.Subscriptions) any { return s.NextBillingDate },
+		copy:  func(d *models.Subscriptions, s models.Subscriptions) { d.NextBillingDate = s.NextBillingDate },
+	},
+}
+
+// reschedulesBilling reports whether patching columns can move a subscription's billing dates
+func reschedulesBilling(columns []string) bool {
+	for _, c := range columns {
+		if c == "start_date" || c == "billing_cycle" {
+			return true
+		}
+	}
+	return false
+}
+
+var transactionPatchColumns = map[string]patchColumn[models.Transactions]{
+	"subscription_id": {
+		value: func(t models.Transactions) any { return t.SubscriptionID },
+		copy:  func(d *models.Transactions, s models.Transactions) { d.SubscriptionID = s.SubscriptionID },
+	},
+	"transaction_date": {
+		value: func(t models.Transactions) any { return t.TransactionDate },
+		copy:  func(d *models.Transactions, s models.Transactions) { d.TransactionDate = s.TransactionDate },
+	},
+	"amount": {
+		value: func(t models.Transactions) any { return t.Amount },
+		copy:  func(d *models.Transactions, s models.Transactions) { d.Amount = s.Amount },
+	},
+	"currency": {
+		value: func(t models.Transactions) any { return t.Currency },
+		copy:  func(d *models.Transactions, s models.Transactions) { d.Currency = s.Currency },
+	},
+	"status": {
+		value: func(t models.Transactions) any { return t.Status },
+		copy:  func(d *models.Transactions, s models.Transactions) { d.Status = s.Status },
+	},
+}
+
+// checkPatchColumns rejects columns the table doesn't allow patching
+func checkPatchColumns[T any](cols map[string]patchColumn[T], columns []string) error {
+	for _, c := range columns {
+		if _, ok := cols[c]; !ok {
+			return fmt.Errorf("store: cannot patch column %q", c)
+		}
+	}
+	return nil
+}
+
+// patchQuery builds an UPDATE of table that writes only the named columns of row,
+// bumps updated_at and the version and returns the stored row. A non-zero
+// version pins the write to the row still having it; soft-deleted rows are never written
+func patchQuery[T any](table string, cols map[string]patchColumn[T], row T, id, version int, columns []string, returning string) (string, []any, error) {
+	if err := checkPatchColumns(cols, columns); err != nil {
+		return "", nil, err
+	}
+
+	// the SET values take the first placeholders, the WHERE clause the rest
+	w := &where{}
+	set := make([]string, 0, len(columns)+1)
+	for _, c := range columns {
+		w.args = append(w.args, cols[c].value(row))
+		set = append(set, fmt.Sprintf("%s=$%d", c, len(w.args)))
+	}
+	w.args = append(w.args, time.Now())
+	set = append(set, fmt.Sprintf("updated_at=$%d", len(w.args)), "version=version+1")
+	w.add("id=?", id)
+	if version != 0 {
+		w.add("version=?", version)
+	}
+	if softDeleteTables[table] {
+		w.addRaw("deleted_at IS NULL")
+	}
+
+	query := "UPDATE " + table + " SET " + strings.Join(set, ", ")
+	return query + w.String() + " RETURNING " + returning, w.args, nil
+}
+
+// applyPatch copies the named columns of src onto dst for the in-memory stores
+func applyPatch[T any](cols map[string]patchColumn[T], dst *T, src T, columns []string) error {
+	if err := checkPatchColumns(cols, columns); err != nil {
+		return err
+	}
+	for _, c := range columns {
+		cols[c].copy(dst, src)
+	}
+	return nil
+}
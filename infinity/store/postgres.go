@@ -0,0 +1,1146 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"infinity/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// notFound turns sql.ErrNoRows into ErrNotFound and passes other errors through
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Postgres error codes the stores translate
+const (
+	pqUniqueViolation     = "23505"
+	pqForeignKeyViolation = "23503"
+)
+
+// constraintError turns constraint violations into ErrConflict and ErrInvalidReference.
+// A foreign key violation on a write means the referenced row is missing; on a
+// delete it means other rows still reference the one being deleted
+func constraintError(err error, deleting bool) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+	switch pqErr.Code {
+	case pqUniqueViolation:
+		return fmt.Errorf("%w: %s", ErrConflict, pqErr.Constraint)
+	case pqForeignKeyViolation:
+		if deleting {
+			return fmt.Errorf("%w: %s", ErrConflict, pqErr.Constraint)
+		}
+		return fmt.Errorf("%w: %s", ErrInvalidReference, pqErr.Constraint)
+	}
+	return err
+}
+
+// softDeleteTables are the tables whose rows are soft-deleted through deleted_at
+var softDeleteTables = map[string]bool{"partners": true, "plans": true, "subscriptions": true}
+
+// live is the condition that leaves soft-deleted rows of table out, or "" when
+// the table has no soft delete
+func live(table string) string {
+	if softDeleteTables[table] {
+		return " AND deleted_at IS NULL"
+	}
+	return ""
+}
+
+// missingOrStale explains why a versioned write to table matched no row:
+// either the row is gone, soft-deleted included, or its version has moved on
+func missingOrStale(ctx context.Context, db *sql.DB, table string, id int) error {
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM "+table+" WHERE id=$1"+live(table)+")", id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return ErrVersionMismatch
+	}
+	return ErrNotFound
+}
+
+// requireLive locks the row of table with id against deletion for the rest of tx,
+// returning ErrInvalidReference when it doesn't exist or is soft-deleted
+func requireLive(ctx context.Context, tx *sql.Tx, table string, id int) error {
+	var one int
+	err := tx.QueryRowContext(ctx, "SELECT 1 FROM "+table+" WHERE id=$1"+live(table)+" FOR SHARE", id).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidReference
+	}
+	return err
+}
+
+// requireSubscriptionCurrency locks the live subscription of t like requireLive and
+// checks that t is in the subscription's currency
+func requireSubscriptionCurrency(ctx context.Context, tx *sql.Tx, t *models.Transactions) error {
+	var currency string
+	err := tx.QueryRowContext(ctx, "SELECT currency FROM subscriptions WHERE id=$1 AND deleted_at IS NULL FOR SHARE", t.SubscriptionID).Scan(&currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidReference
+	}
+	if err != nil {
+		return err
+	}
+	return checkCurrency(t, currency)
+}
+
+// inTx runs fn in a transaction, committing when it returns nil and rolling back otherwise
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkAffected returns ErrNotFound when an UPDATE or DELETE touched no rows
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// partners
+
+const partnerColumns = "id, name, email, phone_number, billing_address, retry_schedule, suspend_after, version, deleted_at, created_at, updated_at"
+
+func scanPartner(row scanner) (models.Partner, error) {
+	var p models.Partner
+	var schedule pq.StringArray
+	err := row.Scan(&p.ID, &p.Name, &p.Email, &p.PhoneNumber, &p.BillingAddress, &schedule, &p.SuspendAfter, &p.Version, &p.DeletedAt, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return p, err
+	}
+	p.RetrySchedule, err = models.ParseRetrySchedule(schedule)
+	return p, err
+}
+
+// PostgresPartnerStore is a PartnerStore backed by the partners table
+type PostgresPartnerStore struct {
+	db *sql.DB
+}
+
+func (s *PostgresPartnerStore) List(ctx context.Context, f PartnerFilter, page Page) ([]models.Partner, *Cursor, error) {
+	w := f.where()
+	suffix, sortBy, err := pageClause(w, partnerSorts, page)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+partnerColumns+" FROM partners"+w.String()+suffix, w.args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var partners []models.Partner
+	for rows.Next() {
+		p, err := scanPartner(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		partners = append(partners, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	partners, next := finishPage(partners, sortBy, page, func(p models.Partner) int { return p.ID })
+	return partners, next, nil
+}
+
+func (s *PostgresPartnerStore) Get(ctx context.Context, id int) (models.Partner, error) {
+	p, err := scanPartner(s.db.QueryRowContext(ctx, "SELECT "+partnerColumns+" FROM partners WHERE id=$1 AND deleted_at IS NULL", id))
+	return p, notFound(err)
+}
+
+func (s *PostgresPartnerStore) GetWithDeleted(ctx context.Context, id int) (models.Partner, error) {
+	p, err := scanPartner(s.db.QueryRowContext(ctx, "SELECT "+partnerColumns+" FROM partners WHERE id=$1", id))
+	return p, notFound(err)
+}
+
+func (s *PostgresPartnerStore) Create(ctx context.Context, p *models.Partner) error {
+	now := time.Now()
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO partners (name, email, phone_number, billing_address, retry_schedule, suspend_after, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id, version, created_at, updated_at`,
+		p.Name, p.Email, p.PhoneNumber, p.BillingAddress, pq.StringArray(p.RetrySchedule.Strings()), p.SuspendAfter, now, now).Scan(&p.ID, &p.Version, &p.CreatedAt, &p.UpdatedAt)
+	return constraintError(err, false)
+}
+
+func (s *PostgresPartnerStore) Update(ctx context.Context, p *models.Partner) error {
+	updated, err := scanPartner(s.db.QueryRowContext(ctx, `
+		UPDATE partners
+		SET name=$1, email=$2, phone_number=$3, billing_address=$4, retry_schedule=$5, suspend_after=$6, updated_at=$7, version=version+1
+		WHERE id=$8 AND ($9 = 0 OR version=$9) AND deleted_at IS NULL
+		RETURNING `+partnerColumns,
+		p.Name, p.Email, p.PhoneNumber, p.BillingAddress, pq.StringArray(p.RetrySchedule.Strings()), p.SuspendAfter, time.Now(), p.ID, p.Version))
+	if errors.Is(err, sql.ErrNoRows) {
+		return missingOrStale(ctx, s.db, "partners", p.ID)
+	}
+	if err != nil {
+		return constraintError(err, false)
+	}
+	*p = updated
+	return nil
+}
+
+func (s *PostgresPartnerStore) Patch(ctx context.Context, p *models.Partner, columns []string) error {
+	query, args, err := patchQuery("partners", partnerPatchColumns, *p, p.ID, p.Version, columns, partnerColumns)
+	if err != nil {
+		return err
+	}
+	updated, err := scanPartner(s.db.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return missingOrStale(ctx, s.db, "partners", p.ID)
+	}
+	if err != nil {
+		return constraintError(err, false)
+	}
+	*p = updated
+	return nil
+}
+
+func (s *PostgresPartnerStore) Delete(ctx context.Context, id, version int, cascade bool) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		now := time.Now()
+		res, err := tx.ExecContext(ctx, `
+			UPDATE partners SET deleted_at=$1, updated_at=$1, version=version+1
+			WHERE id=$2 AND ($3 = 0 OR version=$3) AND deleted_at IS NULL`, now, id, version)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(res); err != nil {
+			if version == 0 {
+				return err
+			}
+			return missingOrStale(ctx, s.db, "partners", id)
+		}
+
+		if cascade {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE subscriptions SET deleted_at=$1, updated_at=$1, version=version+1
+				WHERE partner_id=$2 AND deleted_at IS NULL`, now, id)
+			return err
+		}
+		var active bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM subscriptions
+			WHERE partner_id=$1 AND deleted_at IS NULL AND status NOT IN ('cancelled', 'expired'))`, id).Scan(&active)
+		if err != nil {
+			return err
+		}
+		if active {
+			return fmt.Errorf("%w: partner has active subscriptions", ErrConflict)
+		}
+		return nil
+	})
+}
+
+func (s *PostgresPartnerStore) Restore(ctx context.Context, id int) (models.Partner, error) {
+	var p models.Partner
+	live := false
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var deletedAt time.Time
+		err := tx.QueryRowContext(ctx, "SELECT deleted_at FROM partners WHERE id=$1 AND deleted_at IS NOT NULL FOR UPDATE", id).Scan(&deletedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			// already live, or missing altogether
+			live = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// a cascade stamps the subscriptions with the partner's own deletion time
+		now := time.Now()
+		_, err = tx.ExecContext(ctx, `
+			UPDATE subscriptions SET deleted_at=NULL, updated_at=$1, version=version+1
+			WHERE partner_id=$2 AND deleted_at=$3`, now, id, deletedAt)
+		if err != nil {
+			return err
+		}
+		p, err = scanPartner(tx.QueryRowContext(ctx, `
+			UPDATE partners SET deleted_at=NULL, updated_at=$1, version=version+1
+			WHERE id=$2
+			RETURNING `+partnerColumns, now, id))
+		return err
+	})
+	if err == nil && live {
+		return s.Get(ctx, id)
+	}
+	return p, err
+}
+
+// plans
+
+const planColumns = "id, partner_id, name, description, price, currency, billing_cycle, trial_days, version, deleted_at, created_at, updated_at"
+
+func scanPlan(row scanner) (models.Plan, error) {
+	var p models.Plan
+	err := row.Scan(&p.ID, &p.PartnerID, &p.Name, &p.Description, &p.Price, &p.Currency, &p.BillingCycle, &p.TrialDays, &p.Version, &p.DeletedAt, &p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+// PostgresPlanStore is a PlanStore backed by the plans table
+type PostgresPlanStore struct {
+	db *sql.DB
+}
+
+func (s *PostgresPlanStore) ListByPartner(ctx context.Context, partnerID int) ([]models.Plan, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+planColumns+" FROM plans WHERE partner_id=$1 AND deleted_at IS NULL ORDER BY id", partnerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plans []models.Plan
+	for rows.Next() {
+		p, err := scanPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, p)
+	}
+	return plans, rows.Err()
+}
+
+func (s *PostgresPlanStore) Get(ctx context.Context, id int) (models.Plan, error) {
+	p, err := scanPlan(s.db.QueryRowContext(ctx, "SELECT "+planColumns+" FROM plans WHERE id=$1 AND deleted_at IS NULL", id))
+	return p, notFound(err)
+}
+
+func (s *PostgresPlanStore) Create(ctx context.Context, p *models.Plan) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		// the foreign key still accepts a soft-deleted partner
+		if err := requireLive(ctx, tx, "partners", p.PartnerID); err != nil {
+			return err
+		}
+		now := time.Now()
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO plans (partner_id, name, description, price, currency, billing_cycle, trial_days, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING id, version, created_at, updated_at`,
+			p.PartnerID, p.Name, p.Description, p.Price, p.Currency, p.BillingCycle, p.TrialDays, now, now).Scan(&p.ID, &p.Version, &p.CreatedAt, &p.UpdatedAt)
+		return constraintError(err, false)
+	})
+}
+
+func (s *PostgresPlanStore) Update(ctx context.Context, p *models.Plan) error {
+	updated, err := scanPlan(s.db.QueryRowContext(ctx, `
+		UPDATE plans
+		SET name=$1, description=$2, price=$3, currency=$4, billing_cycle=$5, trial_days=$6, updated_at=$7, version=version+1
+		WHERE id=$8 AND ($9 = 0 OR version=$9) AND deleted_at IS NULL
+		RETURNING `+planColumns,
+		p.Name, p.Description, p.Price, p.Currency, p.BillingCycle, p.TrialDays, time.Now(), p.ID, p.Version))
+	if errors.Is(err, sql.ErrNoRows) {
+		return missingOrStale(ctx, s.db, "plans", p.ID)
+	}
+	if err != nil {
+		return constraintError(err, false)
+	}
+	*p = updated
+	return nil
+}
+
+func (s *PostgresPlanStore) Patch(ctx context.Context, p *models.Plan, columns []string) error {
+	query, args, err := patchQuery("plans", planPatchColumns, *p, p.ID, p.Version, columns, planColumns)
+	if err != nil {
+		return err
+	}
+	updated, err := scanPlan(s.db.QueryRowContext(ctx, query, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return missingOrStale(ctx, s.db, "plans", p.ID)
+	}
+	if err != nil {
+		return constraintError(err, false)
+	}
+	*p = updated
+	return nil
+}
+
+func (s *PostgresPlanStore) Delete(ctx context.Context, id, version int) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE plans SET deleted_at=$1, updated_at=$1, version=version+1
+		WHERE id=$2 AND ($3 = 0 OR version=$3) AND deleted_at IS NULL`, time.Now(), id, version)
+	if err != nil {
+		return err
+	}
+	if err := checkAffected(res); err != nil {
+		if version == 0 {
+			return err
+		}
+		return missingOrStale(ctx, s.db, "plans", id)
+	}
+	return nil
+}
+
+// subscriptions
+
+const subscriptionColumns = "id, partner_id, plan_id, customer_msisdn, subscription_date, status, billing_amount, currency, billing_cycle, start_date, end_date, auto_renew, trial_ends_at, next_billing_date, dunning_state, failed_attempts, next_attempt_at, dunning_period, version, deleted_at, created_at, updated_at"
+
+func scanSubscription(row scanner) (models.Subscriptions, error) {
+	var s models.Subscriptions
+	err := row.Scan(&s.ID, &s.PartnerID, &s.PlanID, &s.CustomerMSISDN, &s.SubscriptionDate, &s.Status, &s.BillingAmount, &s.Currency, &s.BillingCycle, &s.StartDate, &s.EndDate, &s.AutoRenew, &s.TrialEndsAt, &s.NextBillingDate, &s.DunningState, &s.FailedAttempts, &s.NextAttemptAt, &s.DunningPeriod, &s.Version, &s.DeletedAt, &s.CreatedAt, &s.UpdatedAt)
+	return s, err
+}
+
+// PostgresSubscriptionStore is a SubscriptionStore backed by the subscriptions table
+type PostgresSubscriptionStore struct {
+	db *sql.DB
+}
+
+func (s *PostgresSubscriptionStore) List(ctx context.Context, f SubscriptionFilter, page Page) ([]models.Subscriptions, *Cursor, error) {
+	w := f.where()
+	suffix, sortBy, err := pageClause(w, subscriptionSorts, page)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+subscriptionColumns+" FROM subscriptions"+w.String()+suffix, w.args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []models.Subscriptions
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		subscriptions = append(subscriptions, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	subscriptions, next := finishPage(subscriptions, sortBy, page, func(s models.Subscriptions) int { return s.ID })
+	return subscriptions, next, nil
+}
+
+func (s *PostgresSubscriptionStore) Get(ctx context.Context, id int) (models.Subscriptions, error) {
+	sub, err := scanSubscription(s.db.QueryRowContext(ctx, "SELECT "+subscriptionColumns+" FROM subscriptions WHERE id=$1 AND deleted_at IS NULL", id))
+	return sub, notFound(err)
+}
+
+func (s *PostgresSubscriptionStore) GetWithDeleted(ctx context.Context, id int) (models.Subscriptions, error) {
+	sub, err := scanSubscription(s.db.QueryRowContext(ctx, "SELECT "+subscriptionColumns+" FROM subscriptions WHERE id=$1", id))
+	return sub, notFound(err)
+}
+
+func (s *PostgresSubscriptionStore) Create(ctx context.Context, sub *models.Subscriptions) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		// the foreign key still accepts a soft-deleted partner
+		if err := requireLive(ctx, tx, "partners", sub.PartnerID); err != nil {
+			return err
+		}
+		if sub.PlanID != nil {
+			if err := requireLive(ctx, tx, "plans", *sub.PlanID); err != nil {
+				return err
+			}
+		}
+		// the first period is billed on the start date, or once the trial ends
+		now := time.Now()
+		sub.NextBillingDate = sub.FirstBillingDate()
+		sub.DunningState, sub.FailedAttempts, sub.NextAttemptAt, sub.DunningPeriod = models.DunningCurrent, 0, nil, nil
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO subscriptions (partner_id, plan_id, customer_msisdn, subscription_date, status, billing_amount, currency, billing_cycle, start_date, end_date, auto_renew, trial_ends_at, next_billing_date, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+			RETURNING id, version, created_at, updated_at`,
+			sub.PartnerID, sub.PlanID, sub.CustomerMSISDN, sub.SubscriptionDate, sub.Status, sub.BillingAmount, sub.Currency, sub.BillingCycle, sub.StartDate, sub.EndDate, sub.AutoRenew, sub.TrialEndsAt, sub.NextBillingDate, now, now).Scan(&sub.ID, &sub.Version, &sub.CreatedAt, &sub.UpdatedAt)
+		return constraintError(err, false)
+	})
+}
+
+// rescheduleBilling locks the subscription of sub and sets sub's next billing date
+// from its stored trial and the last period charged to it, so a new start date or
+// billing cycle moves the schedule along instead of leaving it behind
+func rescheduleBilling(ctx context.Context, tx *sql.Tx, sub *models.Subscriptions) error {
+	scheduled := *sub
+	err := tx.QueryRowContext(ctx, "SELECT trial_ends_at FROM subscriptions WHERE id=$1 FOR UPDATE", sub.ID).Scan(&scheduled.TrialEndsAt)
+	if err != nil {
+		return notFound(err)
+	}
+	var last *time.Time
+	if err := tx.QueryRowContext(ctx, "SELECT MAX(billing_period) FROM transactions WHERE subscription_id=$1", sub.ID).Scan(&last); err != nil {
+		return err
+	}
+	sub.NextBillingDate = models.ScheduledBillingDate(scheduled, last)
+	return nil
+}
+
+func (s *PostgresSubscriptionStore) Update(ctx context.Context, sub *models.Subscriptions) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := requireLive(ctx, tx, "partners", sub.PartnerID); err != nil {
+			return err
+		}
+		if err := rescheduleBilling(ctx, tx, sub); err != nil {
+			return err
+		}
+		updated, err := scanSubscription(tx.QueryRowContext(ctx, `
+			UPDATE subscriptions
+			SET partner_id=$1, customer_msisdn=$2, subscription_date=$3, billing_amount=$4, currency=$5, billing_cycle=$6, start_date=$7, end_date=$8, auto_renew=$9, next_billing_date=$10, updated_at=$11, version=version+1
+			WHERE id=$12 AND ($13 = 0 OR version=$13) AND deleted_at IS NULL
+			RETURNING `+subscriptionColumns,
+			sub.PartnerID, sub.CustomerMSISDN, sub.SubscriptionDate, sub.BillingAmount, sub.Currency, sub.BillingCycle, sub.StartDate, sub.EndDate, sub.AutoRenew, sub.NextBillingDate, time.Now(), sub.ID, sub.Version))
+		if errors.Is(err, sql.ErrNoRows) {
+			return missingOrStale(ctx, s.db, "subscriptions", sub.ID)
+		}
+		if err != nil {
+			return constraintError(err, false)
+		}
+		*sub = updated
+		return nil
+	})
+}
+
+func (s *PostgresSubscriptionStore) Patch(ctx context.Context, sub *models.Subscriptions, columns []string) error {
+	if err := checkPatchColumns(subscriptionPatchColumns, columns); err != nil {
+		return err
+	}
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := requireLive(ctx, tx, "partners", sub.PartnerID); err != nil {
+			return err
+		}
+		if reschedulesBilling(columns) {
+			if err := rescheduleBilling(ctx, tx, sub); err != nil {
+				return err
+			}
+			columns = append(columns[:len(columns):len(columns)], "next_billing_date")
+		}
+		query, args, err := patchQuery("subscriptions", subscriptionPatchColumns, *sub, sub.ID, sub.Version, columns, subscriptionColumns)
+		if err != nil {
+			return err
+		}
+		updated, err := scanSubscription(tx.QueryRowContext(ctx, query, args...))
+		if errors.Is(err, sql.ErrNoRows) {
+			return missingOrStale(ctx, s.db, "subscriptions", sub.ID)
+		}
+		if err != nil {
+			return constraintError(err, false)
+		}
+		*sub = updated
+		return nil
+	})
+}
+
+func (s *PostgresSubscriptionStore) Delete(ctx context.Context, id, version int) error {
+	now := time.Now()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE subscriptions SET deleted_at=$1, updated_at=$1, version=version+1
+		WHERE id=$2 AND ($3 = 0 OR version=$3) AND deleted_at IS NULL`, now, id, version)
+	if err != nil {
+		return err
+	}
+	if err := checkAffected(res); err != nil {
+		if version == 0 {
+			return err
+		}
+		return missingOrStale(ctx, s.db, "subscriptions", id)
+	}
+	return nil
+}
+
+func (s *PostgresSubscriptionStore) Restore(ctx context.Context, id int) (models.Subscriptions, error) {
+	var sub models.Subscriptions
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		sub, err = scanSubscription(tx.QueryRowContext(ctx, "SELECT "+subscriptionColumns+" FROM subscriptions WHERE id=$1 FOR UPDATE", id))
+		if err != nil || sub.DeletedAt == nil {
+			// missing, or already live
+			return notFound(err)
+		}
+		if err := requireLive(ctx, tx, "partners", sub.PartnerID); err != nil {
+			return err
+		}
+		sub, err = scanSubscription(tx.QueryRowContext(ctx, `
+			UPDATE subscriptions SET deleted_at=NULL, updated_at=$1, version=version+1
+			WHERE id=$2
+			RETURNING `+subscriptionColumns, time.Now(), id))
+		return err
+	})
+	return sub, err
+}
+
+func (s *PostgresSubscriptionStore) Transition(ctx context.Context, id, version int, change *models.SubscriptionStatusChange) (models.Subscriptions, error) {
+	var sub models.Subscriptions
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		now := time.Now()
+		var err error
+		sub, err = scanSubscription(tx.QueryRowContext(ctx, `
+			UPDATE subscriptions SET status=$1, updated_at=$2, version=version+1,
+				dunning_state=CASE WHEN $6 THEN $7 ELSE dunning_state END,
+				failed_attempts=CASE WHEN $6 THEN 0 ELSE failed_attempts END,
+				next_attempt_at=CASE WHEN $6 THEN NULL ELSE next_attempt_at END,
+				dunning_period=CASE WHEN $6 THEN NULL ELSE dunning_period END
+			WHERE id=$3 AND status=$4 AND ($5 = 0 OR version=$5) AND deleted_at IS NULL
+			RETURNING `+subscriptionColumns, change.ToStatus, now, id, change.FromStatus, version,
+			models.ClearsDunning(change.ToStatus), models.DunningCurrent))
+		if errors.Is(err, sql.ErrNoRows) {
+			// without a pinned version the row can only have missed on its status
+			err = missingOrStale(ctx, s.db, "subscriptions", id)
+			if version == 0 && errors.Is(err, ErrVersionMismatch) {
+				return ErrConflict
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		change.SubscriptionID, change.CreatedAt = id, now
+		return insertStatusChange(ctx, tx, change)
+	})
+	return sub, err
+}
+
+// insertStatusChange appends change to its subscription's status history
+func insertStatusChange(ctx context.Context, tx *sql.Tx, change *models.SubscriptionStatusChange) error {
+	return tx.QueryRowContext(ctx, `
+		INSERT INTO subscription_status_history (subscription_id, from_status, to_status, reason, actor, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		change.SubscriptionID, change.FromStatus, change.ToStatus, change.Reason, change.Actor, change.CreatedAt).Scan(&change.ID)
+}
+
+func (s *PostgresSubscriptionStore) History(ctx context.Context, id int) ([]models.SubscriptionStatusChange, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, subscription_id, from_status, to_status, reason, actor, created_at
+		FROM subscription_status_history WHERE subscription_id=$1 ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []models.SubscriptionStatusChange{}
+	for rows.Next() {
+		var c models.SubscriptionStatusChange
+		if err := rows.Scan(&c.ID, &c.SubscriptionID, &c.FromStatus, &c.ToStatus, &c.Reason, &c.Actor, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, c)
+	}
+	return history, rows.Err()
+}
+
+func (s *PostgresSubscriptionStore) Bill(ctx context.Context, id int, period, next, at time.Time) (models.Transactions, error) {
+	var t models.Transactions
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		// moving the billing date first claims the period against concurrent runs
+		now := time.Now()
+		sub, err := scanSubscription(tx.QueryRowContext(ctx, `
+			UPDATE subscriptions SET next_billing_date=$1, updated_at=$2, version=version+1
+			WHERE id=$3 AND status=$4 AND next_billing_date <= $5 AND deleted_at IS NULL
+			RETURNING `+subscriptionColumns, next, now, id, models.SubscriptionActive, period))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrConflict
+		}
+		if err != nil {
+			return err
+		}
+
+		t = models.Transactions{
+			SubscriptionID:  id,
+			TransactionDate: at,
+			Amount:          sub.BillingAmount,
+			Currency:        sub.Currency,
+			Status:          models.TransactionPending,
+			BillingPeriod:   &period,
+			Attempt:         1,
+		}
+		return insertCharge(ctx, tx, &t, now)
+	})
+	return t, err
+}
+
+func (s *PostgresSubscriptionStore) BillRenewal(ctx context.Context, id int, endDate, next, at time.Time) (models.Transactions, error) {
+	var t models.Transactions
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		// the renewal charge pays up to the new end date, so billing resumes there
+		now := time.Now()
+		sub, err := scanSubscription(tx.QueryRowContext(ctx, `
+			UPDATE subscriptions s SET next_billing_date=$1, updated_at=$2, version=version+1
+			WHERE id=$3 AND end_date=$4 AND auto_renew AND status=$5 AND deleted_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM transactions t WHERE t.subscription_id=s.id AND t.billing_period=$4)
+			RETURNING `+subscriptionColumns, next, now, id, endDate, models.SubscriptionActive))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrConflict
+		}
+		if err != nil {
+			return err
+		}
+
+		t = models.Transactions{
+			SubscriptionID:  id,
+			TransactionDate: at,
+			Amount:          sub.BillingAmount,
+			Currency:        sub.Currency,
+			Status:          models.TransactionPending,
+			BillingPeriod:   &endDate,
+			Attempt:         1,
+		}
+		// a concurrent run that charged the period first trips the unique index
+		return insertCharge(ctx, tx, &t, now)
+	})
+	return t, err
+}
+
+func (s *PostgresSubscriptionStore) Renew(ctx context.Context, id int, endDate, next time.Time) (models.Subscriptions, error) {
+	// any attempt at the renewal charge may be the one that succeeded
+	sub, err := scanSubscription(s.db.QueryRowContext(ctx, `
+		UPDATE subscriptions s SET end_date=$1, updated_at=$2, version=version+1
+		WHERE id=$3 AND end_date=$4 AND auto_renew AND status=$5 AND deleted_at IS NULL
+		AND EXISTS (
+			SELECT 1 FROM transactions t
+			WHERE t.subscription_id=s.id AND t.billing_period=$4 AND t.status=$6
+		)
+		RETURNING `+subscriptionColumns, next, time.Now(), id, endDate, models.SubscriptionActive, models.TransactionSucceeded))
+	if errors.Is(err, sql.ErrNoRows) {
+		return sub, ErrConflict
+	}
+	return sub, err
+}
+
+func (s *PostgresSubscriptionStore) Retry(ctx context.Context, id int, at time.Time) (models.Transactions, error) {
+	var t models.Transactions
+	err := inTx(ctx, s.db, func(tx *sql.Tx) error {
+		// clearing the attempt time claims the retry against concurrent runs
+		now := time.Now()
+		sub, err := scanSubscription(tx.QueryRowContext(ctx, `
+			UPDATE subscriptions SET next_attempt_at=NULL, updated_at=$1, version=version+1
+			WHERE id=$2 AND dunning_state=$3 AND next_attempt_at <= $4 AND dunning_period IS NOT NULL
+			AND status IN ($5, $6) AND deleted_at IS NULL
+			RETURNING `+subscriptionColumns, now, id, models.DunningRetrying, at, models.SubscriptionActive, models.SubscriptionSuspended))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrConflict
+		}
+		if err != nil {
+			return err
+		}
+
+		t = models.Transactions{
+			SubscriptionID:  id,
+			TransactionDate: at,
+			Amount:          sub.BillingAmount,
+			Currency:        sub.Currency,
+			Status:          models.TransactionPending,
+			BillingPeriod:   sub.DunningPeriod,
+		}
+		err = tx.QueryRowContext(ctx, `
+			SELECT COALESCE(MAX(attempt), 0) + 1 FROM transactions WHERE subscription_id=$1 AND billing_period=$2`,
+			id, sub.DunningPeriod).Scan(&t.Attempt)
+		if err != nil {
+			return err
+		}
+		return insertCharge(ctx, tx, &t, now)
+	})
+	return t, err
+}
+
+// insertCharge inserts a pending charge made by the billing engine
+func insertCharge(ctx context.Context, tx *sql.Tx, t *models.Transactions, now time.Time) error {
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO transactions (subscription_id, transaction_date, amount, currency, status, billing_period, attempt, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, version, created_at, updated_at`,
+		t.SubscriptionID, t.TransactionDate, t.Amount, t.Currency, t.Status, t.BillingPeriod, t.Attempt, now, now).Scan(&t.ID, &t.Version, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return constraintError(err, false)
+	}
+	return recordTransactionEvent(ctx, tx, "", *t)
+}
+
+// transactions
+
+const transactionColumns = "id, subscription_id, transaction_date, amount, currency, status, billing_period, attempt, version, created_at, updated_at"
+
+func scanTransaction(row scanner) (models.Transactions, error) {
+	var t models.Transactions
+	err := row.Scan(&t.ID, &t.SubscriptionID, &t.TransactionDate, &t.Amount, &t.Currency, &t.Status, &t.BillingPeriod, &t.Attempt, &t.Version, &t.CreatedAt, &t.UpdatedAt)
+	return t, err
+}
+
+// PostgresTransactionStore is a TransactionStore backed by the transactions table
+type PostgresTransactionStore struct {
+	db *sql.DB
+}
+
+func (s *PostgresTransactionStore) List(ctx context.Context, f TransactionFilter, page Page) ([]models.Transactions, *Cursor, error) {
+	w := f.where()
+	suffix, sortBy, err := pageClause(w, transactionSorts, page)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT "+transactionColumns+" FROM transactions"+w.String()+suffix, w.args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var transactions []models.Transactions
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		transactions = append(transactions, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	transactions, next := finishPage(transactions, sortBy, page, func(t models.Transactions) int { return t.ID })
+	return transactions, next, nil
+}
+
+func (s *PostgresTransactionStore) Get(ctx context.Context, id int) (models.Transactions, error) {
+	t, err := scanTransaction(s.db.QueryRowContext(ctx, "SELECT "+transactionColumns+" FROM transactions WHERE id=$1", id))
+	return t, notFound(err)
+}
+
+func (s *PostgresTransactionStore) Create(ctx context.Context, t *models.Transactions) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		// the foreign key still accepts a soft-deleted subscription
+		if err := requireSubscriptionCurrency(ctx, tx, t); err != nil {
+			return err
+		}
+		now := time.Now()
+		t.BillingPeriod, t.Attempt = nil, 0
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO transactions (subscription_id, transaction_date, amount, currency, status, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id, version, created_at, updated_at`,
+			t.SubscriptionID, t.TransactionDate, t.Amount, t.Currency, t.Status, now, now).Scan(&t.ID, &t.Version, &t.CreatedAt, &t.UpdatedAt)
+		if err != nil {
+			return constraintError(err, false)
+		}
+		return recordTransactionEvent(ctx, tx, "", *t)
+	})
+}
+
+// lockTransaction loads the transaction for the rest of tx and enforces a pinned version
+func lockTransaction(ctx context.Context, tx *sql.Tx, id, version int) (models.Transactions, error) {
+	t, err := scanTransaction(tx.QueryRowContext(ctx, "SELECT "+transactionColumns+" FROM transactions WHERE id=$1 FOR UPDATE", id))
+	if err != nil {
+		return t, notFound(err)
+	}
+	if version != 0 && version != t.Version {
+		return t, ErrVersionMismatch
+	}
+	return t, nil
+}
+
+// recordTransactionEvent logs the move of t from status from, if its status moved at all
+func recordTransactionEvent(ctx context.Context, tx *sql.Tx, from string, t models.Transactions) error {
+	if from == t.Status {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO transaction_events (transaction_id, from_status, to_status, created_at)
+		VALUES ($1, $2, $3, $4)`, t.ID, from, t.Status, t.UpdatedAt)
+	return err
+}
+
+// settleCharge applies the outcome of a billing charge that just left pending to
+// its subscription's dunning state, under the policy of the subscription's partner
+func settleCharge(ctx context.Context, tx *sql.Tx, from string, t models.Transactions) error {
+	if t.BillingPeriod == nil || from != models.TransactionPending || t.Status == models.TransactionPending {
+		return nil
+	}
+	sub, err := scanSubscription(tx.QueryRowContext(ctx, "SELECT "+subscriptionColumns+" FROM subscriptions WHERE id=$1 FOR UPDATE", t.SubscriptionID))
+	if err != nil {
+		return err
+	}
+	var schedule pq.StringArray
+	var suspendAfter int
+	err = tx.QueryRowContext(ctx, "SELECT retry_schedule, suspend_after FROM partners WHERE id=$1", sub.PartnerID).Scan(&schedule, &suspendAfter)
+	if err != nil {
+		return err
+	}
+	retries, err := models.ParseRetrySchedule(schedule)
+	if err != nil {
+		return err
+	}
+
+	change := models.ChargeSettled(&sub, t, retries, suspendAfter, t.UpdatedAt)
+	_, err = tx.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET status=$1, dunning_state=$2, failed_attempts=$3, next_attempt_at=$4, dunning_period=$5, updated_at=$6, version=version+1
+		WHERE id=$7`,
+		sub.Status, sub.DunningState, sub.FailedAttempts, sub.NextAttemptAt, sub.DunningPeriod, t.UpdatedAt, sub.ID)
+	if err != nil || change == nil {
+		return err
+	}
+	change.CreatedAt = t.UpdatedAt
+	return insertStatusChange(ctx, tx, change)
+}
+
+func (s *PostgresTransactionStore) Update(ctx context.Context, t *models.Transactions) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		current, err := lockTransaction(ctx, tx, t.ID, t.Version)
+		if err != nil {
+			return err
+		}
+		if err := models.CheckTransactionChange(current, *t); err != nil {
+			return err
+		}
+		if err := requireSubscriptionCurrency(ctx, tx, t); err != nil {
+			return err
+		}
+		updated, err := scanTransaction(tx.QueryRowContext(ctx, `
+			UPDATE transactions
+			SET subscription_id=$1, transaction_date=$2, amount=$3, currency=$4, status=$5, updated_at=$6, version=version+1
+			WHERE id=$7
+			RETURNING `+transactionColumns,
+			t.SubscriptionID, t.TransactionDate, t.Amount, t.Currency, t.Status, time.Now(), t.ID))
+		if err != nil {
+			return constraintError(err, false)
+		}
+		*t = updated
+		if err := recordTransactionEvent(ctx, tx, current.Status, updated); err != nil {
+			return err
+		}
+		return settleCharge(ctx, tx, current.Status, updated)
+	})
+}
+
+func (s *PostgresTransactionStore) Patch(ctx context.Context, t *models.Transactions, columns []string) error {
+	query, args, err := patchQuery("transactions", transactionPatchColumns, *t, t.ID, t.Version, columns, transactionColumns)
+	if err != nil {
+		return err
+	}
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		current, err := lockTransaction(ctx, tx, t.ID, t.Version)
+		if err != nil {
+			return err
+		}
+		next := current
+		if err := applyPatch(transactionPatchColumns, &next, *t, columns); err != nil {
+			return err
+		}
+		if err := models.CheckTransactionChange(current, next); err != nil {
+			return err
+		}
+		if err := requireSubscriptionCurrency(ctx, tx, &next); err != nil {
+			return err
+		}
+		updated, err := scanTransaction(tx.QueryRowContext(ctx, query, args...))
+		if err != nil {
+			return constraintError(err, false)
+		}
+		*t = updated
+		if err := recordTransactionEvent(ctx, tx, current.Status, updated); err != nil {
+			return err
+		}
+		return settleCharge(ctx, tx, current.Status, updated)
+	})
+}
+
+func (s *PostgresTransactionStore) Delete(ctx context.Context, id, version int) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		current, err := lockTransaction(ctx, tx, id, version)
+		if err != nil {
+			return err
+		}
+		if models.TransactionSettled(current.Status) {
+			return fmt.Errorf("%w: transaction is %s", ErrConflict, current.Status)
+		}
+		if current.BillingPeriod != nil {
+			return fmt.Errorf("%w: transaction is a billing charge", ErrConflict)
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM transactions WHERE id=$1", id)
+		return constraintError(err, true)
+	})
+}
+
+func (s *PostgresTransactionStore) Events(ctx context.Context, id int) ([]models.TransactionEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, transaction_id, from_status, to_status, created_at
+		FROM transaction_events WHERE transaction_id=$1 ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.TransactionEvent{}
+	for rows.Next() {
+		var e models.TransactionEvent
+		if err := rows.Scan(&e.ID, &e.TransactionID, &e.FromStatus, &e.ToStatus, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// api keys
+
+const apiKeyColumns = "id, partner_id, name, prefix, key_hash, created_at, last_used_at, revoked_at"
+
+func scanAPIKey(row scanner) (models.APIKey, error) {
+	var k models.APIKey
+	err := row.Scan(&k.ID, &k.PartnerID, &k.Name, &k.Prefix, &k.KeyHash, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+	return k, err
+}
+
+// PostgresAPIKeyStore is an APIKeyStore backed by the api_keys table
+type PostgresAPIKeyStore struct {
+	db *sql.DB
+}
+
+func (s *PostgresAPIKeyStore) Create(ctx context.Context, k *models.APIKey) error {
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO api_keys (partner_id, name, prefix, key_hash, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		k.PartnerID, k.Name, k.Prefix, k.KeyHash, time.Now()).Scan(&k.ID, &k.CreatedAt)
+	return constraintError(err, false)
+}
+
+func (s *PostgresAPIKeyStore) ListByPartner(ctx context.Context, partnerID int) ([]models.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE partner_id=$1 ORDER BY id", partnerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *PostgresAPIKeyStore) GetByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	k, err := scanAPIKey(s.db.QueryRowContext(ctx, `
+		SELECT `+apiKeyColumns+` FROM api_keys
+		WHERE key_hash=$1 AND partner_id IN (SELECT id FROM partners WHERE deleted_at IS NULL)`, hash))
+	return k, notFound(err)
+}
+
+func (s *PostgresAPIKeyStore) Get(ctx context.Context, id int) (models.APIKey, error) {
+	k, err := scanAPIKey(s.db.QueryRowContext(ctx, `
+		SELECT `+apiKeyColumns+` FROM api_keys
+		WHERE id=$1 AND partner_id IN (SELECT id FROM partners WHERE deleted_at IS NULL)`, id))
+	return k, notFound(err)
+}
+
+func (s *PostgresAPIKeyStore) MarkUsed(ctx context.Context, id int, at time.Time) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at=$1 WHERE id=$2", at, id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func (s *PostgresAPIKeyStore) Revoke(ctx context.Context, partnerID, id int) error {
+	res, err := s.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at=$1 WHERE id=$2 AND partner_id=$3 AND revoked_at IS NULL", time.Now(), id, partnerID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+// idempotency keys
+
+// PostgresIdempotencyStore is an IdempotencyStore backed by the idempotency_keys table
+type PostgresIdempotencyStore struct {
+	db *sql.DB
+}
+
+func (s *PostgresIdempotencyStore) Reserve(ctx context.Context, k *models.IdempotencyKey) error {
+	k.CreatedAt = time.Now()
+	// an expired key is taken over as if it had never been used
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (scope, key, request_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (scope, key) DO UPDATE
+		SET request_hash=EXCLUDED.request_hash, response_status=0, response_header=NULL, response_body=NULL,
+			created_at=EXCLUDED.created_at, expires_at=EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= EXCLUDED.created_at`,
+		k.Scope, k.Key, k.RequestHash, k.CreatedAt, k.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	if err := checkAffected(res); err != nil {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *PostgresIdempotencyStore) Get(ctx context.Context, scope, key string) (models.IdempotencyKey, error) {
+	k := models.IdempotencyKey{Scope: scope, Key: key}
+	var header []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT request_hash, response_status, response_header, response_body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE scope=$1 AND key=$2 AND expires_at > $3`, scope, key, time.Now()).Scan(
+		&k.RequestHash, &k.ResponseStatus, &header, &k.ResponseBody, &k.CreatedAt, &k.ExpiresAt)
+	if err != nil {
+		return k, notFound(err)
+	}
+	if header != nil {
+		if err := json.Unmarshal(header, &k.ResponseHeader); err != nil {
+			return k, err
+		}
+	}
+	return k, nil
+}
+
+func (s *PostgresIdempotencyStore) Complete(ctx context.Context, k *models.IdempotencyKey) error {
+	header, err := json.Marshal(k.ResponseHeader)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE idempotency_keys SET response_status=$1, response_header=$2, response_body=$3
+		WHERE scope=$4 AND key=$5`,
+		k.ResponseStatus, header, k.ResponseBody, k.Scope, k.Key)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func (s *PostgresIdempotencyStore) Release(ctx context.Context, scope, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE scope=$1 AND key=$2 AND response_status=0", scope, key)
+	return err
+}
+
+func (s *PostgresIdempotencyStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= $1", now)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,35 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// where accumulates AND-ed SQL conditions with numbered placeholders.
+// Each "?" in a condition takes the next argument and is rewritten to $n
+type where struct {
+	conds []string
+	args  []any
+}
+
+// add appends a condition and its arguments
+func (w *where) add(cond string, args ...any) {
+	for _, arg := range args {
+		w.args = append(w.args, arg)
+		cond = strings.Replace(cond, "?", fmt.Sprintf("$%d", len(w.args)), 1)
+	}
+	w.conds = append(w.conds, cond)
+}
+
+// addRaw appends a condition that takes no argument
+func (w *where) addRaw(cond string) {
+	w.conds = append(w.conds, cond)
+}
+
+// String renders the clause including the WHERE keyword, or "" when empty
+func (w *where) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
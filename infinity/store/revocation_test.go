@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// testRedisEnv names a Redis server the revocation tests may write to.
+// Unset, they run against the in-memory list only
+const testRedisEnv = "TEST_REDIS_URL"
+
+// eachRevocationList runs fn against the in-memory list and, when TEST_REDIS_URL is set, the Redis one
+func eachRevocationList(t *testing.T, fn func(t *testing.T, l RevocationList)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, NewMemoryRevocationList())
+	})
+	t.Run("redis", func(t *testing.T) {
+		url := os.Getenv(testRedisEnv)
+		if url == "" {
+			t.Skip(testRedisEnv + " is not set")
+		}
+		l, err := NewRedisRevocationList(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		fn(t, l)
+	})
+}
+
+func TestRevocationList(t *testing.T) {
+	eachRevocationList(t, func(t *testing.T, l RevocationList) {
+		ctx := context.Background()
+		// IDs are unique per run, so a shared Redis needn't be emptied first
+		prefix := "test:" + time.Now().Format(time.RFC3339Nano) + ":"
+
+		if revoked, err := l.IsRevoked(ctx, prefix+"live"); err != nil || revoked {
+			t.Fatalf("got %v, %v for an ID never revoked", revoked, err)
+		}
+		fresh, err := l.Revoke(ctx, prefix+"live", time.Now().Add(time.Hour))
+		if err != nil || !fresh {
+			t.Fatalf("got %v, %v revoking a new ID, want it fresh", fresh, err)
+		}
+		if revoked, err := l.IsRevoked(ctx, prefix+"live"); err != nil || !revoked {
+			t.Errorf("got %v, %v after revoking, want it revoked", revoked, err)
+		}
+		// the second revocation loses, which is what spends a refresh token once
+		if fresh, err := l.Revoke(ctx, prefix+"live", time.Now().Add(time.Hour)); err != nil || fresh {
+			t.Errorf("got %v, %v revoking again, want it not fresh", fresh, err)
+		}
+
+		// an ID whose token already expired is still held long enough to catch a racing reuse
+		if fresh, err := l.Revoke(ctx, prefix+"expired", time.Now().Add(-time.Hour)); err != nil || !fresh {
+			t.Fatalf("got %v, %v revoking an expired ID", fresh, err)
+		}
+		if fresh, err := l.Revoke(ctx, prefix+"expired", time.Now()); err != nil || fresh {
+			t.Errorf("got %v, %v reusing an expired ID, want it caught", fresh, err)
+		}
+	})
+}
+
+func TestMemoryRevocationListForgetsExpiredIDs(t *testing.T) {
+	ctx := context.Background()
+	l := NewMemoryRevocationList()
+	l.revoked["old"] = time.Now().Add(-time.Second)
+
+	if revoked, _ := l.IsRevoked(ctx, "old"); revoked {
+		t.Error("an expired entry still counts as revoked")
+	}
+	if fresh, _ := l.Revoke(ctx, "new", time.Now().Add(time.Hour)); !fresh {
+		t.Fatal("revoking a new ID wasn't fresh")
+	}
+	if _, ok := l.revoked["old"]; ok {
+		t.Error("revoking didn't purge the expired entry")
+	}
+}
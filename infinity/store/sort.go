@@ -0,0 +1,246 @@
+package store
+
+import (
+	"fmt"
+	"infinity/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sort orders a list by one whitelisted field; ties are broken by ID in the same direction
+type Sort struct {
+	Field string
+	Desc  bool
+}
+
+// String renders the sort the way clients write it, e.g. "-start_date"
+func (s Sort) String() string {
+	field := s.Field
+	if field == "" {
+		field = "id"
+	}
+	if s.Desc {
+		return "-" + field
+	}
+	return field
+}
+
+// Cursor is the keyset position of the last row on a page.
+// Value is the row's sort field rendered by the store, empty when sorting by ID
+type Cursor struct {
+	Value string
+	ID    int
+}
+
+// sortField describes one sortable column and how to read it from a model
+type sortField[T any] struct {
+	column string
+	// value returns an int, int64, float64, string or time.Time
+	value func(T) any
+}
+
+// Sortable fields per resource besides id; anything else is rejected by the handlers
+var (
+	SubscriptionSortFields = []string{"subscription_date", "start_date", "end_date", "billing_amount", "created_at"}
+	TransactionSortFields  = []string{"transaction_date", "amount", "created_at"}
+)
+
+var partnerSorts = map[string]sortField[models.Partner]{
+	"id": {"id", func(p models.Partner) any { return p.ID }},
+}
+
+var subscriptionSorts = map[string]sortField[models.Subscriptions]{
+	"id":                {"id", func(s models.Subscriptions) any { return s.ID }},
+	"subscription_date": {"subscription_date", func(s models.Subscriptions) any { return s.SubscriptionDate }},
+	"start_date":        {"start_date", func(s models.Subscriptions) any { return s.StartDate }},
+	"end_date":          {"end_date", func(s models.Subscriptions) any { return s.EndDate }},
+	"billing_amount":    {"billing_amount", func(s models.Subscriptions) any { return int64(s.BillingAmount) }},
+	"created_at":        {"created_at", func(s models.Subscriptions) any { return s.CreatedAt }},
+}
+
+var transactionSorts = map[string]sortField[models.Transactions]{
+	"id":               {"id", func(t models.Transactions) any { return t.ID }},
+	"transaction_date": {"transaction_date", func(t models.Transactions) any { return t.TransactionDate }},
+	"amount":           {"amount", func(t models.Transactions) any { return int64(t.Amount) }},
+	"created_at":       {"created_at", func(t models.Transactions) any { return t.CreatedAt }},
+}
+
+// lookupSort resolves the page's sort field, defaulting to ID
+func lookupSort[T any](fields map[string]sortField[T], s Sort) (sortField[T], error) {
+	name := s.Field
+	if name == "" {
+		name = "id"
+	}
+	f, ok := fields[name]
+	if !ok {
+		return f, fmt.Errorf("store: cannot sort by %q", name)
+	}
+	return f, nil
+}
+
+// formatValue renders a sort value for a cursor
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// parseValue reads a cursor value back into the type of like
+func parseValue(like any, s string) (any, error) {
+	switch like.(type) {
+	case time.Time:
+		return time.Parse(time.RFC3339Nano, s)
+	case float64:
+		return strconv.ParseFloat(s, 64)
+	case int:
+		return strconv.Atoi(s)
+	case int64:
+		return strconv.ParseInt(s, 10, 64)
+	default:
+		return s, nil
+	}
+}
+
+// compareValues orders two sort values of the same type
+func compareValues(a, b any) int {
+	switch a := a.(type) {
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case int:
+		return a - b.(int)
+	case int64:
+		b := b.(int64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	default:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
+
+// cursorFor returns the cursor that continues after row
+func cursorFor[T any](f sortField[T], row T, id int) *Cursor {
+	c := &Cursor{ID: id}
+	if f.column != "id" {
+		c.Value = formatValue(f.value(row))
+	}
+	return c
+}
+
+// pageClause narrows w to the rows after the page's cursor and returns the
+// ORDER BY and LIMIT suffix; one extra row is fetched to detect a next page
+func pageClause[T any](w *where, fields map[string]sortField[T], page Page) (string, sortField[T], error) {
+	f, err := lookupSort(fields, page.Sort)
+	if err != nil {
+		return "", f, err
+	}
+
+	op, dir := ">", "ASC"
+	if page.Sort.Desc {
+		op, dir = "<", "DESC"
+	}
+
+	if page.After != nil {
+		if f.column == "id" {
+			w.add("id "+op+" ?", page.After.ID)
+		} else {
+			var zero T
+			v, err := parseValue(f.value(zero), page.After.Value)
+			if err != nil {
+				return "", f, fmt.Errorf("store: invalid cursor: %v", err)
+			}
+			w.add(fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", f.column, op), v, v, page.After.ID)
+		}
+	}
+
+	order := fmt.Sprintf(" ORDER BY %s %s", f.column, dir)
+	if f.column != "id" {
+		order += ", id " + dir
+	}
+	return fmt.Sprintf("%s LIMIT %d", order, page.Limit+1), f, nil
+}
+
+// finishPage drops the extra row fetched by pageClause and returns the cursor
+// for the next page, or nil when this is the last one
+func finishPage[T any](rows []T, f sortField[T], page Page, id func(T) int) ([]T, *Cursor) {
+	if len(rows) <= page.Limit {
+		return rows, nil
+	}
+	rows = rows[:page.Limit]
+	last := rows[len(rows)-1]
+	return rows, cursorFor(f, last, id(last))
+}
+
+// memoryPage sorts the matching rows, skips past the cursor and cuts one page,
+// mirroring what pageClause does in SQL
+func memoryPage[T any](rows []T, fields map[string]sortField[T], page Page, id func(T) int) ([]T, *Cursor, error) {
+	f, err := lookupSort(fields, page.Sort)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	less := func(a, b T) int {
+		if c := compareValues(f.value(a), f.value(b)); c != 0 {
+			return c
+		}
+		return id(a) - id(b)
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if page.Sort.Desc {
+			return less(rows[i], rows[j]) > 0
+		}
+		return less(rows[i], rows[j]) < 0
+	})
+
+	if page.After != nil {
+		var zero T
+		var after any = page.After.ID
+		if f.column != "id" {
+			if after, err = parseValue(f.value(zero), page.After.Value); err != nil {
+				return nil, nil, fmt.Errorf("store: invalid cursor: %v", err)
+			}
+		}
+		start := len(rows)
+		for i, row := range rows {
+			c := compareValues(f.value(row), after)
+			if f.column != "id" && c == 0 {
+				c = id(row) - page.After.ID
+			}
+			if (page.Sort.Desc && c < 0) || (!page.Sort.Desc && c > 0) {
+				start = i
+				break
+			}
+		}
+		rows = rows[start:]
+	}
+
+	if len(rows) > page.Limit+1 {
+		rows = rows[:page.Limit+1]
+	}
+	rows, next := finishPage(rows, f, page, id)
+	return rows, next, nil
+}
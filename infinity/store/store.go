@@ -0,0 +1,264 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"infinity/models"
+	"time"
+)
+
+// Errors returned by every store implementation in place of driver errors
+var (
+	// ErrNotFound is returned when the requested row does not exist
+	ErrNotFound = errors.New("store: not found")
+	// ErrConflict is returned when a write would duplicate a unique value or
+	// delete a row other rows still reference
+	ErrConflict = errors.New("store: conflict")
+	// ErrInvalidReference is returned when a write points at a row that does not exist
+	ErrInvalidReference = errors.New("store: invalid reference")
+	// ErrVersionMismatch is returned when a write is pinned to a version the row no longer has
+	ErrVersionMismatch = errors.New("store: version mismatch")
+)
+
+// checkCurrency rejects a transaction that isn't in its subscription's currency
+func checkCurrency(t *models.Transactions, subscriptionCurrency string) error {
+	if t.Currency == subscriptionCurrency {
+		return nil
+	}
+	return &models.ValidationError{Fields: []models.FieldError{{
+		Field:   "currency",
+		Rule:    "currency",
+		Message: "must match the subscription's currency " + subscriptionCurrency,
+	}}}
+}
+
+// Writes to partners, subscriptions and transactions are versioned: every write bumps
+// the row's version, and a write that passes a non-zero version only succeeds while
+// the stored row still has it. Update and Patch take it from the model's Version field
+
+// Partners and subscriptions are soft-deleted: Delete stamps deleted_at and the row
+// drops out of Get, List and every write until Restore clears it again. GetWithDeleted
+// and the IncludeDeleted filters still reach deleted rows
+
+// Page selects one window of a keyset-paginated list
+type Page struct {
+	// After is the position of the last row on the previous page; nil starts from the beginning
+	After *Cursor
+	Limit int
+	Sort  Sort
+}
+
+// PartnerFilter narrows PartnerStore.List
+type PartnerFilter struct {
+	IncludeDeleted bool
+}
+
+// PartnerStore persists models.Partner rows
+type PartnerStore interface {
+	// List returns up to page.Limit rows and the cursor of the next page, nil on the last one
+	List(ctx context.Context, f PartnerFilter, page Page) ([]models.Partner, *Cursor, error)
+	Get(ctx context.Context, id int) (models.Partner, error)
+	// GetWithDeleted is Get that also finds soft-deleted rows
+	GetWithDeleted(ctx context.Context, id int) (models.Partner, error)
+	// Create inserts p and fills in its ID, version and timestamps
+	Create(ctx context.Context, p *models.Partner) error
+	// Update overwrites the row with p.ID and refreshes p from the stored row
+	Update(ctx context.Context, p *models.Partner) error
+	// Patch writes only the named columns of p to the row with p.ID and refreshes p from the stored row
+	Patch(ctx context.Context, p *models.Partner, columns []string) error
+	// Delete soft-deletes the row, provided it still has version when version is non-zero.
+	// It fails with ErrConflict while the partner has subscriptions that are neither
+	// cancelled nor expired, unless cascade soft-deletes its subscriptions along with it
+	Delete(ctx context.Context, id, version int, cascade bool) error
+	// Restore undeletes the row and returns it, along with the subscriptions a cascading
+	// Delete took with it; subscriptions deleted before the partner stay deleted.
+	// Restoring a live row leaves it unchanged
+	Restore(ctx context.Context, id int) (models.Partner, error)
+}
+
+// SubscriptionFilter narrows SubscriptionStore.List; zero values match everything.
+// Date ranges include their From bound and exclude their To bound
+type SubscriptionFilter struct {
+	PartnerID      int
+	Status         string
+	BillingCycle   string
+	CustomerMSISDN string
+	StartFrom      time.Time
+	StartTo        time.Time
+	EndFrom        time.Time
+	EndTo          time.Time
+	IncludeDeleted bool
+	// DueBy keeps the subscriptions a billing run at DueBy has work for: active ones
+	// whose next billing date has come, and active or suspended ones with a dunning
+	// retry due
+	DueBy time.Time
+	// EndedBy keeps the subscriptions whose end date has passed by EndedBy and that
+	// are neither cancelled nor expired
+	EndedBy time.Time
+}
+
+// TransactionFilter narrows TransactionStore.List; zero values match everything.
+// PartnerID matches transactions through their subscription's partner,
+// the amount bounds are inclusive minor units of Currency and the date range excludes DateTo
+type TransactionFilter struct {
+	PartnerID      int
+	SubscriptionID int
+	Status         string
+	Currency       string
+	AmountMin      *models.Money
+	AmountMax      *models.Money
+	DateFrom       time.Time
+	DateTo         time.Time
+}
+
+// SubscriptionStore persists models.Subscriptions rows
+type SubscriptionStore interface {
+	List(ctx context.Context, f SubscriptionFilter, page Page) ([]models.Subscriptions, *Cursor, error)
+	Get(ctx context.Context, id int) (models.Subscriptions, error)
+	// GetWithDeleted is Get that also finds soft-deleted rows
+	GetWithDeleted(ctx context.Context, id int) (models.Subscriptions, error)
+	// Create inserts s; its partner, and its plan if it names one, must exist and not be deleted
+	// Its first billing date is the start date, or the end of its trial; only Bill moves it afterwards
+	Create(ctx context.Context, s *models.Subscriptions) error
+	// Update and Patch leave the status alone; it only changes through Transition.
+	// A new start date or billing cycle moves the next billing date with it, to the
+	// first billing date or one cycle after the last period charged
+	Update(ctx context.Context, s *models.Subscriptions) error
+	// Patch writes only the named columns of s to the row with s.ID and refreshes s from the stored row
+	Patch(ctx context.Context, s *models.Subscriptions, columns []string) error
+	// Delete soft-deletes the row, provided it still has version when version is non-zero
+	Delete(ctx context.Context, id, version int) error
+	// Restore undeletes the row and returns it; a subscription of a deleted partner
+	// can't be restored and fails with ErrInvalidReference
+	Restore(ctx context.Context, id int) (models.Subscriptions, error)
+	// Transition moves the subscription from change.FromStatus to change.ToStatus and
+	// appends change to its status history, provided it still has version when version
+	// is non-zero. It fails with ErrConflict when the status is no longer change.FromStatus.
+	// A move that models.ClearsDunning resets the dunning state to current
+	Transition(ctx context.Context, id, version int, change *models.SubscriptionStatusChange) (models.Subscriptions, error)
+	// History lists the status changes of the subscription, oldest first
+	History(ctx context.Context, id int) ([]models.SubscriptionStatusChange, error)
+	// Bill charges the subscription for the billing period starting at period: it
+	// creates a pending transaction for its billing amount dated at and moves its
+	// next billing date on to next. It fails with ErrConflict unless the subscription
+	// is live, active and due by period, so a period is never charged twice
+	Bill(ctx context.Context, id int, period, next, at time.Time) (models.Transactions, error)
+	// BillRenewal charges an auto-renewing subscription for the first period of its
+	// next term, which starts at endDate and ends at next: it creates a pending
+	// transaction for that period dated at and moves its next billing date on to
+	// next. It fails with ErrConflict unless the subscription is live and active, its
+	// end date is still endDate and the period hasn't been charged yet
+	BillRenewal(ctx context.Context, id int, endDate, next, at time.Time) (models.Transactions, error)
+	// Renew extends the end date of an auto-renewing subscription from endDate to
+	// next. It fails with ErrConflict unless the subscription is live and active, its
+	// end date is still endDate and a charge for the period starting at endDate succeeded
+	Renew(ctx context.Context, id int, endDate, next time.Time) (models.Subscriptions, error)
+	// Retry charges the subscription again for its dunning period, dated at, as
+	// the next attempt. It fails with ErrConflict unless the subscription is live,
+	// active or suspended, and has a retry due by at
+	Retry(ctx context.Context, id int, at time.Time) (models.Transactions, error)
+}
+
+// TransactionStore persists models.Transactions rows. A transaction is always in
+// its subscription's currency; writes in another fail with a *models.ValidationError.
+// Writes follow the transaction lifecycle checked by models.CheckTransactionChange
+// against the stored row, and every status a transaction takes is logged as an event.
+// When a billing charge succeeds or fails, its subscription's dunning state
+// follows in the same write through models.ChargeSettled
+type TransactionStore interface {
+	List(ctx context.Context, f TransactionFilter, page Page) ([]models.Transactions, *Cursor, error)
+	Get(ctx context.Context, id int) (models.Transactions, error)
+	Create(ctx context.Context, t *models.Transactions) error
+	Update(ctx context.Context, t *models.Transactions) error
+	// Patch writes only the named columns of t to the row with t.ID and refreshes t from the stored row
+	Patch(ctx context.Context, t *models.Transactions, columns []string) error
+	// Delete removes a pending transaction; a settled one, or a charge of a billing
+	// period, fails with ErrConflict
+	Delete(ctx context.Context, id, version int) error
+	// Events lists the status timeline of the transaction, oldest first
+	Events(ctx context.Context, id int) ([]models.TransactionEvent, error)
+}
+
+// PlanStore persists the plan catalog of each partner. Plans are soft-deleted:
+// a deleted plan leaves the catalog, but the subscriptions sold under it keep
+// pointing at it
+type PlanStore interface {
+	// ListByPartner returns the partner's live plans in ID order
+	ListByPartner(ctx context.Context, partnerID int) ([]models.Plan, error)
+	Get(ctx context.Context, id int) (models.Plan, error)
+	// Create inserts p; its partner must exist and not be deleted, and no other live
+	// plan of the partner may have its name
+	Create(ctx context.Context, p *models.Plan) error
+	// Update overwrites the row with p.ID, except its partner, and refreshes p from the stored row
+	Update(ctx context.Context, p *models.Plan) error
+	// Patch writes only the named columns of p to the row with p.ID and refreshes p from the stored row
+	Patch(ctx context.Context, p *models.Plan, columns []string) error
+	// Delete soft-deletes the row, provided it still has version when version is non-zero
+	Delete(ctx context.Context, id, version int) error
+}
+
+// APIKeyStore persists the hashed API keys partners exchange for tokens
+type APIKeyStore interface {
+	Create(ctx context.Context, k *models.APIKey) error
+	ListByPartner(ctx context.Context, partnerID int) ([]models.APIKey, error)
+	// GetByHash looks a key up by its hash, including revoked keys
+	GetByHash(ctx context.Context, hash string) (models.APIKey, error)
+	// Get looks a key up by its ID, including revoked keys
+	Get(ctx context.Context, id int) (models.APIKey, error)
+	MarkUsed(ctx context.Context, id int, at time.Time) error
+	// Revoke marks the partner's key as revoked; revoking twice is ErrNotFound
+	Revoke(ctx context.Context, partnerID, id int) error
+}
+
+// IdempotencyStore keeps the Idempotency-Keys of create requests until they
+// expire. An expired key is treated as absent and can be reserved again
+type IdempotencyStore interface {
+	// Reserve records k before its request runs. It fails with ErrConflict while an
+	// unexpired key with the same scope and key exists
+	Reserve(ctx context.Context, k *models.IdempotencyKey) error
+	// Get returns the unexpired key with the scope and key
+	Get(ctx context.Context, scope, key string) (models.IdempotencyKey, error)
+	// Complete stores the response of the reserved key
+	Complete(ctx context.Context, k *models.IdempotencyKey) error
+	// Release drops a reservation that has no response yet so a retry can run the request again
+	Release(ctx context.Context, scope, key string) error
+	// DeleteExpired removes the keys that expired by now and reports how many there were
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+// Stores bundles every store the handlers depend on
+type Stores struct {
+	Partners        PartnerStore
+	Plans           PlanStore
+	Subscriptions   SubscriptionStore
+	Transactions    TransactionStore
+	APIKeys         APIKeyStore
+	IdempotencyKeys IdempotencyStore
+}
+
+// NewPostgres returns stores backed by the shared connection pool
+func NewPostgres(db *sql.DB) Stores {
+	return Stores{
+		Partners:        &PostgresPartnerStore{db: db},
+		Plans:           &PostgresPlanStore{db: db},
+		Subscriptions:   &PostgresSubscriptionStore{db: db},
+		Transactions:    &PostgresTransactionStore{db: db},
+		APIKeys:         &PostgresAPIKeyStore{db: db},
+		IdempotencyKeys: &PostgresIdempotencyStore{db: db},
+	}
+}
+
+// NewMemory returns stores that keep everything in process memory.
+// They are safe for concurrent use and meant for tests and local runs
+func NewMemory() Stores {
+	m := newMemoryDB()
+	return Stores{
+		Partners:        &MemoryPartnerStore{m: m},
+		Plans:           &MemoryPlanStore{m: m},
+		Subscriptions:   &MemorySubscriptionStore{m: m},
+		Transactions:    &MemoryTransactionStore{m: m},
+		APIKeys:         &MemoryAPIKeyStore{m: m},
+		IdempotencyKeys: &MemoryIdempotencyStore{m: m},
+	}
+}
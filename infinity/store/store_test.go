@@ -0,0 +1,406 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"infinity/database"
+	"infinity/models"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDBEnv names a scratch Postgres database the store tests may empty at will.
+// Unset, they run against the memory stores only
+const testDBEnv = "TEST_DB_CONN_STR"
+
+var (
+	testDBOnce sync.Once
+	testDB     *sql.DB
+	testDBErr  error
+)
+
+// openTestDB connects to the scratch database and migrates it up, once per test
+// binary; it returns nil when no database is configured
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	connStr := os.Getenv(testDBEnv)
+	if connStr == "" {
+		return nil
+	}
+	testDBOnce.Do(func() {
+		testDB, testDBErr = database.Open(database.Config{ConnStr: connStr, MaxOpenConns: 5, MaxIdleConns: 5, ConnMaxLifetime: time.Minute})
+		if testDBErr == nil {
+			_, testDBErr = database.MigrateUp(context.Background(), testDB)
+		}
+	})
+	if testDBErr != nil {
+		t.Fatal(testDBErr)
+	}
+	return testDB
+}
+
+// eachStore runs fn against the memory stores and, when TEST_DB_CONN_STR is set,
+// against the Postgres stores over an emptied database, holding both to the same behaviour
+func eachStore(t *testing.T, fn func(t *testing.T, stores Stores)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, NewMemory())
+	})
+	t.Run("postgres", func(t *testing.T) {
+		db := openTestDB(t)
+		if db == nil {
+			t.Skip(testDBEnv + " is not set")
+		}
+		_, err := db.Exec(`TRUNCATE partners, plans, subscriptions, transactions, api_keys, idempotency_keys,
+			subscription_status_history, transaction_events RESTART IDENTITY CASCADE`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fn(t, NewPostgres(db))
+	})
+}
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func createPartner(t *testing.T, stores Stores, name string) models.Partner {
+	t.Helper()
+	p := models.Partner{Name: name, Email: name + "@example.com"}
+	p.SetDunningDefaults()
+	if err := stores.Partners.Create(context.Background(), &p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// createSubscription creates an active monthly KES subscription of the partner;
+// change, when given, adjusts it first
+func createSubscription(t *testing.T, stores Stores, partnerID int, change ...func(*models.Subscriptions)) models.Subscriptions {
+	t.Helper()
+	sub := models.Subscriptions{
+		PartnerID:        partnerID,
+		CustomerMSISDN:   "+254700000001",
+		SubscriptionDate: day(2026, 1, 1),
+		Status:           models.SubscriptionActive,
+		BillingAmount:    1050,
+		Currency:         "KES",
+		BillingCycle:     "monthly",
+		StartDate:        day(2026, 1, 1),
+		EndDate:          day(2027, 1, 1),
+	}
+	for _, c := range change {
+		c(&sub)
+	}
+	if err := stores.Subscriptions.Create(context.Background(), &sub); err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+func TestVersionChecks(t *testing.T) {
+	eachStore(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		p := createPartner(t, stores, "a")
+		sub := createSubscription(t, stores, p.ID)
+		if p.Version != 1 || sub.Version != 1 {
+			t.Fatalf("got versions %d and %d, want new rows at 1", p.Version, sub.Version)
+		}
+
+		writes := []struct {
+			name  string
+			write func(version int) error
+		}{
+			{"partner update", func(v int) error {
+				q := p
+				q.Version = v
+				return stores.Partners.Update(ctx, &q)
+			}},
+			{"partner patch", func(v int) error {
+				q := p
+				q.Version, q.Name = v, "b"
+				return stores.Partners.Patch(ctx, &q, []string{"name"})
+			}},
+			{"subscription update", func(v int) error {
+				s := sub
+				s.Version = v
+				return stores.Subscriptions.Update(ctx, &s)
+			}},
+			{"subscription patch", func(v int) error {
+				s := sub
+				s.Version, s.AutoRenew = v, true
+				return stores.Subscriptions.Patch(ctx, &s, []string{"auto_renew"})
+			}},
+			{"subscription transition", func(v int) error {
+				current, err := stores.Subscriptions.Get(ctx, sub.ID)
+				if err != nil {
+					return err
+				}
+				// move it back and forth so every call starts from active
+				to := models.SubscriptionSuspended
+				if current.Status == to {
+					to = models.SubscriptionActive
+				}
+				_, err = stores.Subscriptions.Transition(ctx, sub.ID, v, &models.SubscriptionStatusChange{FromStatus: current.Status, ToStatus: to, Actor: "test"})
+				return err
+			}},
+		}
+		for _, w := range writes {
+			t.Run(w.name, func(t *testing.T) {
+				if err := w.write(1000); !errors.Is(err, ErrVersionMismatch) {
+					t.Fatalf("a stale version got %v, want ErrVersionMismatch", err)
+				}
+				current := currentVersion(t, stores, w.name, p.ID, sub.ID)
+				if err := w.write(current); err != nil {
+					t.Fatalf("the current version got %v", err)
+				}
+				if got := currentVersion(t, stores, w.name, p.ID, sub.ID); got != current+1 {
+					t.Fatalf("got version %d after the write, want %d", got, current+1)
+				}
+				// without a version the write isn't pinned at all
+				if err := w.write(0); err != nil {
+					t.Fatalf("no version got %v", err)
+				}
+			})
+		}
+
+		t.Run("delete", func(t *testing.T) {
+			current := currentVersion(t, stores, "subscription", p.ID, sub.ID)
+			if err := stores.Subscriptions.Delete(ctx, sub.ID, current-1); !errors.Is(err, ErrVersionMismatch) {
+				t.Fatalf("a stale version got %v, want ErrVersionMismatch", err)
+			}
+			if err := stores.Subscriptions.Delete(ctx, sub.ID, current); err != nil {
+				t.Fatal(err)
+			}
+			// once the row is gone, pinning a version can't make it a mismatch
+			if err := stores.Subscriptions.Delete(ctx, sub.ID, current+1); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("got %v, want ErrNotFound", err)
+			}
+			current = currentVersion(t, stores, "partner", p.ID, sub.ID)
+			if err := stores.Partners.Delete(ctx, p.ID, current+1, false); !errors.Is(err, ErrVersionMismatch) {
+				t.Fatalf("a stale partner version got %v, want ErrVersionMismatch", err)
+			}
+			if err := stores.Partners.Delete(ctx, p.ID, current, false); err != nil {
+				t.Fatal(err)
+			}
+		})
+	})
+}
+
+// currentVersion reads the stored version of the partner or subscription a write named name touches
+func currentVersion(t *testing.T, stores Stores, name string, partnerID, subscriptionID int) int {
+	t.Helper()
+	ctx := context.Background()
+	if strings.HasPrefix(name, "partner") {
+		p, err := stores.Partners.Get(ctx, partnerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.Version
+	}
+	sub, err := stores.Subscriptions.Get(ctx, subscriptionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sub.Version
+}
+
+func TestSoftDeleteVisibility(t *testing.T) {
+	eachStore(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		p := createPartner(t, stores, "a")
+		sub := createSubscription(t, stores, p.ID)
+		if err := stores.Subscriptions.Delete(ctx, sub.ID, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := stores.Subscriptions.Get(ctx, sub.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get found a deleted subscription: %v", err)
+		}
+		if deleted, err := stores.Subscriptions.GetWithDeleted(ctx, sub.ID); err != nil || deleted.DeletedAt == nil {
+			t.Errorf("GetWithDeleted got %+v, %v; want the deleted row", deleted, err)
+		}
+		if subs, _, err := stores.Subscriptions.List(ctx, SubscriptionFilter{}, Page{Limit: 10}); err != nil || len(subs) != 0 {
+			t.Errorf("List got %d rows, %v; want none", len(subs), err)
+		}
+		if subs, _, err := stores.Subscriptions.List(ctx, SubscriptionFilter{IncludeDeleted: true}, Page{Limit: 10}); err != nil || len(subs) != 1 {
+			t.Errorf("List including deleted got %d rows, %v; want 1", len(subs), err)
+		}
+
+		// a deleted row refuses every write
+		patched := sub
+		patched.AutoRenew = true
+		if err := stores.Subscriptions.Patch(ctx, &patched, []string{"auto_renew"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Patch got %v, want ErrNotFound", err)
+		}
+		if err := stores.Subscriptions.Update(ctx, &patched); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Update got %v, want ErrNotFound", err)
+		}
+		change := &models.SubscriptionStatusChange{FromStatus: models.SubscriptionActive, ToStatus: models.SubscriptionSuspended, Actor: "test"}
+		if _, err := stores.Subscriptions.Transition(ctx, sub.ID, 0, change); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Transition got %v, want ErrNotFound", err)
+		}
+		if err := stores.Subscriptions.Delete(ctx, sub.ID, 0); !errors.Is(err, ErrNotFound) {
+			t.Errorf("a second Delete got %v, want ErrNotFound", err)
+		}
+		charge := models.Transactions{SubscriptionID: sub.ID, TransactionDate: day(2026, 1, 1), Amount: 1050, Currency: "KES", Status: models.TransactionPending}
+		if err := stores.Transactions.Create(ctx, &charge); !errors.Is(err, ErrInvalidReference) {
+			t.Errorf("a transaction of a deleted subscription got %v, want ErrInvalidReference", err)
+		}
+
+		restored, err := stores.Subscriptions.Restore(ctx, sub.ID)
+		if err != nil || restored.DeletedAt != nil {
+			t.Fatalf("Restore got %+v, %v", restored, err)
+		}
+		if _, err := stores.Subscriptions.Get(ctx, sub.ID); err != nil {
+			t.Errorf("Get after Restore got %v", err)
+		}
+		if again, err := stores.Subscriptions.Restore(ctx, sub.ID); err != nil || again.Version != restored.Version {
+			t.Errorf("restoring a live row got version %d, %v; want it unchanged at %d", again.Version, err, restored.Version)
+		}
+		if _, err := stores.Subscriptions.Restore(ctx, 1000); !errors.Is(err, ErrNotFound) {
+			t.Errorf("restoring a missing row got %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestCursorPaging(t *testing.T) {
+	eachStore(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		p := createPartner(t, stores, "a")
+		// amounts with ties, so the ID has to break them
+		for _, amount := range []models.Money{300, 100, 200, 100, 300, 100} {
+			createSubscription(t, stores, p.ID, func(s *models.Subscriptions) { s.BillingAmount = amount })
+		}
+
+		tests := []struct {
+			name string
+			sort Sort
+			want []int
+		}{
+			{"by id", Sort{}, []int{1, 2, 3, 4, 5, 6}},
+			{"by id descending", Sort{Desc: true}, []int{6, 5, 4, 3, 2, 1}},
+			{"by amount", Sort{Field: "billing_amount"}, []int{2, 4, 6, 3, 1, 5}},
+			{"by amount descending", Sort{Field: "billing_amount", Desc: true}, []int{5, 1, 3, 6, 4, 2}},
+		}
+		for _, tt := range tests {
+			for _, limit := range []int{1, 2, 4, 6, 10} {
+				t.Run(fmt.Sprintf("%s, %d a page", tt.name, limit), func(t *testing.T) {
+					var got []int
+					page := Page{Limit: limit, Sort: tt.sort}
+					for pages := 0; ; pages++ {
+						if pages > len(tt.want) {
+							t.Fatalf("paging didn't end after %d pages", pages)
+						}
+						subs, next, err := stores.Subscriptions.List(ctx, SubscriptionFilter{}, page)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if len(subs) > limit {
+							t.Fatalf("got %d rows, want at most %d", len(subs), limit)
+						}
+						for _, s := range subs {
+							got = append(got, s.ID)
+						}
+						if next == nil {
+							break
+						}
+						page.After = next
+					}
+					if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+						t.Errorf("got %v, want %v", got, tt.want)
+					}
+				})
+			}
+		}
+
+		t.Run("invalid cursor", func(t *testing.T) {
+			page := Page{Limit: 2, Sort: Sort{Field: "billing_amount"}, After: &Cursor{Value: "lots", ID: 1}}
+			if _, _, err := stores.Subscriptions.List(ctx, SubscriptionFilter{}, page); err == nil {
+				t.Error("a cursor that isn't an amount was accepted")
+			}
+		})
+		t.Run("unknown sort", func(t *testing.T) {
+			if _, _, err := stores.Subscriptions.List(ctx, SubscriptionFilter{}, Page{Limit: 2, Sort: Sort{Field: "customer_msisdn"}}); err == nil {
+				t.Error("an unknown sort field was accepted")
+			}
+		})
+	})
+}
+
+func TestPartnerDeleteAndRestore(t *testing.T) {
+	eachStore(t, func(t *testing.T, stores Stores) {
+		ctx := context.Background()
+		p := createPartner(t, stores, "a")
+		active := createSubscription(t, stores, p.ID)
+		cancelled := createSubscription(t, stores, p.ID)
+		change := &models.SubscriptionStatusChange{FromStatus: models.SubscriptionActive, ToStatus: models.SubscriptionCancelled, Actor: "test"}
+		if _, err := stores.Subscriptions.Transition(ctx, cancelled.ID, 0, change); err != nil {
+			t.Fatal(err)
+		}
+		// deleted on its own, before the partner
+		earlier := createSubscription(t, stores, p.ID)
+		if err := stores.Subscriptions.Delete(ctx, earlier.ID, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := stores.Partners.Delete(ctx, p.ID, 0, false); !errors.Is(err, ErrConflict) {
+			t.Fatalf("deleting a partner with an active subscription got %v, want ErrConflict", err)
+		}
+		if got, err := stores.Partners.Get(ctx, p.ID); err != nil || got.Version != p.Version {
+			t.Fatalf("the refused delete left %+v, %v; want the partner untouched", got, err)
+		}
+
+		if err := stores.Partners.Delete(ctx, p.ID, 0, true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stores.Partners.Get(ctx, p.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get found the deleted partner: %v", err)
+		}
+		if deleted, err := stores.Partners.GetWithDeleted(ctx, p.ID); err != nil || deleted.DeletedAt == nil {
+			t.Errorf("GetWithDeleted got %+v, %v; want the deleted row", deleted, err)
+		}
+		for _, id := range []int{active.ID, cancelled.ID} {
+			if _, err := stores.Subscriptions.Get(ctx, id); !errors.Is(err, ErrNotFound) {
+				t.Errorf("subscription %d survived the cascade: %v", id, err)
+			}
+		}
+		if _, err := stores.Subscriptions.Restore(ctx, active.ID); !errors.Is(err, ErrInvalidReference) {
+			t.Errorf("restoring a subscription of a deleted partner got %v, want ErrInvalidReference", err)
+		}
+
+		restored, err := stores.Partners.Restore(ctx, p.ID)
+		if err != nil || restored.DeletedAt != nil {
+			t.Fatalf("Restore got %+v, %v", restored, err)
+		}
+		// the cascade is undone, but what was deleted before it stays deleted
+		for _, id := range []int{active.ID, cancelled.ID} {
+			if _, err := stores.Subscriptions.Get(ctx, id); err != nil {
+				t.Errorf("subscription %d wasn't restored with its partner: %v", id, err)
+			}
+		}
+		if _, err := stores.Subscriptions.Get(ctx, earlier.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("restoring the partner brought back a subscription deleted on its own: %v", err)
+		}
+		if again, err := stores.Partners.Restore(ctx, p.ID); err != nil || again.Version != restored.Version {
+			t.Errorf("restoring a live partner got version %d, %v; want it unchanged at %d", again.Version, err, restored.Version)
+		}
+		if _, err := stores.Partners.Restore(ctx, 1000); !errors.Is(err, ErrNotFound) {
+			t.Errorf("restoring a missing partner got %v, want ErrNotFound", err)
+		}
+
+		// only subscriptions still running hold the partner back
+		q := createPartner(t, stores, "b")
+		ended := createSubscription(t, stores, q.ID)
+		if _, err := stores.Subscriptions.Transition(ctx, ended.ID, 0, change); err != nil {
+			t.Fatal(err)
+		}
+		if err := stores.Partners.Delete(ctx, q.ID, 0, false); err != nil {
+			t.Errorf("deleting a partner with only a cancelled subscription got %v", err)
+		}
+	})
+}